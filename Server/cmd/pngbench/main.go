@@ -0,0 +1,176 @@
+// pngbench compares the in process imageopt pipeline with the pngquant subprocess it replaced,
+// reporting output size and latency per image.
+//
+//	go run ./cmd/pngbench [-colors 256] [-quality 60] [-dither 0.5] [-size 140] [-runs 5] <png files or directories>
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"spartanreport/imageopt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+type result struct {
+	size    int
+	elapsed time.Duration // Average over the runs
+	err     error
+}
+
+func main() {
+	cfg := imageopt.DefaultConfig()
+	flag.IntVar(&cfg.Colors, "colors", cfg.Colors, "palette size, 2 to 256")
+	flag.IntVar(&cfg.MinQuality, "quality", cfg.MinQuality, "minimum quality, 0 to 100")
+	flag.Float64Var(&cfg.Dither, "dither", cfg.Dither, "dithering strength, 0 to 1")
+	size := flag.Int("size", 0, "resize to size x size first like the inventory does, 0 keeps the original size")
+	runs := flag.Int("runs", 5, "runs per image, latencies are averaged")
+	flag.Parse()
+
+	files, err := pngFiles(flag.Args())
+	if err != nil {
+		fmt.Println("Error finding images:", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		fmt.Println("usage: pngbench [flags] <png files or directories>")
+		os.Exit(2)
+	}
+	if _, err := exec.LookPath("pngquant"); err != nil {
+		fmt.Println("pngquant isn't installed, only imageopt is measured")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "image\tinput\tpngquant\tpngquant ms\timageopt\timageopt ms\t")
+	var totalInput, totalPngquant, totalImageopt int
+	var timePngquant, timeImageopt time.Duration
+	for _, file := range files {
+		img, input, err := loadImage(file, *size)
+		if err != nil {
+			fmt.Println("Error loading", file+":", err)
+			continue
+		}
+		quant := measure(*runs, func() ([]byte, error) { return pngquantPath(img, cfg) })
+		opt := measure(*runs, func() ([]byte, error) { return imageoptPath(img, cfg) })
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t\n", filepath.Base(file), input, sizeColumn(quant), msColumn(quant), sizeColumn(opt), msColumn(opt))
+
+		totalInput += input
+		if quant.err == nil {
+			totalPngquant += quant.size
+			timePngquant += quant.elapsed
+		}
+		if opt.err == nil {
+			totalImageopt += opt.size
+			timeImageopt += opt.elapsed
+		}
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%.1f\t%d\t%.1f\t\n", totalInput, totalPngquant, milliseconds(timePngquant), totalImageopt, milliseconds(timeImageopt))
+	w.Flush()
+}
+
+// pngFiles expands directories into the .png files directly inside them
+func pngFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.png"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+func loadImage(file string, size int) (image.Image, int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	if size > 0 {
+		img = imaging.Resize(img, size, size, imaging.Lanczos)
+	}
+	return img, len(data), nil
+}
+
+func measure(runs int, optimize func() ([]byte, error)) result {
+	var r result
+	start := time.Now()
+	for i := 0; i < runs; i++ {
+		out, err := optimize()
+		if err != nil {
+			return result{err: err}
+		}
+		r.size = len(out)
+	}
+	r.elapsed = time.Since(start) / time.Duration(runs)
+	return r
+}
+
+// pngquantPath is what compressPNGWithImaging used to do: encode with image/png and pipe it through pngquant
+func pngquantPath(img image.Image, cfg imageopt.Config) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("pngquant", "--quality="+strconv.Itoa(cfg.MinQuality)+"-80", "--speed", "1",
+		"--floyd="+strconv.FormatFloat(cfg.Dither, 'f', -1, 64), "--force", "--output", "-", strconv.Itoa(cfg.Colors), "-")
+	cmd.Stdin = &buf
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// imageoptPath is the pipeline compressPNGWithImaging runs now, full color when optimizing fails
+func imageoptPath(img image.Image, cfg imageopt.Config) ([]byte, error) {
+	out, err := imageopt.Optimize(img, cfg)
+	if err != nil {
+		return imageopt.EncodePNG(img)
+	}
+	return out, nil
+}
+
+// sizeColumn shows n/a without pngquant, and failed where pngquant gave up on the image (the old path dropped those)
+func sizeColumn(r result) string {
+	if errors.Is(r.err, exec.ErrNotFound) {
+		return "n/a"
+	} else if r.err != nil {
+		return "failed"
+	}
+	return strconv.Itoa(r.size)
+}
+
+func msColumn(r result) string {
+	if r.err != nil {
+		return "-"
+	}
+	return strconv.FormatFloat(milliseconds(r.elapsed), 'f', 1, 64)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -9,6 +9,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -18,6 +19,11 @@ func GetCollection(name string) *mongo.Collection {
 	return MongoClient.Database("halo_stats_db").Collection(name) // Ensure the database name is correct
 }
 
+// GetBucket returns the GridFS bucket with the given name
+func GetBucket(name string) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(MongoClient.Database("halo_stats_db"), options.GridFSBucket().SetName(name))
+}
+
 // Adjusted to return just the gamertag as a string.
 func GetGamerInfoByXUID(collectionName string, gamerXUID string) (string, error) {
 	collection := GetCollection(collectionName)
@@ -8,11 +8,11 @@ require (
 	github.com/gin-gonic/gin v1.9.1
 	github.com/go-redis/redis v6.15.9+incompatible
 	github.com/go-redis/redis/v8 v8.11.5
-	github.com/go-resty/resty/v2 v2.11.0
 	github.com/newrelic/go-agent/v3 v3.30.0
 	github.com/newrelic/go-agent/v3/integrations/nrgin v1.2.1
 	github.com/newrelic/go-agent/v3/integrations/nrmongo v1.1.3
 	go.mongodb.org/mongo-driver v1.14.0
+	golang.org/x/sync v0.1.0
 )
 
 require (
@@ -47,7 +47,6 @@ require (
 	golang.org/x/crypto v0.17.0 // indirect
 	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
 	golang.org/x/net v0.17.0 // indirect
-	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
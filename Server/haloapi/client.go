@@ -0,0 +1,291 @@
+// haloapi/client.go
+package haloapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Upstream Halo Waypoint services. Each one has its own base URL so it can be pointed at a local stand-in.
+const (
+	HaloStats = "halostats"
+	Economy   = "economy"
+	GameCMS   = "gamecms-hacs"
+	Discovery = "discovery-infiniteugc"
+	Profile   = "profile"
+	Settings  = "settings"
+	Skill     = "skill"
+)
+
+// defaultTimeout bounds every upstream call unless HALO_API_TIMEOUT says otherwise
+const defaultTimeout = 30 * time.Second
+
+var defaultBaseURLs = map[string]string{
+	HaloStats: "https://halostats.svc.halowaypoint.com",
+	Economy:   "https://economy.svc.halowaypoint.com",
+	GameCMS:   "https://gamecms-hacs.svc.halowaypoint.com",
+	Discovery: "https://discovery-infiniteugc.svc.halowaypoint.com",
+	Profile:   "https://profile.svc.halowaypoint.com",
+	Settings:  "https://settings.svc.halowaypoint.com",
+	Skill:     "https://skill.svc.halowaypoint.com",
+}
+
+// Credentials identify the player an upstream call is made for
+type Credentials struct {
+	SpartanToken  string
+	ClearanceCode string
+}
+
+// TokenRefresher is called when an upstream call is rejected with 401.
+// It returns a fresh Spartan token for the same player, or an error if none can be had.
+type TokenRefresher func(ctx context.Context, spartanToken string) (string, error)
+
+// Config holds everything needed to build a Client
+type Config struct {
+	BaseURLs       map[string]string // Service name -> base URL, missing services use the Halo Waypoint default
+	Timeout        time.Duration
+	RetryPolicies  map[string]RetryPolicy // Endpoint -> policy, overrides the built in endpointRetryPolicies
+	MaxConcurrency int                    // Upstream calls in flight across the server
+	MaxPerToken    int                    // Upstream calls in flight per Spartan token
+}
+
+// ConfigFromEnv reads base URL overrides from HALO_<SERVICE>_URL (e.g. HALO_GAMECMS_HACS_URL)
+// the request timeout from HALO_API_TIMEOUT (a Go duration, e.g. "20s")
+// and the concurrency limits from HALO_API_MAX_CONCURRENCY and HALO_API_MAX_PER_TOKEN
+func ConfigFromEnv() Config {
+	cfg := Config{BaseURLs: map[string]string{}, Timeout: defaultTimeout}
+	for service := range defaultBaseURLs {
+		envName := "HALO_" + strings.ToUpper(strings.ReplaceAll(service, "-", "_")) + "_URL"
+		if override := os.Getenv(envName); override != "" {
+			cfg.BaseURLs[service] = override
+		}
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("HALO_API_TIMEOUT")); err == nil && timeout > 0 {
+		cfg.Timeout = timeout
+	}
+	cfg.MaxConcurrency, _ = strconv.Atoi(os.Getenv("HALO_API_MAX_CONCURRENCY"))
+	cfg.MaxPerToken, _ = strconv.Atoi(os.Getenv("HALO_API_MAX_PER_TOKEN"))
+	return cfg
+}
+
+// Client makes every call to the Halo Waypoint services through one shared http.Client
+type Client struct {
+	httpClient    *http.Client
+	baseURLs      map[string]string
+	retryPolicies map[string]RetryPolicy
+	limiter       *Limiter
+	inflight      singleflight.Group // Coalesces concurrent fetches of the same public gamecms URL
+	refresher     TokenRefresher
+}
+
+// Default is the client used by the handlers, main can replace it or set its TokenRefresher
+var Default = NewClient(ConfigFromEnv())
+
+// NewClient builds a Client from cfg
+func NewClient(cfg Config) *Client {
+	baseURLs := make(map[string]string, len(defaultBaseURLs))
+	for service, baseURL := range defaultBaseURLs {
+		baseURLs[service] = baseURL
+	}
+	for service, baseURL := range cfg.BaseURLs {
+		baseURLs[service] = strings.TrimSuffix(baseURL, "/")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	retryPolicies := make(map[string]RetryPolicy, len(endpointRetryPolicies))
+	for endpoint, policy := range endpointRetryPolicies {
+		retryPolicies[endpoint] = policy
+	}
+	for endpoint, policy := range cfg.RetryPolicies {
+		retryPolicies[endpoint] = policy
+	}
+	return &Client{
+		httpClient:    &http.Client{Timeout: timeout},
+		baseURLs:      baseURLs,
+		retryPolicies: retryPolicies,
+		limiter:       NewLimiter(cfg.MaxConcurrency, cfg.MaxPerToken),
+	}
+}
+
+// SetTokenRefresher installs the hook used to retry a call once after a 401
+func (c *Client) SetTokenRefresher(refresher TokenRefresher) {
+	c.refresher = refresher
+}
+
+// BaseURL returns the configured base URL of a service
+func (c *Client) BaseURL(service string) string {
+	return c.baseURLs[service]
+}
+
+// StatusError is returned when an upstream service answers with a non-OK status
+type StatusError struct {
+	StatusCode int
+	Body       string
+	URL        string
+	RetryAfter time.Duration // Parsed Retry-After header, zero if the upstream didn't send one
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Received a non-OK status code %d. Response body: %s url: %s", e.StatusCode, e.Body, e.URL)
+}
+
+// IsStatus reports whether err is a StatusError with the given status code
+func IsStatus(err error, statusCode int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == statusCode
+}
+
+// request describes a single upstream call
+type request struct {
+	endpoint string // Names the call for retry policies and logging, e.g. "halostats.matchStats"
+	method   string
+	url      string
+	body     []byte
+	headers  map[string]string
+}
+
+// do sends req with creds and returns the response body.
+// A 401 is retried once with a refreshed token, other failures follow the endpoint's RetryPolicy.
+func (c *Client) do(ctx context.Context, creds Credentials, req request) ([]byte, error) {
+	policy := c.retryPolicy(req.endpoint)
+	refreshed := false
+	attempt := 1
+	for {
+		body, err := c.send(ctx, creds, req)
+		if err == nil {
+			return body, nil
+		}
+
+		if !refreshed && c.refresher != nil && creds.SpartanToken != "" && IsStatus(err, http.StatusUnauthorized) {
+			refreshed = true
+			newToken, refreshErr := c.refresher(ctx, creds.SpartanToken)
+			if refreshErr != nil {
+				fmt.Println("Could not refresh Spartan token after 401: ", refreshErr)
+				return nil, err
+			}
+			creds.SpartanToken = newToken
+			continue
+		}
+
+		if attempt >= policy.MaxAttempts || !policy.shouldRetry(ctx, err) {
+			return nil, err
+		}
+		delay, ok := policy.delay(attempt, err)
+		if !ok {
+			return nil, err
+		}
+		attempt++
+		fmt.Printf("Retrying %s (attempt %d of %d) in %s: %s\n", req.endpoint, attempt, policy.MaxAttempts, delay, retryReason(err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, creds Credentials, req request) ([]byte, error) {
+	var bodyReader io.Reader
+	if req.body != nil {
+		bodyReader = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, req.url, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %v", err)
+	}
+	if creds.SpartanToken != "" {
+		httpReq.Header.Set("X-343-Authorization-Spartan", creds.SpartanToken)
+	}
+	if creds.ClearanceCode != "" {
+		httpReq.Header.Set("343-clearance", creds.ClearanceCode)
+	}
+	if req.body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range req.headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	release, err := c.limiter.Acquire(ctx, creds.SpartanToken)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body), URL: req.url, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return body, nil
+}
+
+// getJSON GETs a URL and decodes the JSON response into out
+func (c *Client) getJSON(ctx context.Context, creds Credentials, endpoint, url string, out interface{}) error {
+	body, err := c.do(ctx, creds, request{endpoint: endpoint, method: http.MethodGet, url: url, headers: map[string]string{"Accept": "application/json"}})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("Failed to parse JSON response: %v", err)
+	}
+	return nil
+}
+
+// sendJSON sends payload as JSON with the given method and decodes the JSON response into out (if non-nil)
+func (c *Client) sendJSON(ctx context.Context, creds Credentials, endpoint, method, url string, payload, out interface{}) error {
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Error marshaling JSON: %v", err)
+	}
+	body, err := c.do(ctx, creds, request{endpoint: endpoint, method: method, url: url, body: jsonBody, headers: map[string]string{"Accept": "application/json"}})
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("Failed to parse JSON response: %v", err)
+	}
+	return nil
+}
+
+// getBytes GETs a URL and returns the raw response body, used for images
+func (c *Client) getBytes(ctx context.Context, creds Credentials, endpoint, url string) ([]byte, error) {
+	return c.do(ctx, creds, request{endpoint: endpoint, method: http.MethodGet, url: url})
+}
+
+// GetURLBytes fetches an absolute URL (e.g. a wpassets image) through the shared client
+func (c *Client) GetURLBytes(ctx context.Context, creds Credentials, url string) ([]byte, error) {
+	return c.getBytes(ctx, creds, "url", url)
+}
+
+// GetURLJSON fetches an absolute URL through the shared client and decodes the JSON response into out
+func (c *Client) GetURLJSON(ctx context.Context, creds Credentials, url string, out interface{}) error {
+	return c.getJSON(ctx, creds, "url", url, out)
+}
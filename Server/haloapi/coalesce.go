@@ -0,0 +1,81 @@
+// haloapi/coalesce.go
+package haloapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sharedFetch is what the coalesced fetch of a URL hands to every caller waiting on it
+type sharedFetch struct {
+	body         []byte
+	spartanToken string // The token of the caller whose fetch it was
+}
+
+// detachedContext carries its parent's values but never its deadline or cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)           { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}                 { return nil }
+func (detachedContext) Err() error                            { return nil }
+func (ctx detachedContext) Value(key interface{}) interface{} { return ctx.parent.Value(key) }
+
+// sharedContext is the context a coalesced fetch runs with. It keeps the first caller's values (the client IP
+// the limiter buckets anonymous calls by) and deadline, but not its cancellation, so that caller going away
+// doesn't fail everyone else. The http.Client timeout still bounds fetches without a deadline.
+func sharedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := detachedContext{parent: ctx}
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached, deadline)
+	}
+	return context.WithCancel(detached)
+}
+
+// getShared GETs a public gamecms URL, coalescing concurrent callers for the same URL into one upstream fetch.
+// Only a successful body is shared. A caller whose fetch was made with someone else's token that was refused,
+// or ran out of someone else's time, makes its own.
+// The returned bytes are shared between those callers and must not be modified.
+func (c *Client) getShared(ctx context.Context, creds Credentials, endpoint, url string) ([]byte, error) {
+	req := request{endpoint: endpoint, method: http.MethodGet, url: url}
+	results := c.inflight.DoChan(url, func() (interface{}, error) {
+		fetchCtx, cancel := sharedContext(ctx)
+		defer cancel()
+		body, err := c.do(fetchCtx, creds, req)
+		return sharedFetch{body: body, spartanToken: creds.SpartanToken}, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-results:
+		fetch := result.Val.(sharedFetch)
+		if result.Err == nil {
+			return fetch.body, nil
+		}
+		if fetch.spartanToken != creds.SpartanToken && (IsStatus(result.Err, http.StatusUnauthorized) || IsStatus(result.Err, http.StatusForbidden)) {
+			return c.do(ctx, creds, req)
+		}
+		if errors.Is(result.Err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return c.do(ctx, creds, req)
+		}
+		return nil, result.Err
+	}
+}
+
+// getSharedJSON is getShared for JSON files, each caller decodes its own copy into out
+func (c *Client) getSharedJSON(ctx context.Context, creds Credentials, endpoint, url string, out interface{}) error {
+	body, err := c.getShared(ctx, creds, endpoint, url)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("Failed to parse JSON response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package haloapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// joinWait is how long a test gives its callers to pile onto an in flight fetch
+const joinWait = 50 * time.Millisecond
+
+// gamecmsStub serves hi/images/file/ and holds the first request until release is closed.
+// Requests with the "expired" token are refused with 401.
+type gamecmsStub struct {
+	server   *httptest.Server
+	requests int32
+	arrived  chan struct{}
+	release  chan struct{}
+	tokens   chan string // Spartan token of every request in arrival order
+}
+
+func newGamecmsStub(t *testing.T) *gamecmsStub {
+	stub := &gamecmsStub{arrived: make(chan struct{}), release: make(chan struct{}), tokens: make(chan string, 16)}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-343-Authorization-Spartan")
+		stub.tokens <- token
+		if atomic.AddInt32(&stub.requests, 1) == 1 {
+			close(stub.arrived)
+			<-stub.release
+		}
+		if token == "expired" {
+			http.Error(w, "expired", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("image"))
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (stub *gamecmsStub) client() *Client {
+	return NewClient(Config{BaseURLs: map[string]string{GameCMS: stub.server.URL}})
+}
+
+func TestGetSharedCoalescesCallers(t *testing.T) {
+	stub := newGamecmsStub(t)
+	client := stub.client()
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	errs := make([]error, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, err := client.Image(context.Background(), Credentials{SpartanToken: "valid"}, "a.png")
+			bodies[i], errs[i] = string(body), err
+		}(i)
+	}
+	<-stub.arrived
+	time.Sleep(joinWait)
+	close(stub.release)
+	wg.Wait()
+
+	for i := range bodies {
+		if errs[i] != nil || bodies[i] != "image" {
+			t.Errorf("caller %d got %q, %v", i, bodies[i], errs[i])
+		}
+	}
+	if got := atomic.LoadInt32(&stub.requests); got != 1 {
+		t.Errorf("upstream saw %d requests, want 1", got)
+	}
+}
+
+func TestGetSharedRetriesWithOwnCredentials(t *testing.T) {
+	stub := newGamecmsStub(t)
+	client := stub.client()
+
+	expiredErr := make(chan error, 1)
+	go func() {
+		_, err := client.Image(context.Background(), Credentials{SpartanToken: "expired"}, "a.png")
+		expiredErr <- err
+	}()
+	<-stub.arrived
+
+	validBody := make(chan []byte, 1)
+	validErr := make(chan error, 1)
+	go func() {
+		body, err := client.Image(context.Background(), Credentials{SpartanToken: "valid"}, "a.png")
+		validBody <- body
+		validErr <- err
+	}()
+	time.Sleep(joinWait)
+	close(stub.release)
+
+	if err := <-expiredErr; !IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("caller with the expired token got %v, want a 401", err)
+	}
+	if body, err := <-validBody, <-validErr; err != nil || string(body) != "image" {
+		t.Errorf("caller with a valid token got %q, %v, want its own fetch to succeed", body, err)
+	}
+	// The valid caller waited on the expired fetch and then made its own
+	if first, second := <-stub.tokens, <-stub.tokens; first != "expired" || second != "valid" {
+		t.Errorf("upstream saw tokens %q then %q, want expired then valid", first, second)
+	}
+}
+
+func TestGetSharedSurvivesFirstCallerLeaving(t *testing.T) {
+	stub := newGamecmsStub(t)
+	client := stub.client()
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := client.Image(firstCtx, Credentials{SpartanToken: "valid"}, "a.png")
+		firstErr <- err
+	}()
+	<-stub.arrived
+
+	secondBody := make(chan []byte, 1)
+	go func() {
+		body, _ := client.Image(context.Background(), Credentials{SpartanToken: "valid"}, "a.png")
+		secondBody <- body
+	}()
+	time.Sleep(joinWait)
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller got %v, want context.Canceled", err)
+	}
+	close(stub.release)
+
+	if body := <-secondBody; string(body) != "image" {
+		t.Errorf("second caller got %q, want the shared fetch to finish for it", body)
+	}
+	if got := atomic.LoadInt32(&stub.requests); got != 1 {
+		t.Errorf("upstream saw %d requests, want 1", got)
+	}
+}
+
+func TestGetSharedRetriesAfterFirstCallersDeadline(t *testing.T) {
+	stub := newGamecmsStub(t)
+	client := stub.client()
+
+	firstCtx, cancelFirst := context.WithTimeout(context.Background(), 2*joinWait)
+	defer cancelFirst()
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := client.Image(firstCtx, Credentials{SpartanToken: "valid"}, "a.png")
+		firstErr <- err
+	}()
+	<-stub.arrived
+
+	secondBody := make(chan []byte, 1)
+	go func() {
+		body, _ := client.Image(context.Background(), Credentials{SpartanToken: "valid"}, "a.png")
+		secondBody <- body
+	}()
+	if err := <-firstErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("first caller got %v, want context.DeadlineExceeded", err)
+	}
+	close(stub.release)
+
+	if body := <-secondBody; string(body) != "image" {
+		t.Errorf("second caller got %q, want its own fetch once the shared one ran out of time", body)
+	}
+}
+
+func TestSharedContext(t *testing.T) {
+	parent, cancel := context.WithTimeout(WithClientIP(context.Background(), "203.0.113.7"), time.Hour)
+	shared, cancelShared := sharedContext(parent)
+	defer cancelShared()
+	cancel()
+
+	if shared.Err() != nil {
+		t.Errorf("shared context was cancelled with its parent: %v", shared.Err())
+	}
+	if deadline, ok := shared.Deadline(); !ok || time.Until(deadline) < 59*time.Minute {
+		t.Errorf("shared context deadline = %v, %v, want the parent's", deadline, ok)
+	}
+	if got := limiterKey(shared, ""); got != "ip:203.0.113.7" {
+		t.Errorf("limiter key = %q, want the parent's client IP", got)
+	}
+}
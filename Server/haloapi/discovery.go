@@ -0,0 +1,19 @@
+// haloapi/discovery.go
+package haloapi
+
+import (
+	"context"
+	"fmt"
+)
+
+// MapVersion returns a map's UGC details (public name, files)
+func (c *Client) MapVersion(ctx context.Context, creds Credentials, assetID, versionID string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/maps/%s/versions/%s", c.BaseURL(Discovery), assetID, versionID)
+	return c.getJSON(ctx, creds, "discovery.mapVersion", url, out)
+}
+
+// PlaylistVersion returns a playlist's UGC details
+func (c *Client) PlaylistVersion(ctx context.Context, creds Credentials, assetID, versionID string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/Playlists/%s/versions/%s", c.BaseURL(Discovery), assetID, versionID)
+	return c.getJSON(ctx, creds, "discovery.playlistVersion", url, out)
+}
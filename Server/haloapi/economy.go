@@ -0,0 +1,58 @@
+// haloapi/economy.go
+package haloapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// PlayerStore returns the main store as offered to a player
+func (c *Client) PlayerStore(ctx context.Context, creds Credentials, xuid string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/players/xuid(%s)/stores/Main", c.BaseURL(Economy), xuid)
+	return c.getJSON(ctx, creds, "economy.playerStore", url, out)
+}
+
+// PlayerInventory returns every item a player owns
+func (c *Client) PlayerInventory(ctx context.Context, creds Credentials, xuid string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/players/xuid(%s)/Inventory", c.BaseURL(Economy), xuid)
+	return c.getJSON(ctx, creds, "economy.playerInventory", url, out)
+}
+
+// PlayerCustomization returns what a player currently has equipped
+func (c *Client) PlayerCustomization(ctx context.Context, creds Credentials, xuid string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/customization?players=xuid(%s)", c.BaseURL(Economy), xuid)
+	return c.getJSON(ctx, creds, "economy.playerCustomization", url, out)
+}
+
+// OperationRewardTrack returns a player's progress through an operation
+func (c *Client) OperationRewardTrack(ctx context.Context, creds Credentials, xuid, operationID string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/players/xuid(%s)/rewardtracks/operations/%s", c.BaseURL(Economy), xuid, operationID)
+	return c.getJSON(ctx, creds, "economy.operationRewardTrack", url, out)
+}
+
+// EventRewardTrack returns a player's progress through an event
+func (c *Client) EventRewardTrack(ctx context.Context, creds Credentials, xuid, eventID string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/players/xuid(%s)/rewardtracks/events/%s", c.BaseURL(Economy), xuid, eventID)
+	return c.getJSON(ctx, creds, "economy.eventRewardTrack", url, out)
+}
+
+// CareerRankTrack returns a player's career rank progress
+func (c *Client) CareerRankTrack(ctx context.Context, creds Credentials, xuid string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/players/xuid(%s)/rewardtracks/careerranks/careerrank1", c.BaseURL(Economy), xuid)
+	return c.getJSON(ctx, creds, "economy.careerRankTrack", url, out)
+}
+
+func (c *Client) armorCoreURL(creds Credentials, xuid, coreID string) string {
+	return fmt.Sprintf("%s/hi/players/xuid(%s)/customization/armors/%s?flight=%s", c.BaseURL(Economy), xuid, coreID, creds.ClearanceCode)
+}
+
+// ArmorCoreCustomization returns how a player has an armor core customized
+func (c *Client) ArmorCoreCustomization(ctx context.Context, creds Credentials, xuid, coreID string, out interface{}) error {
+	return c.getJSON(ctx, creds, "economy.armorCoreCustomization", c.armorCoreURL(creds, xuid, coreID), out)
+}
+
+// PutArmorCoreCustomization equips customization on an armor core, the response is decoded into out if non-nil
+func (c *Client) PutArmorCoreCustomization(ctx context.Context, creds Credentials, xuid, coreID string, customization, out interface{}) error {
+	return c.sendJSON(ctx, creds, "economy.putArmorCoreCustomization", http.MethodPut, c.armorCoreURL(creds, xuid, coreID), customization, out)
+}
@@ -0,0 +1,34 @@
+// haloapi/gamecms.go
+package haloapi
+
+import (
+	"context"
+	"strings"
+)
+
+// gamecms files are the same for every player, so concurrent fetches of one URL are coalesced (see getShared)
+
+// ProgressionFile decodes a JSON file under hi/progression/file/ (items, challenges, seasons, reward tracks)
+func (c *Client) ProgressionFile(ctx context.Context, creds Credentials, path string, out interface{}) error {
+	return c.getSharedJSON(ctx, creds, "gamecms.progressionFile", c.BaseURL(GameCMS)+"/hi/progression/file/"+strings.TrimPrefix(path, "/"), out)
+}
+
+// WaypointFile decodes a JSON file under hi/Waypoint/file/ (emblem mappings, medals)
+func (c *Client) WaypointFile(ctx context.Context, creds Credentials, path string, out interface{}) error {
+	return c.getSharedJSON(ctx, creds, "gamecms.waypointFile", c.BaseURL(GameCMS)+"/hi/Waypoint/file/"+strings.TrimPrefix(path, "/"), out)
+}
+
+// WaypointFileBytes returns a raw file under hi/Waypoint/file/ (emblem and nameplate pngs), the returned bytes are shared and must not be modified
+func (c *Client) WaypointFileBytes(ctx context.Context, creds Credentials, path string) ([]byte, error) {
+	return c.getShared(ctx, creds, "gamecms.waypointFileBytes", c.BaseURL(GameCMS)+"/hi/Waypoint/file/"+strings.TrimPrefix(path, "/"))
+}
+
+// Image returns a raw image under hi/images/file/, the returned bytes are shared and must not be modified
+func (c *Client) Image(ctx context.Context, creds Credentials, path string) ([]byte, error) {
+	return c.getShared(ctx, creds, "gamecms.image", c.BaseURL(GameCMS)+"/hi/images/file/"+strings.TrimPrefix(path, "/"))
+}
+
+// CMSFile returns any gamecms file by its path relative to the service root (e.g. hi/images/file/...), the returned bytes are shared and must not be modified
+func (c *Client) CMSFile(ctx context.Context, creds Credentials, path string) ([]byte, error) {
+	return c.getShared(ctx, creds, "gamecms.cmsFile", c.BaseURL(GameCMS)+"/"+strings.TrimPrefix(path, "/"))
+}
@@ -0,0 +1,31 @@
+// haloapi/halostats.go
+package haloapi
+
+import (
+	"context"
+	"fmt"
+)
+
+// PlayerMatches returns one page of a player's match history
+func (c *Client) PlayerMatches(ctx context.Context, creds Credentials, xuid string, start, count int, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/players/xuid(%s)/matches?start=%d&count=%d", c.BaseURL(HaloStats), xuid, start, count)
+	return c.getJSON(ctx, creds, "halostats.playerMatches", url, out)
+}
+
+// PlayerMatchCount returns how many matches a player has played
+func (c *Client) PlayerMatchCount(ctx context.Context, creds Credentials, xuid string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/players/xuid(%s)/matches/count", c.BaseURL(HaloStats), xuid)
+	return c.getJSON(ctx, creds, "halostats.playerMatchCount", url, out)
+}
+
+// MatchStats returns the full stats of a single match
+func (c *Client) MatchStats(ctx context.Context, creds Credentials, matchID string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/matches/%s/stats", c.BaseURL(HaloStats), matchID)
+	return c.getJSON(ctx, creds, "halostats.matchStats", url, out)
+}
+
+// PlayerChallengeDecks returns a player's active challenge decks
+func (c *Client) PlayerChallengeDecks(ctx context.Context, creds Credentials, xuid string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/players/xuid(%s)/decks", c.BaseURL(HaloStats), xuid)
+	return c.getJSON(ctx, creds, "halostats.playerChallengeDecks", url, out)
+}
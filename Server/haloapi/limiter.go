@@ -0,0 +1,179 @@
+// haloapi/limiter.go
+package haloapi
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Default concurrency limits, overridable with HALO_API_MAX_CONCURRENCY and HALO_API_MAX_PER_TOKEN
+const (
+	defaultMaxConcurrency = 64
+	defaultMaxPerToken    = 16
+)
+
+// Limiter bounds how many upstream calls are in flight, across the whole server and per Spartan token,
+// so one player's progression sync can't flood halostats or starve everyone else
+type Limiter struct {
+	global      chan struct{}
+	maxPerToken int
+
+	mu     sync.Mutex
+	tokens map[string]*tokenSlots
+
+	queued   int64
+	inFlight int64
+}
+
+// clientIPKey carries the IP of the browser an anonymous call is made for
+type clientIPKey struct{}
+
+// WithClientIP tags ctx with the IP of the signed out browser calls are made for, so they get a per token
+// bucket of their own instead of sharing one with every other anonymous caller
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// limiterKey is the bucket a call waits on: the Spartan token, or the client IP of anonymous calls.
+// Anonymous calls the server makes on its own share the "" bucket.
+func limiterKey(ctx context.Context, spartanToken string) string {
+	if spartanToken != "" {
+		return spartanToken
+	}
+	if ip, _ := ctx.Value(clientIPKey{}).(string); ip != "" {
+		return "ip:" + ip
+	}
+	return ""
+}
+
+type tokenSlots struct {
+	slots chan struct{}
+	users int
+}
+
+// LimiterStats is a snapshot of the limiter, served by the upstream stats endpoint
+type LimiterStats struct {
+	Queued         int64 `json:"queued"`
+	InFlight       int64 `json:"inFlight"`
+	ActiveTokens   int   `json:"activeTokens"`
+	MaxConcurrency int   `json:"maxConcurrency"`
+	MaxPerToken    int   `json:"maxPerToken"`
+}
+
+// NewLimiter builds a Limiter, non-positive limits fall back to the defaults
+func NewLimiter(maxConcurrency, maxPerToken int) *Limiter {
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMaxConcurrency
+	}
+	if maxPerToken <= 0 {
+		maxPerToken = defaultMaxPerToken
+	}
+	return &Limiter{
+		global:      make(chan struct{}, maxConcurrency),
+		maxPerToken: maxPerToken,
+		tokens:      make(map[string]*tokenSlots),
+	}
+}
+
+// Acquire waits for a per token slot and then a global slot. The returned func releases both.
+// Calls without a token are limited per client IP, see WithClientIP.
+func (l *Limiter) Acquire(ctx context.Context, spartanToken string) (func(), error) {
+	atomic.AddInt64(&l.queued, 1)
+	defer atomic.AddInt64(&l.queued, -1)
+
+	spartanToken = limiterKey(ctx, spartanToken)
+	token := l.tokenSlots(spartanToken)
+	select {
+	case token.slots <- struct{}{}:
+	case <-ctx.Done():
+		l.releaseToken(spartanToken, token)
+		return nil, ctx.Err()
+	}
+	select {
+	case l.global <- struct{}{}:
+	case <-ctx.Done():
+		<-token.slots
+		l.releaseToken(spartanToken, token)
+		return nil, ctx.Err()
+	}
+
+	atomic.AddInt64(&l.inFlight, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&l.inFlight, -1)
+			<-l.global
+			<-token.slots
+			l.releaseToken(spartanToken, token)
+		})
+	}, nil
+}
+
+func (l *Limiter) tokenSlots(spartanToken string) *tokenSlots {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	token, ok := l.tokens[spartanToken]
+	if !ok {
+		token = &tokenSlots{slots: make(chan struct{}, l.maxPerToken)}
+		l.tokens[spartanToken] = token
+	}
+	token.users++
+	return token
+}
+
+// releaseToken forgets a token once nothing is waiting on or holding its slots
+func (l *Limiter) releaseToken(spartanToken string, token *tokenSlots) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	token.users--
+	if token.users == 0 {
+		delete(l.tokens, spartanToken)
+	}
+}
+
+// Stats returns the current queue depth and in flight count
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	activeTokens := len(l.tokens)
+	l.mu.Unlock()
+	return LimiterStats{
+		Queued:         atomic.LoadInt64(&l.queued),
+		InFlight:       atomic.LoadInt64(&l.inFlight),
+		ActiveTokens:   activeTokens,
+		MaxConcurrency: cap(l.global),
+		MaxPerToken:    l.maxPerToken,
+	}
+}
+
+// FanOut calls fn for every index in [0, count) on a bounded set of workers and waits for them all.
+// Fan-out sites use it instead of a goroutine per item, the worker count matches the per token limit
+// since each worker's upstream calls would queue on it anyway.
+func (c *Client) FanOut(count int, fn func(i int)) {
+	workers := c.limiter.maxPerToken
+	if count < workers {
+		workers = count
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// Stats returns the client's limiter stats
+func (c *Client) Stats() LimiterStats {
+	return c.limiter.Stats()
+}
@@ -0,0 +1,181 @@
+package haloapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyStub holds every request for a moment and records the most seen in flight at once,
+// overall and per Spartan token (or X-Test-Bucket for anonymous calls)
+type concurrencyStub struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	inFlight  int
+	maxTotal  int
+	perBucket map[string]int
+	maxBucket map[string]int
+}
+
+func newConcurrencyStub(t *testing.T) *concurrencyStub {
+	stub := &concurrencyStub{perBucket: map[string]int{}, maxBucket: map[string]int{}}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket := r.Header.Get("X-343-Authorization-Spartan") + r.URL.Query().Get("bucket")
+		stub.mu.Lock()
+		stub.inFlight++
+		stub.perBucket[bucket]++
+		if stub.inFlight > stub.maxTotal {
+			stub.maxTotal = stub.inFlight
+		}
+		if stub.perBucket[bucket] > stub.maxBucket[bucket] {
+			stub.maxBucket[bucket] = stub.perBucket[bucket]
+		}
+		stub.mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		stub.mu.Lock()
+		stub.inFlight--
+		stub.perBucket[bucket]--
+		stub.mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func TestLimiterBoundsFanOutPerToken(t *testing.T) {
+	stub := newConcurrencyStub(t)
+	client := NewClient(Config{MaxConcurrency: 64, MaxPerToken: 3})
+
+	var calls int32
+	seen := make([]int32, 30)
+	client.FanOut(len(seen), func(i int) {
+		atomic.AddInt32(&calls, 1)
+		atomic.AddInt32(&seen[i], 1)
+		if _, err := client.GetURLBytes(context.Background(), Credentials{SpartanToken: "player"}, stub.server.URL); err != nil {
+			t.Error(err)
+		}
+	})
+
+	for i, n := range seen {
+		if n != 1 {
+			t.Errorf("FanOut called index %d %d times, want once", i, n)
+		}
+	}
+	if stub.maxBucket["player"] > 3 {
+		t.Errorf("%d calls of one token in flight at once, want at most 3", stub.maxBucket["player"])
+	}
+	if stats := client.Stats(); stats.InFlight != 0 || stats.Queued != 0 || stats.ActiveTokens != 0 {
+		t.Errorf("stats after the fan-out = %+v, want nothing left", stats)
+	}
+}
+
+func TestLimiterBoundsConcurrentFanOuts(t *testing.T) {
+	stub := newConcurrencyStub(t)
+	client := NewClient(Config{MaxConcurrency: 5, MaxPerToken: 3})
+
+	// Four players syncing at once, each with its own fan-out
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			creds := Credentials{SpartanToken: fmt.Sprintf("player-%d", p)}
+			client.FanOut(12, func(i int) {
+				client.GetURLBytes(context.Background(), creds, stub.server.URL)
+			})
+		}(p)
+	}
+	wg.Wait()
+
+	if stub.maxTotal > 5 {
+		t.Errorf("%d calls in flight at once, want at most 5", stub.maxTotal)
+	}
+	for bucket, max := range stub.maxBucket {
+		if max > 3 {
+			t.Errorf("%d calls of %s in flight at once, want at most 3", max, bucket)
+		}
+	}
+}
+
+func TestLimiterBucketsAnonymousCallsByClientIP(t *testing.T) {
+	stub := newConcurrencyStub(t)
+	client := NewClient(Config{MaxConcurrency: 64, MaxPerToken: 2})
+
+	// Two visitors behind different IPs, each firing more calls than its bucket holds.
+	// The bucket query parameter tells the stub whose call it is.
+	var wg sync.WaitGroup
+	for _, ip := range []string{"203.0.113.7", "198.51.100.2"} {
+		ctx := WithClientIP(context.Background(), ip)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(ip string) {
+				defer wg.Done()
+				client.GetURLBytes(ctx, Credentials{}, stub.server.URL+"?bucket="+ip)
+			}(ip)
+		}
+	}
+	wg.Wait()
+
+	for _, ip := range []string{"203.0.113.7", "198.51.100.2"} {
+		if max := stub.maxBucket[ip]; max > 2 {
+			t.Errorf("%d calls from %s in flight at once, want at most 2", max, ip)
+		}
+	}
+	// Separate buckets, so both visitors were served side by side
+	if stub.maxTotal < 3 {
+		t.Errorf("at most %d calls in flight at once, want the two IPs to have separate buckets", stub.maxTotal)
+	}
+}
+
+func TestLimiterAcquireGivesUpWithContext(t *testing.T) {
+	limiter := NewLimiter(1, 1)
+	release, err := limiter.Acquire(context.Background(), "player")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(ctx, "player"); err != context.DeadlineExceeded {
+		t.Errorf("Acquire on a full bucket = %v, want context.DeadlineExceeded", err)
+	}
+	if _, err := limiter.Acquire(ctx, "other player"); err != context.DeadlineExceeded {
+		t.Errorf("Acquire with the global slot taken = %v, want context.DeadlineExceeded", err)
+	}
+	if stats := limiter.Stats(); stats.InFlight != 1 || stats.Queued != 0 || stats.ActiveTokens != 1 {
+		t.Errorf("stats = %+v, want the one held slot", stats)
+	}
+
+	release()
+	release() // Releasing twice is harmless
+	if stats := limiter.Stats(); stats.InFlight != 0 || stats.ActiveTokens != 0 {
+		t.Errorf("stats after release = %+v, want nothing held", stats)
+	}
+}
+
+func TestLimiterKey(t *testing.T) {
+	anonymous := WithClientIP(context.Background(), "203.0.113.7")
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		token string
+		want  string
+	}{
+		{"signed in", anonymous, "token", "token"},
+		{"signed out visitor", anonymous, "", "ip:203.0.113.7"},
+		{"server's own calls", context.Background(), "", ""},
+	}
+	for _, tt := range tests {
+		if got := limiterKey(tt.ctx, tt.token); got != tt.want {
+			t.Errorf("%s: limiterKey = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// haloapi/profile.go
+package haloapi
+
+import (
+	"context"
+	"strings"
+)
+
+// Me returns the profile of the player the Spartan token belongs to
+func (c *Client) Me(ctx context.Context, creds Credentials, out interface{}) error {
+	return c.getJSON(ctx, creds, "profile.me", c.BaseURL(Profile)+"/users/me", out)
+}
+
+// Users returns the profiles (gamertag, gamerpic) of a batch of players
+func (c *Client) Users(ctx context.Context, creds Credentials, xuids []string, out interface{}) error {
+	return c.getJSON(ctx, creds, "profile.users", c.BaseURL(Profile)+"/users?xuids="+strings.Join(xuids, ","), out)
+}
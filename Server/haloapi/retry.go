@@ -0,0 +1,110 @@
+// haloapi/retry.go
+package haloapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryAfter is the longest Retry-After we'll wait out, anything longer fails the call instead of hanging the request
+const maxRetryAfter = 30 * time.Second
+
+// RetryPolicy controls how an endpoint is retried after a 429, a 5xx or a transport error
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts including the first, 1 disables retries
+	BaseDelay   time.Duration // Backoff before the first retry, doubled on every attempt
+	MaxDelay    time.Duration // Cap on the backoff
+	Idempotent  bool          // Non-idempotent calls (PUT/POST) are only retried on 429, where the upstream rejected the call outright
+}
+
+// defaultRetryPolicy covers every GET without an entry in endpointRetryPolicies
+var defaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 250 * time.Millisecond, MaxDelay: 4 * time.Second, Idempotent: true}
+
+// endpointRetryPolicies are the per endpoint limits. The match history fan-outs in SyncMatchHistory
+// and the item lookups in FetchInventoryItems make hundreds of calls, so they get a longer budget.
+var endpointRetryPolicies = map[string]RetryPolicy{
+	"halostats.playerMatches":           {MaxAttempts: 5, BaseDelay: 500 * time.Millisecond, MaxDelay: 8 * time.Second, Idempotent: true},
+	"halostats.matchStats":              {MaxAttempts: 5, BaseDelay: 500 * time.Millisecond, MaxDelay: 8 * time.Second, Idempotent: true},
+	"skill.matchSkill":                  {MaxAttempts: 4, BaseDelay: 500 * time.Millisecond, MaxDelay: 8 * time.Second, Idempotent: true},
+	"discovery.playlistVersion":         {MaxAttempts: 4, BaseDelay: 250 * time.Millisecond, MaxDelay: 4 * time.Second, Idempotent: true},
+	"discovery.mapVersion":              {MaxAttempts: 4, BaseDelay: 250 * time.Millisecond, MaxDelay: 4 * time.Second, Idempotent: true},
+	"gamecms.progressionFile":           {MaxAttempts: 4, BaseDelay: 250 * time.Millisecond, MaxDelay: 4 * time.Second, Idempotent: true},
+	"gamecms.image":                     {MaxAttempts: 4, BaseDelay: 250 * time.Millisecond, MaxDelay: 4 * time.Second, Idempotent: true},
+	"economy.putArmorCoreCustomization": {MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 4 * time.Second, Idempotent: false},
+	"settings.spartanToken":             {MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 4 * time.Second, Idempotent: false},
+}
+
+func (c *Client) retryPolicy(endpoint string) RetryPolicy {
+	if policy, ok := c.retryPolicies[endpoint]; ok {
+		return policy
+	}
+	return defaultRetryPolicy
+}
+
+// shouldRetry reports whether err is worth another attempt under this policy
+func (policy RetryPolicy) shouldRetry(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		// Transport error (timeout, connection reset), the upstream may or may not have seen the call
+		return policy.Idempotent
+	}
+	switch statusErr.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return policy.Idempotent
+	}
+	return false
+}
+
+// delay returns how long to wait before the retry following attempt.
+// Retry-After wins when the upstream sends one, otherwise it's full jitter exponential backoff.
+func (policy RetryPolicy) delay(attempt int, err error) (time.Duration, bool) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > maxRetryAfter {
+			return 0, false
+		}
+		return statusErr.RetryAfter, true
+	}
+
+	backoff := policy.BaseDelay << uint(attempt-1)
+	if backoff <= 0 || backoff > policy.MaxDelay {
+		backoff = policy.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1), true
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if retryAt, err := http.ParseTime(header); err == nil {
+		return time.Until(retryAt)
+	}
+	return 0
+}
+
+// retryReason is the short form of err used in retry logs, the full body can be huge
+func retryReason(err error) string {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return fmt.Sprintf("status %d", statusErr.StatusCode)
+	}
+	return err.Error()
+}
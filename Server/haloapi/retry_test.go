@@ -0,0 +1,189 @@
+package haloapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusSequence answers with the given statuses in order, then 200, and counts the requests it saw
+func statusSequence(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		if n <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// fastRetries keeps the backoff of the "url" endpoint short enough for tests
+func fastRetries(maxAttempts int, idempotent bool) Config {
+	return Config{RetryPolicies: map[string]RetryPolicy{
+		"url": {MaxAttempts: maxAttempts, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Idempotent: idempotent},
+	}}
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	server, requests := statusSequence(t, "1", http.StatusTooManyRequests)
+	client := NewClient(fastRetries(3, true))
+
+	start := time.Now()
+	body, err := client.GetURLBytes(context.Background(), Credentials{}, server.URL)
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("GetURLBytes = %q, %v", body, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want the 1s Retry-After", elapsed)
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("upstream saw %d requests, want 2", got)
+	}
+}
+
+func TestRetryGivesUpOnLongRetryAfter(t *testing.T) {
+	server, requests := statusSequence(t, "120", http.StatusTooManyRequests)
+	client := NewClient(fastRetries(3, true))
+
+	if _, err := client.GetURLBytes(context.Background(), Credentials{}, server.URL); !IsStatus(err, http.StatusTooManyRequests) {
+		t.Errorf("GetURLBytes = %v, want the 429", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("upstream saw %d requests, want 1", got)
+	}
+}
+
+func TestRetryStopsAtMaxAttempts(t *testing.T) {
+	server, requests := statusSequence(t, "", http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	client := NewClient(fastRetries(3, true))
+
+	if _, err := client.GetURLBytes(context.Background(), Credentials{}, server.URL); !IsStatus(err, http.StatusServiceUnavailable) {
+		t.Errorf("GetURLBytes = %v, want the last 503", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("upstream saw %d requests, want 3", got)
+	}
+}
+
+func TestRetryWhichStatuses(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		idempotent   bool
+		wantRequests int32
+	}{
+		{"5xx retried on idempotent calls", http.StatusBadGateway, true, 2},
+		{"5xx not retried on non-idempotent calls", http.StatusBadGateway, false, 1},
+		{"429 retried on non-idempotent calls", http.StatusTooManyRequests, false, 2},
+		{"404 never retried", http.StatusNotFound, true, 1},
+		{"400 never retried", http.StatusBadRequest, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := statusSequence(t, "", tt.status)
+			client := NewClient(fastRetries(3, tt.idempotent))
+			client.GetURLBytes(context.Background(), Credentials{}, server.URL)
+			if got := atomic.LoadInt32(requests); got != tt.wantRequests {
+				t.Errorf("upstream saw %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryRefreshesTokenOnceAfter401(t *testing.T) {
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-343-Authorization-Spartan")
+		tokens = append(tokens, token)
+		if token != "fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := NewClient(fastRetries(3, true))
+	client.SetTokenRefresher(func(ctx context.Context, spartanToken string) (string, error) {
+		return "fresh", nil
+	})
+	if body, err := client.GetURLBytes(context.Background(), Credentials{SpartanToken: "stale"}, server.URL); err != nil || string(body) != "ok" {
+		t.Fatalf("GetURLBytes = %q, %v", body, err)
+	}
+	if len(tokens) != 2 || tokens[0] != "stale" || tokens[1] != "fresh" {
+		t.Errorf("upstream saw tokens %v, want stale then fresh", tokens)
+	}
+
+	// A refreshed token that's refused too isn't refreshed again
+	tokens = nil
+	client.SetTokenRefresher(func(ctx context.Context, spartanToken string) (string, error) {
+		return "also stale", nil
+	})
+	if _, err := client.GetURLBytes(context.Background(), Credentials{SpartanToken: "stale"}, server.URL); !IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("GetURLBytes = %v, want the 401", err)
+	}
+	if len(tokens) != 2 {
+		t.Errorf("upstream saw %d requests, want 2", len(tokens))
+	}
+}
+
+func TestRetryStopsWhenContextEnds(t *testing.T) {
+	server, requests := statusSequence(t, "5", http.StatusTooManyRequests)
+	client := NewClient(fastRetries(3, true))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetURLBytes(ctx, Credentials{}, server.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetURLBytes = %v, want context.DeadlineExceeded while waiting out Retry-After", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("upstream saw %d requests, want 1", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	inAMinute := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		header   string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"3", 3 * time.Second, 3 * time.Second},
+		{"0", 0, 0},
+		{inAMinute, 58 * time.Second, time.Minute},
+		{"soon", 0, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %s, want between %s and %s", tt.header, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestRetryDelayBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: 400 * time.Millisecond}
+	for attempt, ceiling := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 8: 400 * time.Millisecond} {
+		for i := 0; i < 50; i++ {
+			delay, ok := policy.delay(attempt, errors.New("connection reset"))
+			if !ok || delay <= 0 || delay > ceiling {
+				t.Fatalf("delay(%d) = %s, %v, want within (0, %s]", attempt, delay, ok, ceiling)
+			}
+		}
+	}
+
+	retryAfter := &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 2 * time.Second}
+	if delay, ok := policy.delay(1, retryAfter); !ok || delay != 2*time.Second {
+		t.Errorf("delay with Retry-After = %s, %v, want 2s", delay, ok)
+	}
+}
@@ -0,0 +1,37 @@
+// haloapi/settings.go
+package haloapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// SpartanToken trades a token request (XSTS proof) for a Spartan token, no credentials are needed
+func (c *Client) SpartanToken(ctx context.Context, tokenRequest, out interface{}) error {
+	jsonBody, err := json.Marshal(tokenRequest)
+	if err != nil {
+		return fmt.Errorf("Error marshaling JSON: %v", err)
+	}
+	body, err := c.do(ctx, Credentials{}, request{
+		endpoint: "settings.spartanToken",
+		method:   http.MethodPost,
+		url:      c.BaseURL(Settings) + "/spartan-token",
+		body:     jsonBody,
+		headers:  map[string]string{"User-Agent": "HALO_WAYPOINT_USER_AGENT"},
+	})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("Failed to parse JSON response: %v", err)
+	}
+	return nil
+}
+
+// FlightConfiguration returns the player's active flight, its id is the clearance code
+func (c *Client) FlightConfiguration(ctx context.Context, creds Credentials, xuid string, out interface{}) error {
+	url := fmt.Sprintf("%s/oban/flight-configurations/titles/hi/audiences/RETAIL/players/xuid(%s)/active?sandbox=UNUSED&build=210921.22.01.10.1706-0", c.BaseURL(Settings), xuid)
+	return c.getJSON(ctx, creds, "settings.flightConfiguration", url, out)
+}
@@ -0,0 +1,34 @@
+// haloapi/skill.go
+package haloapi
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+func playersQuery(xuids []string) string {
+	players := make([]string, len(xuids))
+	for i, xuid := range xuids {
+		players[i] = "players=xuid(" + xuid + ")"
+	}
+	return strings.Join(players, "&")
+}
+
+// PlaylistCSR returns the current, season high and all time high CSR of players in a ranked playlist.
+// season is the season's CsrSeasonFilePath, empty means the current season.
+func (c *Client) PlaylistCSR(ctx context.Context, creds Credentials, playlistID string, xuids []string, season string, out interface{}) error {
+	query := playersQuery(xuids)
+	if season != "" {
+		query += "&season=" + url.QueryEscape(season)
+	}
+	url := fmt.Sprintf("%s/hi/playlist/%s/csrs?%s", c.BaseURL(Skill), playlistID, query)
+	return c.getJSON(ctx, creds, "skill.playlistCsr", url, out)
+}
+
+// MatchSkill returns the skill results of players in a match, ranked matches carry the pre and post match CSR
+func (c *Client) MatchSkill(ctx context.Context, creds Credentials, matchID string, xuids []string, out interface{}) error {
+	url := fmt.Sprintf("%s/hi/matches/%s/skill?%s", c.BaseURL(Skill), matchID, playersQuery(xuids))
+	return c.getJSON(ctx, creds, "skill.matchSkill", url, out)
+}
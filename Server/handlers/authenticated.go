@@ -1,17 +1,15 @@
 package spartanreport
 
 import (
-	"fmt"
 	"net/http"
-	requests "spartanreport/requests"
 
 	"github.com/gin-gonic/gin"
 )
 
+// HandleAuthenticated lets the front end ask whether its session cookie is still valid
 func HandleAuthenticated(c *gin.Context) {
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		fmt.Println("Error binding JSON:", err)
+	gamerInfo := GetSessionGamerInfo(c)
+	if gamerInfo.XUID == "" {
 		c.JSON(http.StatusOK, gin.H{
 			"GamerInfo": nil,
 			"IsNew":     false,
@@ -19,6 +17,8 @@ func HandleAuthenticated(c *gin.Context) {
 		return
 	}
 
-	return
-
+	c.JSON(http.StatusOK, gin.H{
+		"GamerInfo": gamerInfo.WithoutTokens(),
+		"IsNew":     false,
+	})
 }
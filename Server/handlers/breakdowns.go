@@ -0,0 +1,195 @@
+package spartanreport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"spartanreport/db"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// defaultBreakdownMinMatches hides groups with too few matches for their averages to mean much
+const defaultBreakdownMinMatches = 5
+
+// PerformanceBreakdown is a player's record over one map, one mode or one map and mode pair.
+// DNFs count as played and not won.
+type PerformanceBreakdown struct {
+	MapId                string  `bson:"mapId" json:"MapId,omitempty"`
+	MapName              string  `bson:"mapName" json:"MapName,omitempty"`
+	GameVariantCategory  *int    `bson:"gameVariantCategory" json:"GameVariantCategory,omitempty"`
+	Matches              int     `bson:"matches" json:"Matches"`
+	Wins                 int     `bson:"wins" json:"Wins"`
+	WinRate              float64 `bson:"winRate" json:"WinRate"`
+	AverageKDA           float64 `bson:"averageKDA" json:"AverageKDA"`
+	AverageKills         float64 `bson:"averageKills" json:"AverageKills"`
+	AverageDeaths        float64 `bson:"averageDeaths" json:"AverageDeaths"`
+	AverageAssists       float64 `bson:"averageAssists" json:"AverageAssists"`
+	AveragePersonalScore float64 `bson:"averagePersonalScore" json:"AveragePersonalScore"`
+}
+
+// PerformanceBreakdowns is the response of GET /players/:xuid/breakdowns, each list sorted by matches played
+type PerformanceBreakdowns struct {
+	Maps       []PerformanceBreakdown `bson:"maps"`
+	Modes      []PerformanceBreakdown `bson:"modes"`
+	MapModes   []PerformanceBreakdown `bson:"mapModes"`
+	MinMatches int                    `bson:"-"`
+}
+
+// playerMatchRow is one match of playerMatchRows
+type playerMatchRow struct {
+	MatchId       string  `bson:"matchId"`
+	StartTime     string  `bson:"startTime"`
+	EndTime       string  `bson:"endTime"`
+	PlaylistId    string  `bson:"playlistId"`
+	PlaylistName  string  `bson:"playlistName"`
+	Outcome       int     `bson:"outcome"`
+	KDA           float64 `bson:"kda"`
+	Kills         float64 `bson:"kills"`
+	Deaths        float64 `bson:"deaths"`
+	Assists       float64 `bson:"assists"`
+	PersonalScore float64 `bson:"personalScore"`
+}
+
+// playerMatchRows matches filter and reduces each match to one row of the player's stats
+func playerMatchRows(filter bson.M, xuid string) mongo.Pipeline {
+	playerID := "xuid(" + xuid + ")"
+	coreStats := "$player.playerteamstats.stats.corestats."
+	return mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$project", Value: bson.M{
+			"matchId":             "$MatchId",
+			"startTime":           "$matchinfo.starttime",
+			"endTime":             "$matchinfo.endtime",
+			"playlistId":          "$matchinfo.playlist.assetid",
+			"playlistName":        "$matchinfo.playlistinfo.publicname",
+			"mapId":               "$matchinfo.mapvariant.assetid",
+			"mapName":             "$matchinfo.publicname",
+			"gameVariantCategory": "$matchinfo.gamevariantcategory",
+			"player": bson.M{"$arrayElemAt": bson.A{
+				bson.M{"$filter": bson.M{
+					"input": "$players",
+					"as":    "player",
+					"cond":  bson.M{"$eq": bson.A{"$$player.playerid", playerID}},
+				}},
+				0,
+			}},
+		}}},
+		// A player who switched teams has stats for each, the totals are summed
+		{{Key: "$project", Value: bson.M{
+			"matchId":             1,
+			"startTime":           1,
+			"endTime":             1,
+			"playlistId":          1,
+			"playlistName":        1,
+			"mapId":               1,
+			"mapName":             1,
+			"gameVariantCategory": 1,
+			"outcome":             "$player.outcome",
+			"kda":                 bson.M{"$avg": coreStats + "kda"},
+			"kills":               bson.M{"$sum": coreStats + "kills"},
+			"deaths":              bson.M{"$sum": coreStats + "deaths"},
+			"assists":             bson.M{"$sum": coreStats + "assists"},
+			"personalScore":       bson.M{"$sum": coreStats + "personalscore"},
+		}}},
+	}
+}
+
+// breakdownFacet groups the per match rows by key and averages the player's stats
+func breakdownFacet(key bson.M, minMatches int) bson.A {
+	group := bson.M{
+		"_id":                  key,
+		"matches":              bson.M{"$sum": 1},
+		"wins":                 bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$outcome", matchOutcomes["win"]}}, 1, 0}}},
+		"averageKDA":           bson.M{"$avg": "$kda"},
+		"averageKills":         bson.M{"$avg": "$kills"},
+		"averageDeaths":        bson.M{"$avg": "$deaths"},
+		"averageAssists":       bson.M{"$avg": "$assists"},
+		"averagePersonalScore": bson.M{"$avg": "$personalScore"},
+	}
+	if _, byMap := key["mapId"]; byMap {
+		group["mapName"] = bson.M{"$last": "$mapName"}
+	}
+	return bson.A{
+		bson.M{"$group": group},
+		bson.M{"$match": bson.M{"matches": bson.M{"$gte": minMatches}}},
+		bson.M{"$addFields": bson.M{
+			"mapId":               "$_id.mapId",
+			"gameVariantCategory": "$_id.gameVariantCategory",
+			"winRate":             bson.M{"$divide": bson.A{"$wins", "$matches"}},
+		}},
+		bson.M{"$sort": bson.D{{Key: "matches", Value: -1}, {Key: "_id.mapId", Value: 1}, {Key: "_id.gameVariantCategory", Value: 1}}},
+	}
+}
+
+// GetPerformanceBreakdowns runs one aggregation over detailed_matches that breaks a player's matches down
+// by map, by mode (GameVariantCategory) and by map and mode. The MatchHistoryQuery filters narrow the matches.
+func GetPerformanceBreakdowns(ctx context.Context, query MatchHistoryQuery, minMatches int) (PerformanceBreakdowns, error) {
+	breakdowns := PerformanceBreakdowns{
+		Maps:       []PerformanceBreakdown{},
+		Modes:      []PerformanceBreakdown{},
+		MapModes:   []PerformanceBreakdown{},
+		MinMatches: minMatches,
+	}
+	query.Cursor = ""
+	filter, err := matchHistoryFilter(query)
+	if err != nil {
+		return breakdowns, err
+	}
+
+	pipeline := append(playerMatchRows(filter, query.XUID),
+		bson.D{{Key: "$facet", Value: bson.M{
+			"maps":     breakdownFacet(bson.M{"mapId": "$mapId"}, minMatches),
+			"modes":    breakdownFacet(bson.M{"gameVariantCategory": "$gameVariantCategory"}, minMatches),
+			"mapModes": breakdownFacet(bson.M{"mapId": "$mapId", "gameVariantCategory": "$gameVariantCategory"}, minMatches),
+		}}},
+	)
+
+	cursor, err := db.GetCollection("detailed_matches").Aggregate(ctx, pipeline)
+	if err != nil {
+		return breakdowns, err
+	}
+	defer cursor.Close(ctx)
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&breakdowns); err != nil {
+			return breakdowns, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return breakdowns, err
+	}
+
+	return breakdowns, nil
+}
+
+// HandlePerformanceBreakdowns serves GET /players/:xuid/breakdowns.
+// It takes the filters of GET /players/:xuid/matches plus minMatches, the smallest group that's returned.
+func HandlePerformanceBreakdowns(c *gin.Context) {
+	query, err := ParseMatchHistoryQuery(c)
+	if err == nil && c.Query("cursor") != "" {
+		err = errors.New("cursor isn't supported here")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	minMatches := defaultBreakdownMinMatches
+	if value := c.Query("minMatches"); value != "" {
+		minMatches, err = strconv.Atoi(value)
+		if err != nil || minMatches < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "minMatches must be a positive number"})
+			return
+		}
+	}
+
+	breakdowns, err := GetPerformanceBreakdowns(c.Request.Context(), query, minMatches)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, breakdowns)
+}
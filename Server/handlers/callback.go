@@ -3,13 +3,16 @@ package spartanreport
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
 	requests "spartanreport/requests"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthExchange struct {
-	Code string `json:"token"`
+	Code              string `json:"token"`
+	AuthorizationCode string `json:"code"`
 }
 
 func HandleAuth(c *gin.Context) {
@@ -19,9 +22,62 @@ func HandleAuth(c *gin.Context) {
 		return
 	}
 
-	if authCode.Code != "" {
+	if authCode.AuthorizationCode != "" {
+		requests.ProcessAuthorizationCode(authCode.AuthorizationCode, c)
+	} else if authCode.Code != "" {
 		requests.ProcessAuthCode(authCode.Code, c)
 	} else {
 		fmt.Println("No code received")
 	}
 }
+
+// HandleLogin sends the browser to the Microsoft sign in page, which redirects back to HandleAuthCallback with the state set here
+func HandleLogin(c *gin.Context) {
+	state, err := requests.StartOAuthState(c)
+	if err != nil {
+		fmt.Println(err)
+		c.Redirect(http.StatusFound, os.Getenv("HOST")+"/?authError=1")
+		return
+	}
+	c.Redirect(http.StatusFound, requests.RequestLink(os.Getenv("CLIENT_ID"), os.Getenv("REDIRECT_URI"), state))
+}
+
+// HandleAuthCallback is the OAuth redirect target. It checks the state against the browser's cookie, creates the session
+// and sends the browser back to the front end with a short lived handoff code instead of the profile itself.
+func HandleAuthCallback(c *gin.Context) {
+	host := os.Getenv("HOST")
+	// Without this anyone could send a victim here with the attacker's own code and sign them in as the attacker
+	if err := requests.CheckOAuthState(c, c.Query("state")); err != nil {
+		fmt.Println("Rejected auth callback:", err)
+		c.Redirect(http.StatusFound, host+"/?authError=1")
+		return
+	}
+	authCode := c.Query("code")
+	if authCode == "" {
+		fmt.Println("No code received:", c.Query("error_description"))
+		c.Redirect(http.StatusFound, host+"/?authError=1")
+		return
+	}
+
+	SpartanResp, refreshInfo, err := requests.ExchangeAuthorizationCode(authCode)
+	if err != nil {
+		fmt.Println("Error exchanging authorization code:", err)
+		c.Redirect(http.StatusFound, host+"/?authError=1")
+		return
+	}
+
+	sessionID, gamerInfo, err := requests.NewSessionFromSpartanToken(c.Request.Context(), SpartanResp, refreshInfo)
+	if err != nil {
+		fmt.Println("Error creating session: ", err)
+		c.Redirect(http.StatusFound, host+"/?authError=1")
+		return
+	}
+
+	handoffCode, err := requests.CreateHandoff(c.Request.Context(), sessionID, gamerInfo)
+	if err != nil {
+		fmt.Println("Error creating handoff: ", err)
+		c.Redirect(http.StatusFound, host+"/?authError=1")
+		return
+	}
+	c.Redirect(http.StatusFound, host+"/?handoff="+url.QueryEscape(handoffCode))
+}
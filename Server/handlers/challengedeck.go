@@ -3,7 +3,7 @@ package spartanreport
 import (
 	"fmt"
 	"net/http"
-	requests "spartanreport/requests"
+	"spartanreport/haloapi"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -70,23 +70,16 @@ type RewardTrack struct {
 }
 
 func HandleChallengeDeck(c *gin.Context) {
+	gamerInfo := GetSessionGamerInfo(c)
 
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+	ctx := c.Request.Context()
+	creds := gamerInfo.Credentials()
 
 	var playerData PlayerData
-	url := "https://halostats.svc.halowaypoint.com/hi/players/xuid(" + gamerInfo.XUID + ")/decks"
-
-	err := makeAPIRequest(gamerInfo.SpartanKey, url, nil, &playerData)
+	err := haloapi.Default.PlayerChallengeDecks(ctx, creds, gamerInfo.XUID, &playerData)
 	if err != nil {
 		fmt.Println("Error API Challenge Deck: ", err)
 	}
-	baseURL := "https://gamecms-hacs.svc.halowaypoint.com/hi/Progression/file/"
-	hdrs := map[string]string{}
-	hdrs["343-clearance"] = gamerInfo.ClearanceCode
 
 	for _, deck := range playerData.AssignedDecks {
 		// Active Challenges
@@ -94,8 +87,7 @@ func HandleChallengeDeck(c *gin.Context) {
 			fmt.Println("Challenge Raw", chal)
 
 			var chalDetail ChallengeDetail
-			challengeURL := baseURL + chal.Path
-			err := makeAPIRequest(gamerInfo.SpartanKey, challengeURL, hdrs, &chalDetail)
+			err := haloapi.Default.ProgressionFile(ctx, creds, chal.Path, &chalDetail)
 			if err != nil {
 				fmt.Println("Error fetching Challenge Detail: ", err)
 			}
@@ -104,8 +96,7 @@ func HandleChallengeDeck(c *gin.Context) {
 		// Upcoming Challenges
 		for i, chal := range deck.UpcomingChallenges {
 			var chalDetail ChallengeDetail
-			challengeURL := baseURL + chal.Path
-			err := makeAPIRequest(gamerInfo.SpartanKey, challengeURL, hdrs, &chalDetail)
+			err := haloapi.Default.ProgressionFile(ctx, creds, chal.Path, &chalDetail)
 			if err != nil {
 				fmt.Println("Error fetching Challenge Detail: ", err)
 			}
@@ -114,8 +105,7 @@ func HandleChallengeDeck(c *gin.Context) {
 		// Completed Challenges
 		for i, chal := range deck.CompletedChallenges {
 			var chalDetail ChallengeDetail
-			challengeURL := baseURL + chal.Path
-			err := makeAPIRequest(gamerInfo.SpartanKey, challengeURL, hdrs, &chalDetail)
+			err := haloapi.Default.ProgressionFile(ctx, creds, chal.Path, &chalDetail)
 			if err != nil {
 				fmt.Println("Error fetching Challenge Detail: ", err)
 			}
@@ -0,0 +1,348 @@
+package spartanreport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"spartanreport/db"
+	"spartanreport/haloapi"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	minComparePlayers = 2
+	maxComparePlayers = 4
+)
+
+// recentSharedMatches is how many of the latest matches two players shared are listed by MatchId
+const recentSharedMatches = 10
+
+// CompareRequest is the body of POST /compare
+type CompareRequest struct {
+	XUIDs []string `json:"xuids"`
+}
+
+// ComparePlayer is one player's column of the comparison
+type ComparePlayer struct {
+	XUID             string
+	Gamertag         string
+	CareerTrack      RewardTrackResponse // Other players' comes from the career XP their last sync recorded
+	CareerAsOf       time.Time           // When CareerTrack was read, zero when the player never synced
+	AdjustedAverages map[string]int
+	AverageDurations map[string]string
+	Matches          int // Stored matches KDA and WinRate are taken over
+	KDA              float64
+	WinRate          float64
+	Synced           bool // False when the player never ran a sync and the averages come from their latest matches only
+}
+
+// ComparePair is what two of the compared players did in the same matches.
+// Wins and Losses are the first player's results in the matches they were on opposite teams.
+type ComparePair struct {
+	XUIDs         [2]string
+	Together      int
+	TogetherWins  int
+	Against       int
+	Wins          int
+	Losses        int
+	RecentMatches []string // Newest first
+}
+
+// CompareResponse is the response of POST /compare, Players are in the order they were asked for
+type CompareResponse struct {
+	Players []ComparePlayer
+	Pairs   []ComparePair
+}
+
+// comparePlaylistAggregates returns a player's playlist aggregates from their last sync under the given rules.
+// Players who never synced get aggregates over their latest page of matches, which are stored along the way.
+func comparePlaylistAggregates(ctx context.Context, creds haloapi.Credentials, xuid string, rules *ScoringRules) (map[string]PlaylistAggregate, bool, error) {
+	state, err := loadSyncState(xuid)
+	if err != nil {
+		return nil, false, err
+	}
+	if state.LatestMatchId != "" && state.RulesVersion == rules.Version {
+		return state.PlaylistAggregates, true, nil
+	}
+	if state.LatestMatchId != "" {
+		// The recompute hasn't reached the player since the rules changed, so the cutoff may have moved
+		aggregates, err := rebuildPlaylistAggregates(state, rules.AveragesCutoff)
+		return aggregates, true, err
+	}
+
+	var page HaloData
+	if err := haloapi.Default.PlayerMatches(ctx, creds, xuid, 0, matchHistoryPageSize, &page); err != nil {
+		return nil, false, err
+	}
+	details, err := loadOrFetchMatches(ctx, creds, page.Results, SyncHooks{})
+	if err != nil {
+		return nil, false, err
+	}
+	var results []Result
+	for _, result := range page.Results {
+		if match, ok := details[result.MatchId]; ok {
+			result.Match = match
+			results = append(results, result)
+		}
+	}
+	aggregates := make(map[string]PlaylistAggregate)
+	accumulatePlaylistAggregates(aggregates, results, "xuid("+xuid+")", rules.AveragesCutoff)
+	return aggregates, false, nil
+}
+
+// compareOverall reads a player's KDA and win rate over their stored matches
+func compareOverall(ctx context.Context, player *ComparePlayer) error {
+	pipeline := append(playerMatchRows(bson.M{"players.playerid": "xuid(" + player.XUID + ")"}, player.XUID),
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"matches": bson.M{"$sum": 1},
+			"kda":     bson.M{"$avg": "$kda"},
+			"wins":    bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$outcome", matchOutcomes["win"]}}, 1, 0}}},
+		}}},
+	)
+	cursor, err := db.GetCollection("detailed_matches").Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	var overall struct {
+		Matches int     `bson:"matches"`
+		KDA     float64 `bson:"kda"`
+		Wins    int     `bson:"wins"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&overall); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	player.Matches = overall.Matches
+	player.KDA = overall.KDA
+	if overall.Matches > 0 {
+		player.WinRate = float64(overall.Wins) / float64(overall.Matches)
+	}
+	return nil
+}
+
+// sharedMatch is a stored match two compared players were in, reduced to the pair
+type sharedMatch struct {
+	MatchId string              `bson:"MatchId"`
+	Players []sharedMatchPlayer `bson:"players"`
+}
+
+type sharedMatchPlayer struct {
+	PlayerId   string `bson:"playerid"`
+	LastTeamId int    `bson:"lastteamid"`
+	Outcome    int    `bson:"outcome"`
+}
+
+// comparePair finds the stored matches both players were in
+func comparePair(ctx context.Context, first string, second string) (ComparePair, error) {
+	pair := ComparePair{XUIDs: [2]string{first, second}, RecentMatches: []string{}}
+	playerIDs := []string{"xuid(" + first + ")", "xuid(" + second + ")"}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"players.playerid": bson.M{"$all": playerIDs}}}},
+		{{Key: "$project", Value: bson.M{
+			"MatchId":   1,
+			"starttime": "$matchinfo.starttime",
+			"players": bson.M{"$filter": bson.M{
+				"input": "$players",
+				"as":    "player",
+				"cond":  bson.M{"$in": bson.A{"$$player.playerid", playerIDs}},
+			}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "starttime", Value: -1}, {Key: "MatchId", Value: -1}}}},
+	}
+	cursor, err := db.GetCollection("detailed_matches").Aggregate(ctx, pipeline)
+	if err != nil {
+		return pair, err
+	}
+	var shared []sharedMatch
+	if err := cursor.All(ctx, &shared); err != nil {
+		return pair, err
+	}
+	tallyComparePair(&pair, playerIDs[0], playerIDs[1], shared)
+	return pair, nil
+}
+
+// tallyComparePair counts the matches, newest first, the two players were on the same and on opposite teams
+func tallyComparePair(pair *ComparePair, firstID string, secondID string, shared []sharedMatch) {
+	for _, match := range shared {
+		first, second := -1, -1
+		for k, player := range match.Players {
+			switch player.PlayerId {
+			case firstID:
+				first = k
+			case secondID:
+				second = k
+			}
+		}
+		if first < 0 || second < 0 {
+			continue
+		}
+		outcome := match.Players[first].Outcome
+		if match.Players[first].LastTeamId == match.Players[second].LastTeamId {
+			pair.Together++
+			if outcome == matchOutcomes["win"] {
+				pair.TogetherWins++
+			}
+		} else {
+			pair.Against++
+			if outcome == matchOutcomes["win"] {
+				pair.Wins++
+			} else if outcome == matchOutcomes["loss"] {
+				pair.Losses++
+			}
+		}
+		if len(pair.RecentMatches) < recentSharedMatches {
+			pair.RecentMatches = append(pair.RecentMatches, match.MatchId)
+		}
+	}
+}
+
+// compareQueryWorkers bounds the players or pairs queried at once. The queries are mostly Mongo's,
+// so they run on their own small group rather than haloapi's FanOut, which is sized for upstream calls.
+const compareQueryWorkers = 4
+
+// comparePairs runs comparePair for every two of the players, in the order the players were given
+func comparePairs(ctx context.Context, xuids []string) ([]ComparePair, error) {
+	var pairs []ComparePair
+	for i := 0; i < len(xuids); i++ {
+		for j := i + 1; j < len(xuids); j++ {
+			pairs = append(pairs, ComparePair{XUIDs: [2]string{xuids[i], xuids[j]}})
+		}
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(compareQueryWorkers)
+	for i := range pairs {
+		i := i
+		group.Go(func() error {
+			pair, err := comparePair(groupCtx, pairs[i].XUIDs[0], pairs[i].XUIDs[1])
+			if err != nil {
+				return err
+			}
+			pairs[i] = pair
+			return nil
+		})
+	}
+	return pairs, group.Wait()
+}
+
+// storedCareerTrack is a player's career progress as their last sync recorded it, with when it was recorded.
+// Players who never synced get an empty track and a zero time.
+func storedCareerTrack(ctx context.Context, xuid string) (RewardTrackResponse, time.Time, error) {
+	var track RewardTrackResponse
+	var snapshot CareerXPSnapshot
+	err := db.GetCollection("career_xp_history").FindOne(ctx, bson.M{"xuid": xuid}, options.FindOne().SetSort(bson.D{{Key: "recordedat", Value: -1}})).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return track, time.Time{}, nil
+	} else if err != nil {
+		return track, time.Time{}, err
+	}
+	track.CurrentProgress = CurrentProgress{
+		Rank:            snapshot.Rank,
+		PartialProgress: snapshot.PartialProgress,
+		TotalXPEarned:   snapshot.TotalXP,
+	}
+	return track, snapshot.RecordedAt, nil
+}
+
+// ComparePlayers builds the side by side comparison of the given players
+func ComparePlayers(c *gin.Context, xuids []string) (CompareResponse, error) {
+	ctx := c.Request.Context()
+	caller := GetSessionGamerInfo(c)
+	creds := caller.Credentials()
+	response := CompareResponse{Players: make([]ComparePlayer, len(xuids))}
+
+	rules, err := GetScoringRules(ctx)
+	if err != nil {
+		return response, err
+	}
+	careerLadder := GetCareerLadder(caller, c)
+	gamertags := resolveGamertags(ctx, creds, xuids)
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(compareQueryWorkers)
+	for i := range xuids {
+		i := i
+		group.Go(func() error {
+			player := ComparePlayer{XUID: xuids[i], Gamertag: gamertags[xuids[i]]}
+
+			var err error
+			if player.XUID == caller.XUID {
+				player.CareerTrack = GetCareerStats(caller, c)
+				player.CareerTrack.CurrentProgress.TotalXPEarned = CalculateTotalXPGainedSoFar(careerLadder, player.CareerTrack.CurrentProgress)
+				player.CareerAsOf = time.Now().UTC()
+			} else {
+				// Another player's track can only be read with their own token, so it comes from their last sync
+				player.CareerTrack, player.CareerAsOf, err = storedCareerTrack(groupCtx, player.XUID)
+			}
+
+			var aggregates map[string]PlaylistAggregate
+			if err == nil {
+				aggregates, player.Synced, err = comparePlaylistAggregates(groupCtx, creds, player.XUID, rules)
+			}
+			if err == nil {
+				player.AdjustedAverages, player.AverageDurations = adjustedPlaylistAverages(aggregates, rules)
+				err = compareOverall(groupCtx, &player)
+			}
+			if err != nil {
+				return fmt.Errorf("Error comparing %s: %v", player.XUID, err)
+			}
+			response.Players[i] = player
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return response, err
+	}
+
+	pairs, err := comparePairs(ctx, xuids)
+	if err != nil {
+		return response, err
+	}
+	response.Pairs = pairs
+	return response, nil
+}
+
+// HandleCompare serves POST /compare with two to four XUIDs
+func HandleCompare(c *gin.Context) {
+	var request CompareRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	seen := map[string]bool{}
+	var xuids []string
+	for _, xuid := range request.XUIDs {
+		if !xuidPattern.MatchString(xuid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid xuid " + xuid})
+			return
+		}
+		if !seen[xuid] {
+			seen[xuid] = true
+			xuids = append(xuids, xuid)
+		}
+	}
+	if len(xuids) < minComparePlayers || len(xuids) > maxComparePlayers {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("compare takes %d to %d different players", minComparePlayers, maxComparePlayers)})
+		return
+	}
+
+	response, err := ComparePlayers(c, xuids)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
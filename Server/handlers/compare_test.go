@@ -0,0 +1,41 @@
+package spartanreport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func sharedMatchOf(id string, firstTeam, firstOutcome, secondTeam int) sharedMatch {
+	return sharedMatch{MatchId: id, Players: []sharedMatchPlayer{
+		{PlayerId: "xuid(1)", LastTeamId: firstTeam, Outcome: firstOutcome},
+		{PlayerId: "xuid(2)", LastTeamId: secondTeam},
+	}}
+}
+
+func TestTallyComparePair(t *testing.T) {
+	win, loss, tie := matchOutcomes["win"], matchOutcomes["loss"], matchOutcomes["tie"]
+	shared := []sharedMatch{
+		sharedMatchOf("m1", 0, win, 0),
+		sharedMatchOf("m2", 0, loss, 0),
+		sharedMatchOf("m3", 0, win, 1),
+		sharedMatchOf("m4", 1, loss, 0),
+		sharedMatchOf("m5", 1, tie, 0),
+		{MatchId: "only-one", Players: []sharedMatchPlayer{{PlayerId: "xuid(1)", Outcome: win}}},
+	}
+
+	pair := ComparePair{XUIDs: [2]string{"1", "2"}, RecentMatches: []string{}}
+	tallyComparePair(&pair, "xuid(1)", "xuid(2)", shared)
+
+	want := ComparePair{
+		XUIDs:         [2]string{"1", "2"},
+		Together:      2,
+		TogetherWins:  1,
+		Against:       3,
+		Wins:          1,
+		Losses:        1,
+		RecentMatches: []string{"m1", "m2", "m3", "m4", "m5"},
+	}
+	if !reflect.DeepEqual(pair, want) {
+		t.Errorf("pair = %+v, want %+v", pair, want)
+	}
+}
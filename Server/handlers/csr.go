@@ -0,0 +1,346 @@
+package spartanreport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"spartanreport/db"
+	"spartanreport/haloapi"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// csrMatchBackfill bounds how many ranked matches missing from match_csr are looked up per sync
+const csrMatchBackfill = 25
+
+// CSR is a rank in a ranked playlist, as returned by the skill service.
+// Tier is empty while the player is still in placement matches.
+type CSR struct {
+	Value                       int    `json:"Value" bson:"value"`
+	MeasurementMatchesRemaining int    `json:"MeasurementMatchesRemaining" bson:"measurementmatchesremaining"`
+	Tier                        string `json:"Tier" bson:"tier"`
+	TierStart                   int    `json:"TierStart" bson:"tierstart"`
+	SubTier                     int    `json:"SubTier" bson:"subtier"`
+	NextTier                    string `json:"NextTier" bson:"nexttier"`
+	NextTierStart               int    `json:"NextTierStart" bson:"nexttierstart"`
+	NextSubTier                 int    `json:"NextSubTier" bson:"nextsubtier"`
+}
+
+// PlaylistCSRResponse is what the skill service returns for GET /hi/playlist/{id}/csrs
+type PlaylistCSRResponse struct {
+	Value []struct {
+		Id         string `json:"Id"`
+		ResultCode int    `json:"ResultCode"`
+		Result     struct {
+			Current    CSR `json:"Current"`
+			SeasonMax  CSR `json:"SeasonMax"`
+			AllTimeMax CSR `json:"AllTimeMax"`
+		} `json:"Result"`
+	} `json:"Value"`
+}
+
+// MatchSkillResponse is what the skill service returns for GET /hi/matches/{id}/skill
+type MatchSkillResponse struct {
+	Value []struct {
+		Id         string `json:"Id"`
+		ResultCode int    `json:"ResultCode"`
+		Result     struct {
+			RankRecap struct {
+				PreMatchCsr  CSR `json:"PreMatchCsr"`
+				PostMatchCsr CSR `json:"PostMatchCsr"`
+			} `json:"RankRecap"`
+		} `json:"Result"`
+	} `json:"Value"`
+}
+
+// CSRSnapshot is a player's CSR in one ranked playlist at the end of a sync, stored in csr_history.
+// A snapshot is only stored when the CSR moved since the last one.
+type CSRSnapshot struct {
+	XUID         string    `bson:"xuid" json:"-"`
+	PlaylistId   string    `bson:"playlistid"`
+	PlaylistName string    `bson:"playlistname"`
+	Season       string    `bson:"season"` // CsrSeasonFilePath of the season the CSR belongs to
+	RecordedAt   time.Time `bson:"recordedat"`
+	Current      CSR       `bson:"current"`
+	SeasonMax    CSR       `bson:"seasonmax"`
+	AllTimeMax   CSR       `bson:"alltimemax"`
+}
+
+// MatchCSR is a player's CSR before and after one ranked match, stored in match_csr.
+// Available is false when the skill service had no rank recap for the match.
+type MatchCSR struct {
+	MatchId    string    `bson:"matchid"`
+	XUID       string    `bson:"xuid" json:"-"`
+	PlaylistId string    `bson:"playlistid" json:"-"`
+	StartTime  time.Time `bson:"starttime"`
+	Available  bool      `bson:"available"`
+	PreMatch   CSR       `bson:"prematch"`
+	PostMatch  CSR       `bson:"postmatch"`
+}
+
+// PlaylistCSRHistory is one ranked playlist of GET /players/:xuid/csr
+type PlaylistCSRHistory struct {
+	PlaylistId   string
+	PlaylistName string
+	Current      CSR
+	SeasonMax    CSR
+	AllTimeMax   CSR
+	RecordedAt   time.Time
+	History      []CSRSnapshot // Oldest first
+	Matches      []MatchCSR    // Oldest first, only matches with a rank recap
+}
+
+// CreateCSRIndexes creates the csr_history and match_csr indexes
+func CreateCSRIndexes() error {
+	if err := db.CreateIndex("csr_history", bson.D{{Key: "xuid", Value: 1}, {Key: "playlistid", Value: 1}, {Key: "recordedat", Value: 1}}); err != nil {
+		return err
+	}
+	return db.CreateIndex("match_csr", bson.D{{Key: "xuid", Value: 1}, {Key: "playlistid", Value: 1}, {Key: "starttime", Value: 1}})
+}
+
+// currentCSRSeason returns the CsrSeasonFilePath of the season running now, empty if it can't be told
+func currentCSRSeason(ctx context.Context, creds haloapi.Credentials) string {
+	seasons, _, found := seasonCache.Get(ctx, seasonDataKey)
+	if !found {
+		if err := haloapi.Default.ProgressionFile(ctx, creds, "calendars/seasons/seasoncalendar.json", &seasons); err != nil {
+			fmt.Println("Error Obtaining Season Info: ", err)
+			return ""
+		}
+		markActiveSeasons(&seasons, time.Now().UTC())
+	}
+	for _, season := range seasons.Seasons {
+		if season.IsActive {
+			return season.CsrSeasonFilePath
+		}
+	}
+	return ""
+}
+
+// rankedPlaylists returns the AssetId and name of every ranked playlist a player has stored matches in
+func rankedPlaylists(ctx context.Context, xuid string) (map[string]string, error) {
+	ranked := true
+	filter, err := matchHistoryFilter(MatchHistoryQuery{XUID: xuid, Ranked: &ranked})
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := db.GetCollection("detailed_matches").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": "$matchinfo.playlist.assetid", "name": bson.M{"$last": "$matchinfo.playlistinfo.publicname"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Id   string `bson:"_id"`
+		Name string `bson:"name"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	playlists := make(map[string]string, len(groups))
+	for _, group := range groups {
+		playlists[group.Id] = group.Name
+	}
+	return playlists, nil
+}
+
+// RecordCSR stores a player's CSR in each of their ranked playlists, plus the pre and post match CSR
+// of their latest ranked matches that don't have one yet. It runs after every sync.
+func RecordCSR(ctx context.Context, creds haloapi.Credentials, xuid string) error {
+	playlists, err := rankedPlaylists(ctx, xuid)
+	if err != nil {
+		return err
+	}
+	season := currentCSRSeason(ctx, creds)
+	now := time.Now().UTC()
+
+	collection := db.GetCollection("csr_history")
+	for playlistID, playlistName := range playlists {
+		var response PlaylistCSRResponse
+		if err := haloapi.Default.PlaylistCSR(ctx, creds, playlistID, []string{xuid}, season, &response); err != nil {
+			fmt.Println("Error fetching playlist CSR: ", err)
+			continue
+		}
+		if len(response.Value) == 0 || response.Value[0].ResultCode != 0 {
+			continue
+		}
+		result := response.Value[0].Result
+		snapshot := CSRSnapshot{
+			XUID:         xuid,
+			PlaylistId:   playlistID,
+			PlaylistName: playlistName,
+			Season:       season,
+			RecordedAt:   now,
+			Current:      result.Current,
+			SeasonMax:    result.SeasonMax,
+			AllTimeMax:   result.AllTimeMax,
+		}
+
+		var last CSRSnapshot
+		err := collection.FindOne(ctx,
+			bson.M{"xuid": xuid, "playlistid": playlistID},
+			options.FindOne().SetSort(bson.D{{Key: "recordedat", Value: -1}}),
+		).Decode(&last)
+		if err == nil && last.Season == snapshot.Season && last.Current == snapshot.Current && last.SeasonMax == snapshot.SeasonMax {
+			continue
+		} else if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		if _, err := collection.InsertOne(ctx, snapshot); err != nil {
+			return fmt.Errorf("Error storing CSR: %v", err)
+		}
+	}
+
+	return recordMatchCSR(ctx, creds, xuid)
+}
+
+// recordMatchCSR looks up the rank recap of the player's latest ranked matches that aren't in match_csr yet
+func recordMatchCSR(ctx context.Context, creds haloapi.Credentials, xuid string) error {
+	var recorded []MatchCSR
+	if err := db.BulkGetData("match_csr", bson.M{"xuid": xuid}, &recorded); err != nil {
+		return err
+	}
+	known := make([]string, len(recorded))
+	for i, match := range recorded {
+		known[i] = match.MatchId
+	}
+
+	ranked := true
+	filter, err := matchHistoryFilter(MatchHistoryQuery{XUID: xuid, Ranked: &ranked})
+	if err != nil {
+		return err
+	}
+	filter = bson.M{"$and": bson.A{filter, bson.M{"MatchId": bson.M{"$nin": known}}}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "matchinfo.starttime", Value: -1}}).
+		SetLimit(csrMatchBackfill).
+		SetProjection(bson.M{"MatchId": 1, "matchinfo.starttime": 1, "matchinfo.playlist.assetid": 1})
+	cursor, err := db.GetCollection("detailed_matches").Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	var matches []Match
+	if err := cursor.All(ctx, &matches); err != nil {
+		return err
+	}
+
+	var models []mongo.WriteModel
+	var mu sync.Mutex
+	haloapi.Default.FanOut(len(matches), func(i int) {
+		match := matches[i]
+		startTime, _ := time.Parse(time.RFC3339Nano, match.MatchInfo.StartTime)
+		var response MatchSkillResponse
+		if err := haloapi.Default.MatchSkill(ctx, creds, match.MatchId, []string{xuid}, &response); err != nil {
+			fmt.Println("Error fetching match skill: ", err)
+			return
+		}
+		record := MatchCSR{
+			MatchId:    match.MatchId,
+			XUID:       xuid,
+			PlaylistId: match.MatchInfo.Playlist.AssetId,
+			StartTime:  startTime,
+		}
+		if len(response.Value) > 0 && response.Value[0].ResultCode == 0 {
+			recap := response.Value[0].Result.RankRecap
+			record.PreMatch = recap.PreMatchCsr
+			record.PostMatch = recap.PostMatchCsr
+			record.Available = recap.PostMatchCsr != (CSR{})
+		}
+		mu.Lock()
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"matchid": record.MatchId, "xuid": xuid}).
+			SetReplacement(record).
+			SetUpsert(true))
+		mu.Unlock()
+	})
+	if len(models) == 0 {
+		return nil
+	}
+	if _, err := db.GetCollection("match_csr").BulkWrite(ctx, models); err != nil {
+		return fmt.Errorf("Error storing match CSR: %v", err)
+	}
+	return nil
+}
+
+// GetCSRHistory returns a player's recorded CSR per ranked playlist, playlist filters to one AssetId
+func GetCSRHistory(ctx context.Context, xuid string, playlist string) ([]PlaylistCSRHistory, error) {
+	filter := bson.M{"xuid": xuid}
+	if playlist != "" {
+		filter["playlistid"] = playlist
+	}
+	var snapshots []CSRSnapshot
+	cursor, err := db.GetCollection("csr_history").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "recordedat", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		return nil, err
+	}
+
+	matchFilter := bson.M{"xuid": xuid, "available": true}
+	if playlist != "" {
+		matchFilter["playlistid"] = playlist
+	}
+	var matches []MatchCSR
+	cursor, err = db.GetCollection("match_csr").Find(ctx, matchFilter, options.Find().SetSort(bson.D{{Key: "starttime", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &matches); err != nil {
+		return nil, err
+	}
+
+	byPlaylist := map[string]*PlaylistCSRHistory{}
+	var order []string
+	for _, snapshot := range snapshots {
+		history, ok := byPlaylist[snapshot.PlaylistId]
+		if !ok {
+			history = &PlaylistCSRHistory{PlaylistId: snapshot.PlaylistId, History: []CSRSnapshot{}, Matches: []MatchCSR{}}
+			byPlaylist[snapshot.PlaylistId] = history
+			order = append(order, snapshot.PlaylistId)
+		}
+		history.PlaylistName = snapshot.PlaylistName
+		history.Current = snapshot.Current
+		history.SeasonMax = snapshot.SeasonMax
+		history.AllTimeMax = snapshot.AllTimeMax
+		history.RecordedAt = snapshot.RecordedAt
+		history.History = append(history.History, snapshot)
+	}
+	for _, match := range matches {
+		if history, ok := byPlaylist[match.PlaylistId]; ok {
+			history.Matches = append(history.Matches, match)
+		}
+	}
+
+	// Most recently moved first
+	sort.SliceStable(order, func(i, j int) bool {
+		return byPlaylist[order[i]].RecordedAt.After(byPlaylist[order[j]].RecordedAt)
+	})
+	histories := make([]PlaylistCSRHistory, 0, len(order))
+	for _, playlistID := range order {
+		histories = append(histories, *byPlaylist[playlistID])
+	}
+	return histories, nil
+}
+
+// HandleCSRHistory serves GET /players/:xuid/csr, the optional playlist query parameter narrows it to one playlist
+func HandleCSRHistory(c *gin.Context) {
+	xuid := c.Param("xuid")
+	if !xuidPattern.MatchString(xuid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid xuid"})
+		return
+	}
+
+	histories, err := GetCSRHistory(c.Request.Context(), xuid, c.Query("playlist"))
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, histories)
+}
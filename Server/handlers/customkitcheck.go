@@ -4,13 +4,12 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	requests "spartanreport/requests"
+	"spartanreport/haloapi"
 	"spartanreport/structures"
 )
 
 type kitcheck struct {
 	ItemsToCheck structures.CurrentlyEquipped `json:"currentlyEquippedItems"`
-	GamerInfo    requests.GamerInfo           `json:"gamerInfo"`
 }
 
 // EquippedItemsCheck is the struct to hold the check results
@@ -31,7 +30,7 @@ type EquippedItemsCheck struct {
 }
 
 func HandleCustomKitCheck(c *gin.Context) {
-	// Get GamerInfo from request
+	gamerInfo := GetSessionGamerInfo(c)
 	var kitcheck kitcheck
 	if err := c.ShouldBindJSON(&kitcheck); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error couldn't bind": err.Error()})
@@ -40,10 +39,7 @@ func HandleCustomKitCheck(c *gin.Context) {
 	equipmentCheck := EquippedItemsCheck{}
 	// Get Player Inventory
 	var InventoryResults = Items{}
-	url := "https://economy.svc.halowaypoint.com/hi/players/xuid(" + kitcheck.GamerInfo.XUID + ")/Inventory"
-	hdrs := map[string]string{}
-	hdrs["343-clearance"] = kitcheck.GamerInfo.ClearanceCode
-	err := makeAPIRequest(kitcheck.GamerInfo.SpartanKey, url, hdrs, &InventoryResults)
+	err := haloapi.Default.PlayerInventory(c.Request.Context(), gamerInfo.Credentials(), gamerInfo.XUID, &InventoryResults)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -12,20 +12,13 @@ import (
 )
 
 type SaveCustomKit struct {
-	CustomKit CustomKit          `json:"newDummyObject"`
-	GamerInfo requests.GamerInfo `json:"gamerInfo"`
+	CustomKit CustomKit `json:"newDummyObject"`
 }
 type UpdateCustomKit struct {
-	GamerInfo requests.GamerInfo `json:"gamerInfo"`
-	CustomKit CustomKit          `json:"newDummyObject"`
+	CustomKit CustomKit `json:"newDummyObject"`
 }
 type DeleteCustomKit struct {
-	Id        string             `json:"idToRemove"`
-	GamerInfo requests.GamerInfo `json:"gamerInfo"`
-}
-
-type GetCustomKit struct {
-	GamerInfo requests.GamerInfo `json:"gamerInfo"`
+	Id string `json:"idToRemove"`
 }
 
 func HandleSaveCustomKit(c *gin.Context) {
@@ -37,11 +30,7 @@ func HandleSaveCustomKit(c *gin.Context) {
 		return
 	}
 
-	newGamerInfo := customKitData.GamerInfo
-	if newGamerInfo.SpartanKey == "" {
-		c.JSON(http.StatusForbidden, "Empty GamerInfo received")
-		return
-	}
+	newGamerInfo := GetSessionGamerInfo(c)
 	prettyJSON, err := json.MarshalIndent(customKitData.CustomKit, "", "    ")
 	if err != nil {
 		fmt.Println("Error marshalling to pretty JSON:", err)
@@ -61,9 +50,7 @@ func HandleSaveCustomKit(c *gin.Context) {
 	fmt.Printf("Size of customKitData: %.2f KB\n", sizeInKB)
 	// First, add the gamerInfo to progression_data, if it already exists, nothing happens.
 	// Remove sensitive information from storing
-	truncatedGamerInfo := newGamerInfo
-	truncatedGamerInfo.XBLToken = ""
-	truncatedGamerInfo.SpartanKey = ""
+	truncatedGamerInfo := newGamerInfo.WithoutTokens()
 	dataToStore := struct {
 		GamerInfo requests.GamerInfo
 	}{
@@ -86,7 +73,7 @@ func HandleUpdateCustomKit(c *gin.Context) {
 		return
 	}
 
-	newGamerInfo := requestData.GamerInfo
+	newGamerInfo := GetSessionGamerInfo(c)
 
 	err := db.UpdateKit("progression_data", newGamerInfo.XUID, requestData.CustomKit.Id, requestData.CustomKit)
 	if err != nil {
@@ -107,22 +94,13 @@ func HandleRemoveCustomKit(c *gin.Context) {
 		return
 	}
 
-	newGamerInfo := customKitData.GamerInfo
-	if newGamerInfo.SpartanKey == "" {
-		c.JSON(http.StatusForbidden, "Empty GamerInfo received")
-		return
-	}
+	newGamerInfo := GetSessionGamerInfo(c)
 	db.DeleteKit("progression_data", newGamerInfo.XUID, customKitData.Id)
 }
 
 func HandleGetCustomKit(c *gin.Context) {
 	fmt.Println("Checking Custom Kit")
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		fmt.Println("Error binding JSON:", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+	gamerInfo := GetSessionGamerInfo(c)
 
 	kits, err := db.GetKit("progression_data", gamerInfo.XUID)
 	if err != nil {
@@ -75,6 +75,7 @@ type PlaylistInfo struct {
 	AssetId    string     `json:"AssetId"`
 	VersionId  string     `json:"VersionId"`
 	PublicName string     `json:"PublicName"`
+	HasCsr     bool       `json:"HasCsr"` // Ranked playlists track CSR
 	Files      FileDetail `json:"Files"`
 	AssetStats AssetStats `json:"AssetStats"`
 }
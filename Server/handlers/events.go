@@ -0,0 +1,189 @@
+package spartanreport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"spartanreport/db"
+	"spartanreport/haloapi"
+	requests "spartanreport/requests"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// eventTrackKey is the Redis hash event reward tracks are cached in with their image URLs, keyed by RewardTrackPath
+const eventTrackKey = "haloeventtracks"
+
+type EventsData struct {
+	Events    []Event // Newest first
+	GamerInfo requests.GamerInfo
+}
+
+type EventDetailsToReturn struct {
+	Event Event `json:"selectedEvent"`
+	Track Track `json:"track"`
+}
+
+// eventID is the name the economy service knows an event's reward track by, the file name of its RewardTrackPath
+func eventID(rewardTrackPath string) string {
+	return strings.TrimSuffix(path.Base(rewardTrackPath), ".json")
+}
+
+// processEvents fills in the name, description and image of each event from its reward track and sorts them newest first.
+// It errors when any track or image failed to load, the events are still filled in as far as they could be.
+func processEvents(gamerInfo requests.GamerInfo, events []Event) error {
+	var failed int32
+	haloapi.Default.FanOut(len(events), func(i int) {
+		events[i].EventId = eventID(events[i].RewardTrackPath)
+		track, err := GetEventRewards(gamerInfo, events[i])
+		if err != nil {
+			atomic.AddInt32(&failed, 1)
+			return
+		}
+		events[i].Name = track.Name.Value
+		events[i].Description = track.Description.Value
+
+		imagePath := track.SummaryImagePath
+		if imagePath == "" {
+			imagePath = track.BackgroundImagePath
+		}
+		if imagePath == "" {
+			return
+		}
+		imageURL, err := fetchImageURL(context.Background(), gamerInfo.Credentials(), imagePath)
+		if err != nil {
+			fmt.Println("Error while getting event image: ", err)
+			atomic.AddInt32(&failed, 1)
+			return
+		}
+		events[i].EventImageURL = imageURL
+	})
+
+	sort.Slice(events, func(i, j int) bool {
+		startTimeI, _ := time.Parse(time.RFC3339, events[i].StartDate.ISO8601Date)
+		startTimeJ, _ := time.Parse(time.RFC3339, events[j].StartDate.ISO8601Date)
+		return startTimeI.After(startTimeJ)
+	})
+	if failed > 0 {
+		return fmt.Errorf("%d of %d events failed to load", failed, len(events))
+	}
+	return nil
+}
+
+func GetEventRewards(gamerInfo requests.GamerInfo, event Event) (Track, error) {
+	trackData := Track{}
+	err := haloapi.Default.ProgressionFile(context.Background(), gamerInfo.Credentials(), event.RewardTrackPath, &trackData)
+	if err != nil {
+		fmt.Println("Error when getting event track data: ", err)
+		return trackData, err
+	}
+	return trackData, nil
+}
+
+// getEventTrack returns an event's reward track with its item images, from Redis once it's been built
+func getEventTrack(ctx context.Context, gamerInfo requests.GamerInfo, event Event) (Track, error) {
+	var trackData Track
+	obj, err := db.RedisClient.HGet(ctx, eventTrackKey, event.RewardTrackPath).Result()
+	if err == nil {
+		if err := json.Unmarshal([]byte(obj), &trackData); err != nil {
+			return trackData, fmt.Errorf("Couldn't decode event track: %v", err)
+		}
+		return trackData, nil
+	} else if err != redis.Nil {
+		fmt.Println("Error reading event track from Redis: ", err)
+	}
+
+	trackData, err = GetEventRewards(gamerInfo, event)
+	if err != nil {
+		return trackData, err
+	}
+	trackData.Ranks = GetTrackImages(gamerInfo, trackData.Ranks)
+	trackJSON, err := json.Marshal(trackData)
+	if err != nil {
+		return trackData, err
+	}
+	// A track that failed to load isn't cached so the next request tries again
+	if len(trackData.Ranks) > 0 {
+		if err := db.RedisClient.HSet(ctx, eventTrackKey, event.RewardTrackPath, trackJSON).Err(); err != nil {
+			fmt.Printf("error setting value in Redis: %v", err)
+		}
+	}
+	return trackData, nil
+}
+
+// getEventProgress returns the player's progress through an event, nil when it can't be read
+func getEventProgress(ctx context.Context, gamerInfo requests.GamerInfo, event Event) *OperationRewardTracks {
+	progress := OperationRewardTracks{}
+	if err := haloapi.Default.EventRewardTrack(ctx, gamerInfo.Credentials(), gamerInfo.XUID, event.EventId, &progress); err != nil {
+		fmt.Println("Error while getting user event progression: ", err)
+		return nil
+	}
+	return &progress
+}
+
+// HandleEvents lists the events of the season calendar with IsActive and IsUpcoming set.
+// Signed in players also get their progress through the active events.
+func HandleEvents(c *gin.Context) {
+	gamerInfo := GetSessionGamerInfo(c)
+	ctx := c.Request.Context()
+
+	seasons, err := GetSeasons(ctx, gamerInfo)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	events := seasons.Events
+	if events == nil {
+		events = []Event{}
+	}
+
+	if gamerInfo.XUID != "" {
+		haloapi.Default.FanOut(len(events), func(i int) {
+			if events[i].IsActive {
+				events[i].UserProgress = getEventProgress(ctx, gamerInfo, events[i])
+			}
+		})
+	}
+
+	c.JSON(http.StatusOK, EventsData{Events: events, GamerInfo: gamerInfo.WithoutTokens()})
+}
+
+// HandleEventDetails returns an event with its reward track, plus the player's progress when signed in
+func HandleEventDetails(c *gin.Context) {
+	gamerInfo := GetSessionGamerInfo(c)
+	ctx := c.Request.Context()
+
+	seasons, err := GetSeasons(ctx, gamerInfo)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	var eventFound *Event
+	for i := range seasons.Events {
+		if seasons.Events[i].EventId == c.Param("id") {
+			eventFound = &seasons.Events[i]
+			break
+		}
+	}
+	if eventFound == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	trackData, err := getEventTrack(ctx, gamerInfo, *eventFound)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	if gamerInfo.XUID != "" {
+		eventFound.UserProgress = getEventProgress(ctx, gamerInfo, *eventFound)
+	}
+	c.JSON(http.StatusOK, EventDetailsToReturn{Event: *eventFound, Track: trackData})
+}
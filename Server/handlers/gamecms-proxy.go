@@ -0,0 +1,29 @@
+package spartanreport
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGameCMSImage proxies gamecms images for the front end using the Spartan token
+// from the session, so the browser never needs the token itself.
+func HandleGameCMSImage(c *gin.Context) {
+	path := strings.TrimPrefix(c.Param("path"), "/")
+	allowed := strings.HasPrefix(path, "hi/images/file/") || strings.HasPrefix(path, medalSpritePrefix)
+	if !allowed || strings.Contains(path, "..") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image path"})
+		return
+	}
+
+	gamerInfo := GetSessionGamerInfo(c)
+	imageData := FetchImageData(c.Request.Context(), path, gamerInfo)
+	if len(imageData) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Data(http.StatusOK, http.DetectContentType(imageData), imageData)
+}
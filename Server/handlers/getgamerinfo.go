@@ -1,18 +1,34 @@
 package spartanreport
 
 import (
-	"encoding/json"
+	"fmt"
 	"net/http"
 	requests "spartanreport/requests"
+
+	"github.com/gin-gonic/gin"
 )
 
-// Endpoint to retrieve and delete gamerInfo
-func HandleGetGamerInfo(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
+type HandoffRedeem struct {
+	Code string `json:"code"`
+}
 
-	if gamerInfo, exists := requests.GetGamerInfo(token); exists {
-		json.NewEncoder(w).Encode(gamerInfo)
-	} else {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+// HandleRedeemHandoff trades the one-time code from the OAuth redirect for the session cookie and profile
+func HandleRedeemHandoff(c *gin.Context) {
+	var redeem HandoffRedeem
+	if err := c.ShouldBindJSON(&redeem); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+
+	handoff, err := requests.RedeemHandoff(c.Request.Context(), redeem.Code)
+	if err != nil {
+		if err != requests.ErrHandoffNotFound {
+			fmt.Println("Error redeeming handoff: ", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	requests.SetSessionCookie(c, handoff.SessionID)
+	c.JSON(http.StatusOK, handoff.GamerInfo)
 }
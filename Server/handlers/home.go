@@ -4,46 +4,59 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type EventsHome struct {
-	PreviousSeason Season `json:"PreviousSeason"`
-	CurrentSeason  Season `json:"CurrentSeason"`
+	PreviousSeason Season  `json:"PreviousSeason"`
+	CurrentSeason  Season  `json:"CurrentSeason"`
+	ActiveEvents   []Event `json:"ActiveEvents"`
+	UpcomingEvents []Event `json:"UpcomingEvents"` // Soonest first
 }
 
 func HandleEventsHome(c *gin.Context) {
 	fmt.Println("HandleEventsHome")
 	ctx := context.Background()
-	seasonID := "SeasonData"
-	cachedSeasons, exists := seasonCache.Get(ctx, seasonID)
-	if exists {
-		var activeIndex int
-		for i, season := range cachedSeasons.Seasons {
+	// /home has no session to refetch the calendar with, StartSeasonRefresher keeps it current in the background
+	cachedSeasons, _, exists := seasonCache.Get(ctx, seasonDataKey)
+	if !exists || len(cachedSeasons.Seasons) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while getting events"})
+		return
+	}
+
+	// Seasons are newest first. Between seasons the latest one that has started stands in for the current one
+	now := time.Now().UTC()
+	currentIndex := -1
+	for i, season := range cachedSeasons.Seasons {
+		startTime, _ := time.Parse(time.RFC3339, season.StartDate.ISO8601Date)
+		if season.IsActive || (currentIndex < 0 && startTime.Before(now)) {
+			currentIndex = i
 			if season.IsActive {
-				activeIndex = i
 				break
 			}
 		}
+	}
+	if currentIndex < 0 {
+		currentIndex = len(cachedSeasons.Seasons) - 1
+	}
 
-		// Check if the active season is not the first one in the list
-		if activeIndex > 0 {
-			EventsToReturn := EventsHome{
-				PreviousSeason: cachedSeasons.Seasons[activeIndex],
-				CurrentSeason:  cachedSeasons.Seasons[activeIndex+1],
-			}
-			c.JSON(http.StatusOK, EventsToReturn)
-			return
-		}
-
-		// Handle case where the active season is the first in the list
-		EventsToReturn := EventsHome{
-			PreviousSeason: cachedSeasons.Seasons[activeIndex], // or set a default value
-			CurrentSeason:  cachedSeasons.Seasons[activeIndex+1],
+	EventsToReturn := EventsHome{
+		CurrentSeason:  cachedSeasons.Seasons[currentIndex],
+		ActiveEvents:   []Event{},
+		UpcomingEvents: []Event{},
+	}
+	if currentIndex+1 < len(cachedSeasons.Seasons) {
+		EventsToReturn.PreviousSeason = cachedSeasons.Seasons[currentIndex+1]
+	}
+	for i := len(cachedSeasons.Events) - 1; i >= 0; i-- {
+		event := cachedSeasons.Events[i]
+		if event.IsActive {
+			EventsToReturn.ActiveEvents = append(EventsToReturn.ActiveEvents, event)
+		} else if event.IsUpcoming {
+			EventsToReturn.UpcomingEvents = append(EventsToReturn.UpcomingEvents, event)
 		}
-		c.JSON(http.StatusOK, EventsToReturn)
-		return
 	}
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while getting events"})
+	c.JSON(http.StatusOK, EventsToReturn)
 }
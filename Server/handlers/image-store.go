@@ -0,0 +1,150 @@
+package spartanreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"spartanreport/db"
+	"spartanreport/haloapi"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+// imageBucket is the GridFS bucket images are stored in, each file named by the SHA-256 of its bytes
+const imageBucket = "images"
+
+// The bytes behind a hash never change, so browsers can keep an image for as long as they like
+const imageCacheControl = "public, max-age=31536000, immutable"
+
+var imageHashPattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// storedImages remembers the hashes already in the bucket so the same image isn't looked up on every store
+var storedImages sync.Map
+
+// imageURL is the path an image is served from, relative to the API
+func imageURL(hash string) string {
+	return "/images/" + hash
+}
+
+// StoreImage stores the image under the hash of its bytes and returns the hash. Storing an image twice is a no-op
+func StoreImage(ctx context.Context, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if _, ok := storedImages.Load(hash); ok {
+		return hash, nil
+	}
+
+	bucket, err := db.GetBucket(imageBucket)
+	if err != nil {
+		return "", err
+	}
+	cursor, err := bucket.FindContext(ctx, bson.M{"filename": hash})
+	if err != nil {
+		return "", err
+	}
+	exists := cursor.Next(ctx)
+	cursor.Close(ctx)
+	// Two servers storing the same image at once leave two files with the same bytes, either one serves it
+	if !exists {
+		if _, err := bucket.UploadFromStream(hash, bytes.NewReader(data)); err != nil {
+			return "", fmt.Errorf("Error storing image: %v", err)
+		}
+	}
+	storedImages.Store(hash, struct{}{})
+	return hash, nil
+}
+
+// storeBase64Image stores an image cached as base64 before the image store and gives back its URL
+func storeBase64Image(ctx context.Context, base64Image string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(base64Image)
+	if err != nil {
+		return "", err
+	}
+	hash, err := StoreImage(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	return imageURL(hash), nil
+}
+
+// fetchImageURL fetches an image under hi/images/file/, compresses it and returns the URL it's served from
+func fetchImageURL(ctx context.Context, creds haloapi.Credentials, path string) (string, error) {
+	imageData, err := haloapi.Default.Image(ctx, creds, path)
+	if err != nil {
+		return "", err
+	}
+	imageData, err = compressPNGWithImaging(imageData, false, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	hash, err := StoreImage(ctx, imageData)
+	if err != nil {
+		return "", err
+	}
+	return imageURL(hash), nil
+}
+
+// HandleImage serves GET /images/:hash from the image store
+func HandleImage(c *gin.Context) {
+	hash := c.Param("hash")
+	if !imageHashPattern.MatchString(hash) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	serveStoredImage(c, hash, imageCacheControl)
+}
+
+// serveStoredImage writes an image of the store with the hash as its ETag, answering a matching If-None-Match with 304
+func serveStoredImage(c *gin.Context, hash string, cacheControl string) {
+	etag := `"` + hash + `"`
+	if c.GetHeader("If-None-Match") == etag {
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", cacheControl)
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	bucket, err := db.GetBucket(imageBucket)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	var buf bytes.Buffer
+	if _, err := bucket.DownloadToStreamByName(hash, &buf); err == gridfs.ErrFileNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	} else if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", cacheControl)
+	c.Data(http.StatusOK, http.DetectContentType(buf.Bytes()), buf.Bytes())
+}
+
+// inlineImageCachesDropped marks the reward track caches from before the image store as dropped
+const inlineImageCachesDropped = "migrations:inline_image_caches_dropped"
+
+// DropInlineImageCaches deletes the reward track caches from before the image store, which carried their images as base64.
+// It only runs once, later starts find the marker and leave the caches alone.
+func DropInlineImageCaches(ctx context.Context) error {
+	first, err := db.RedisClient.SetNX(ctx, inlineImageCachesDropped, time.Now().UTC().Format(time.RFC3339), 0).Result()
+	if err != nil || !first {
+		return err
+	}
+	if err := db.RedisClient.Del(ctx, "haloseasondata", "haloeventdata").Err(); err != nil {
+		// Leave it to the next start
+		db.RedisClient.Del(ctx, inlineImageCachesDropped)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package spartanreport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"regexp"
+	"spartanreport/db"
+	"spartanreport/haloapi"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// imageVariantKey is the Redis hash mapping each variant key to the hash of the variant in the image store
+const imageVariantKey = "image_variants"
+
+// Variants are capped so a request can't have the server encode arbitrarily large images
+const maxVariantDimension = 2048
+
+// variantSizes are the only widths and heights variants are generated at, anything else being rounded up to one of them
+// (or to the asset's own size past the last) so there's a bounded number of variants stored per asset
+var variantSizes = []int{70, 140, 280, 560}
+
+// Generation is detached from the requests waiting on it, this stops one stuck upstream fetch holding them all forever
+const variantGenerationTimeout = 30 * time.Second
+
+const variantJPEGQuality = 85
+
+// armoryThumbnailSize is the variant the armory grid's item images are stored as
+const armoryThumbnailSize = 140
+
+// variantPathPattern is what asset paths may contain, anything else could change the upstream URL the path is put in
+var variantPathPattern = regexp.MustCompile(`^[A-Za-z0-9/_.-]+$`)
+
+// A variant URL names the upstream asset rather than its bytes, so browsers check back on it daily
+const variantCacheControl = "public, max-age=86400"
+
+// errVariantNeedsSession is returned for a variant that isn't cached yet when there's no Spartan token to fetch the asset with
+var errVariantNeedsSession = errors.New("generating an image variant needs a session")
+
+// variantEncoders are the formats a variant can be asked for. WebP is recognized but has no encoder:
+// the pure Go one needs a newer Go than the server is built with
+var variantEncoders = map[string]func(image.Image) ([]byte, error){
+	"png":  encodeOptimizedPNG,
+	"jpeg": encodeJPEG,
+	"webp": nil,
+}
+
+// variantGeneration coalesces concurrent requests for the same variant so it's only generated once
+var variantGeneration singleflight.Group
+
+// ImageVariant is an asset under hi/images/file/ scaled down to fit Width x Height (0 keeping the asset's own) in Format.
+// GetImageVariant rounds Width and Height up to variantSizes.
+type ImageVariant struct {
+	Path   string
+	Width  int
+	Height int
+	Format string
+	Exact  bool // Scaled to exactly Width x Height ignoring the aspect ratio, the way armory thumbnails always were
+}
+
+// validVariantPath reports whether path is a plain relative asset path, without dot segments or characters that need escaping
+func validVariantPath(path string) bool {
+	if !variantPathPattern.MatchString(path) {
+		return false
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// snapVariantSize rounds a requested width or height up to the next of variantSizes, 0 meaning the asset's own
+func snapVariantSize(size int) int {
+	if size <= 0 {
+		return 0
+	}
+	for _, allowed := range variantSizes {
+		if size <= allowed {
+			return allowed
+		}
+	}
+	return 0
+}
+
+func (v ImageVariant) key() string {
+	if v.Exact {
+		return fmt.Sprintf("%dx%d!.%s:%s", v.Width, v.Height, v.Format, v.Path)
+	}
+	return fmt.Sprintf("%dx%d.%s:%s", v.Width, v.Height, v.Format, v.Path)
+}
+
+// encodeJPEG flattens transparent areas onto black, JPEG having no alpha
+func encodeJPEG(img image.Image) ([]byte, error) {
+	flattened := imaging.New(img.Bounds().Dx(), img.Bounds().Dy(), color.Black)
+	flattened = imaging.Overlay(flattened, img, image.Pt(0, 0), 1)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flattened, &jpeg.Options{Quality: variantJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// generateImageVariant fetches the asset, scales it down to fit the variant's box keeping its aspect ratio
+// (or to the box itself for an Exact variant) and encodes it
+func generateImageVariant(ctx context.Context, creds haloapi.Credentials, variant ImageVariant) ([]byte, error) {
+	data, err := haloapi.Default.Image(ctx, creds, variant.Path)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	width, height := variant.Width, variant.Height
+	if width == 0 {
+		width = img.Bounds().Dx()
+	}
+	if height == 0 {
+		height = img.Bounds().Dy()
+	}
+	if variant.Exact {
+		img = imaging.Resize(img, width, height, imaging.Lanczos)
+	} else {
+		// Fit never scales up, an asset smaller than the box keeps its size
+		img = imaging.Fit(img, width, height, imaging.Lanczos)
+	}
+	return variantEncoders[variant.Format](img)
+}
+
+// GetImageVariant returns the image store hash of a variant, generating and storing it the first time it's asked for
+func GetImageVariant(ctx context.Context, creds haloapi.Credentials, variant ImageVariant) (string, error) {
+	variant.Width, variant.Height = snapVariantSize(variant.Width), snapVariantSize(variant.Height)
+	key := variant.key()
+	hash, err := db.RedisClient.HGet(ctx, imageVariantKey, key).Result()
+	if err == nil {
+		return hash, nil
+	} else if err != redis.Nil {
+		fmt.Println("Error reading image variant from Redis: ", err)
+	}
+	if creds.SpartanToken == "" {
+		return "", errVariantNeedsSession
+	}
+
+	// Generation isn't tied to the request that started it, the others waiting on it would fail along with it
+	result, err, _ := variantGeneration.Do(key, func() (interface{}, error) {
+		generationCtx, cancel := context.WithTimeout(context.Background(), variantGenerationTimeout)
+		defer cancel()
+		data, err := generateImageVariant(generationCtx, creds, variant)
+		if err != nil {
+			return "", err
+		}
+		hash, err := StoreImage(generationCtx, data)
+		if err != nil {
+			return "", err
+		}
+		if err := db.RedisClient.HSet(generationCtx, imageVariantKey, key, hash).Err(); err != nil {
+			fmt.Printf("error setting value in Redis: %v", err)
+		}
+		return hash, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.(string), nil
+}
+
+// HandleImageVariant serves GET /variants/*path, the asset under hi/images/file/ scaled down to fit
+// the width and height query parameters in the format one (png by default)
+func HandleImageVariant(c *gin.Context) {
+	path := strings.TrimPrefix(c.Param("path"), "/")
+	if !validVariantPath(path) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image path"})
+		return
+	}
+	width, err := boundedIntQuery(c, "width", 0, maxVariantDimension)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	height, err := boundedIntQuery(c, "height", 0, maxVariantDimension)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := strings.ToLower(c.DefaultQuery("format", "png"))
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	encoder, known := variantEncoders[format]
+	if !known {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be png or jpeg"})
+		return
+	} else if encoder == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": format + " isn't supported, use png or jpeg"})
+		return
+	}
+
+	gamerInfo := GetSessionGamerInfo(c)
+	variant := ImageVariant{Path: path, Width: width, Height: height, Format: format}
+	hash, err := GetImageVariant(c.Request.Context(), gamerInfo.Credentials(), variant)
+	if err == errVariantNeedsSession {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not signed in"})
+		return
+	} else if err != nil {
+		fmt.Println("Error generating image variant: ", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	serveStoredImage(c, hash, variantCacheControl)
+}
@@ -0,0 +1,40 @@
+package spartanreport
+
+import "testing"
+
+func TestValidVariantPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"progression/Inventory/Armor/Helmets/013-001-olympus-c13d0b38.png", true},
+		{"progression/inventory/emblems/emblem_1-x.png", true},
+		{"", false},
+		{"../progression/a.png", false},
+		{"progression/../../a.png", false},
+		{"progression/./a.png", false},
+		{"progression//a.png", false},
+		{"/progression/a.png", false},
+		{"progression/a.png?x=1", false},
+		{"progression/a.png#top", false},
+		{"progression/a b.png", false},
+		{"progression/%2e%2e/a.png", false},
+	}
+	for _, tt := range tests {
+		if got := validVariantPath(tt.path); got != tt.want {
+			t.Errorf("validVariantPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestImageVariantKey(t *testing.T) {
+	fit := ImageVariant{Path: "a.png", Width: 140, Height: 140, Format: "png"}
+	exact := fit
+	exact.Exact = true
+	if got := fit.key(); got != "140x140.png:a.png" {
+		t.Errorf("key = %q", got)
+	}
+	if fit.key() == exact.key() {
+		t.Errorf("fit and exact variants share the key %q", fit.key())
+	}
+}
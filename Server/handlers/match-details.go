@@ -1,9 +1,10 @@
 package spartanreport
 
 import (
+	"context"
 	"fmt"
 	"net/http"
-	requests "spartanreport/requests"
+	"spartanreport/haloapi"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -14,9 +15,10 @@ type AssetStats struct {
 }
 
 type Medal struct {
-	NameId                    int64 `json:"NameId"`
-	Count                     int   `json:"Count"`
-	TotalPersonalScoreAwarded int   `json:"TotalPersonalScoreAwarded"`
+	NameId                    int64            `json:"NameId"`
+	Count                     int              `json:"Count"`
+	TotalPersonalScoreAwarded int              `json:"TotalPersonalScoreAwarded"`
+	Definition                *MedalDefinition `json:"Definition,omitempty" bson:"-"` // Filled in by ResolveMatchMedals, never stored
 }
 
 type PersonalScore struct {
@@ -58,7 +60,8 @@ type AssetDetailed struct {
 }
 
 type ParticipationInfo struct {
-	FirstJoinedTime string `json:"FirstJoinedTime"`
+	FirstJoinedTime     string `json:"FirstJoinedTime"`
+	PresentAtCompletion bool   `json:"PresentAtCompletion"`
 }
 
 type PlayerTeamStats struct {
@@ -83,6 +86,9 @@ type PlayerProfile struct {
 type Player struct {
 	PlayerId          string            `json:"PlayerId"`
 	PlayerType        int               `json:"PlayerType"`
+	LastTeamId        int               `json:"LastTeamId"`
+	Outcome           int               `json:"Outcome"`
+	Rank              int               `json:"Rank"`
 	ParticipationInfo ParticipationInfo `json:"ParticipationInfo"`
 	PlayerTeamStats   []PlayerTeamStats `json:"PlayerTeamStats"`
 	Profile           PlayerProfile     // Add this line to include the PlayerProfile
@@ -98,8 +104,7 @@ type MapData struct {
 	// Add other fields as needed
 }
 type CompositeData struct {
-	GamerInfo     requests.GamerInfo `json:"gamerInfo"`
-	SelectedMatch Match              `json:"selectedMatch"`
+	SelectedMatch Match `json:"selectedMatch"`
 }
 
 func HandleMatch(c *gin.Context) {
@@ -110,25 +115,25 @@ func HandleMatch(c *gin.Context) {
 		return
 	}
 
-	spartanKey := compData.GamerInfo.SpartanKey
+	creds := GetSessionGamerInfo(c).Credentials()
 
 	matchStats := compData.SelectedMatch
 	fmt.Println("stats", matchStats.Players)
-	fetchPlayerProfiles(spartanKey, &matchStats)
+	fetchPlayerProfiles(c.Request.Context(), creds, &matchStats)
+	ResolveMatchMedals(c.Request.Context(), creds, &matchStats)
 	c.JSON(http.StatusOK, matchStats)
 }
 
-func GetMatchStats(c *gin.Context, spartanToken string, matchId string) (Match, error) {
+func GetMatchStats(ctx context.Context, creds haloapi.Credentials, matchId string) (Match, error) {
 	var data Match
-	url := fmt.Sprintf("https://halostats.svc.halowaypoint.com/hi/matches/%s/stats", matchId)
-	err := makeAPIRequest(spartanToken, url, nil, &data)
+	err := haloapi.Default.MatchStats(ctx, creds, matchId, &data)
 	if err != nil {
 		return data, err
 	}
 	return data, nil
 }
 
-func formatMatchStats(spartanToken string, match Match) Match {
+func formatMatchStats(ctx context.Context, creds haloapi.Credentials, match Match) Match {
 	assetID := match.MatchInfo.MapVariant.AssetId
 	versionID := match.MatchInfo.MapVariant.VersionId
 
@@ -137,9 +142,8 @@ func formatMatchStats(spartanToken string, match Match) Match {
 		return match
 	}
 
-	url := fmt.Sprintf("https://discovery-infiniteugc.svc.halowaypoint.com/hi/maps/%s/versions/%s", assetID, versionID)
 	var rawResponse map[string]interface{}
-	if err := makeAPIRequest(spartanToken, url, nil, &rawResponse); err != nil {
+	if err := haloapi.Default.MapVersion(ctx, creds, assetID, versionID, &rawResponse); err != nil {
 		fmt.Println("Error fetching map details:", err)
 		return match
 	}
@@ -172,7 +176,7 @@ func formatMatchStats(spartanToken string, match Match) Match {
 	return match
 }
 
-func fetchPlayerProfiles(spartanToken string, match *Match) {
+func fetchPlayerProfiles(ctx context.Context, creds haloapi.Credentials, match *Match) {
 	var xuids []string
 	for _, player := range match.Players {
 		xuid := player.PlayerId
@@ -183,11 +187,9 @@ func fetchPlayerProfiles(spartanToken string, match *Match) {
 		xuid = strings.TrimSuffix(xuid, ")")
 		xuids = append(xuids, xuid)
 	}
-	xuidsStr := strings.Join(xuids, ",")
-	url := fmt.Sprintf("https://profile.svc.halowaypoint.com/users?xuids=%s", xuidsStr)
 
 	var playerProfiles []PlayerProfile
-	err := makeAPIRequest(spartanToken, url, nil, &playerProfiles)
+	err := haloapi.Default.Users(ctx, creds, xuids, &playerProfiles)
 	if err != nil {
 		fmt.Println("Error while fetching player profiles:", err)
 		return
@@ -205,7 +207,6 @@ func fetchPlayerProfiles(spartanToken string, match *Match) {
 	}
 }
 
-func FetchPlaylistDetails(spartanToken, assetID, versionID string, playlistInfo *PlaylistInfo) error {
-	url := fmt.Sprintf("https://discovery-infiniteugc.svc.halowaypoint.com/hi/Playlists/%s/versions/%s", assetID, versionID)
-	return makeAPIRequest(spartanToken, url, nil, playlistInfo)
+func FetchPlaylistDetails(ctx context.Context, creds haloapi.Credentials, assetID, versionID string, playlistInfo *PlaylistInfo) error {
+	return haloapi.Default.PlaylistVersion(ctx, creds, assetID, versionID, playlistInfo)
 }
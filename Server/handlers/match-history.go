@@ -0,0 +1,323 @@
+package spartanreport
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"spartanreport/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultMatchHistoryLimit = 25
+	maxMatchHistoryLimit     = 100
+)
+
+// Halo's match outcome codes, as used on Player.Outcome and Result.Outcome
+var matchOutcomes = map[string]int{
+	"tie":  1,
+	"win":  2,
+	"loss": 3,
+	"dnf":  4,
+}
+
+var xuidPattern = regexp.MustCompile(`^\d+$`)
+
+// Playlists stored before HasCsr was read count as ranked by name
+var rankedPlaylistPattern = primitive.Regex{Pattern: "ranked", Options: "i"}
+
+// ErrInvalidCursor is returned for cursors that weren't handed out by GetMatchHistory
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// MatchHistoryPage is one page of GET /players/:xuid/matches
+type MatchHistoryPage struct {
+	Results    []Result
+	NextCursor string // Pass back as ?cursor= for the next page, empty on the last page
+}
+
+// matchHistoryIndexes back the player filter plus the playlist and map filters, all sorted newest first
+var matchHistoryIndexes = []bson.D{
+	{{Key: "players.playerid", Value: 1}, {Key: "matchinfo.starttime", Value: -1}, {Key: "MatchId", Value: -1}},
+	{{Key: "players.playerid", Value: 1}, {Key: "matchinfo.playlist.assetid", Value: 1}, {Key: "matchinfo.starttime", Value: -1}, {Key: "MatchId", Value: -1}},
+	{{Key: "players.playerid", Value: 1}, {Key: "matchinfo.mapvariant.assetid", Value: 1}, {Key: "matchinfo.starttime", Value: -1}, {Key: "MatchId", Value: -1}},
+}
+
+// CreateMatchHistoryIndexes creates the detailed_matches indexes used by GetMatchHistory
+func CreateMatchHistoryIndexes() error {
+	for _, keys := range matchHistoryIndexes {
+		if err := db.CreateIndex("detailed_matches", keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// playerOutcomesBackfilled marks the matches stored before Player carried LastTeamId and Outcome as backfilled
+const playerOutcomesBackfilled = "migrations:player_outcomes_backfilled"
+
+// BackfillPlayerOutcomes fills in LastTeamId and Outcome on players of matches stored before Player carried them,
+// using the last team each player was on. It only runs once, matches stored since carry them already.
+func BackfillPlayerOutcomes(ctx context.Context) error {
+	first, err := db.RedisClient.SetNX(ctx, playerOutcomesBackfilled, time.Now().UTC().Format(time.RFC3339), 0).Result()
+	if err != nil || !first {
+		return err
+	}
+
+	lastTeamID := bson.M{"$arrayElemAt": bson.A{"$$player.playerteamstats.teamid", -1}}
+	teamOutcome := bson.M{"$arrayElemAt": bson.A{
+		bson.M{"$map": bson.M{
+			"input": bson.M{"$filter": bson.M{
+				"input": "$teams",
+				"as":    "team",
+				"cond":  bson.M{"$eq": bson.A{"$$team.teamid", lastTeamID}},
+			}},
+			"as": "team",
+			"in": "$$team.outcome",
+		}},
+		0,
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"players": bson.M{"$map": bson.M{
+			"input": "$players",
+			"as":    "player",
+			"in": bson.M{"$mergeObjects": bson.A{
+				"$$player",
+				bson.M{"lastteamid": lastTeamID, "outcome": teamOutcome},
+			}},
+		}},
+	}}}}
+
+	filter := bson.M{"players": bson.M{"$elemMatch": bson.M{"outcome": bson.M{"$exists": false}}}}
+	res, err := db.GetCollection("detailed_matches").UpdateMany(ctx, filter, update)
+	if err != nil {
+		// Leave it to the next start
+		db.RedisClient.Del(ctx, playerOutcomesBackfilled)
+		return err
+	}
+	if res.ModifiedCount > 0 {
+		fmt.Println("Backfilled player outcomes on", res.ModifiedCount, "matches")
+	}
+	return nil
+}
+
+// MatchHistoryQuery holds the filters of GET /players/:xuid/matches, zero values mean no filter
+type MatchHistoryQuery struct {
+	XUID                string
+	Playlist            string // Playlist AssetId
+	Map                 string // MapVariant AssetId
+	GameVariantCategory *int
+	Outcome             int
+	From                time.Time
+	To                  time.Time
+	Ranked              *bool
+	Cursor              string
+	Limit               int
+}
+
+// ParseMatchHistoryQuery reads the filters off the query string
+func ParseMatchHistoryQuery(c *gin.Context) (MatchHistoryQuery, error) {
+	query := MatchHistoryQuery{
+		XUID:     c.Param("xuid"),
+		Playlist: c.Query("playlist"),
+		Map:      c.Query("map"),
+		Cursor:   c.Query("cursor"),
+		Limit:    defaultMatchHistoryLimit,
+	}
+	if !xuidPattern.MatchString(query.XUID) {
+		return query, errors.New("invalid xuid")
+	}
+	if category := c.Query("gameVariantCategory"); category != "" {
+		value, err := strconv.Atoi(category)
+		if err != nil {
+			return query, errors.New("invalid gameVariantCategory")
+		}
+		query.GameVariantCategory = &value
+	}
+	if outcome := c.Query("outcome"); outcome != "" {
+		value, ok := matchOutcomes[strings.ToLower(outcome)]
+		if !ok {
+			return query, errors.New("outcome must be one of win, loss, tie or dnf")
+		}
+		query.Outcome = value
+	}
+	for param, bound := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC 3339 date", param)
+			}
+			*bound = parsed
+		}
+	}
+	if ranked := c.Query("ranked"); ranked != "" {
+		value, err := strconv.ParseBool(ranked)
+		if err != nil {
+			return query, errors.New("invalid ranked")
+		}
+		query.Ranked = &value
+	}
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			return query, errors.New("invalid limit")
+		}
+		if value > maxMatchHistoryLimit {
+			value = maxMatchHistoryLimit
+		}
+		query.Limit = value
+	}
+	return query, nil
+}
+
+// The cursor is the StartTime and MatchId of the last match on a page, matches sort by both descending
+func encodeMatchCursor(match Match) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(match.MatchInfo.StartTime + "|" + match.MatchId))
+}
+
+func decodeMatchCursor(cursor string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", ErrInvalidCursor
+	}
+	if _, err := time.Parse(time.RFC3339Nano, parts[0]); err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	return parts[0], parts[1], nil
+}
+
+// matchTimeBound is the bound to compare the stored StartTime strings with. They're the upstream's RFC 3339 UTC strings,
+// whose fractional seconds vary in length, so only their fixed width part up to the second sorts the same as the time.
+// The bound is that part alone, t truncated to the second: every StartTime within that second sorts after it.
+func matchTimeBound(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05")
+}
+
+func matchHistoryFilter(query MatchHistoryQuery) (bson.M, error) {
+	playerID := "xuid(" + query.XUID + ")"
+	and := bson.A{}
+
+	if query.Outcome != 0 {
+		and = append(and, bson.M{"players": bson.M{"$elemMatch": bson.M{"playerid": playerID, "outcome": query.Outcome}}})
+	} else {
+		and = append(and, bson.M{"players.playerid": playerID})
+	}
+	if query.Playlist != "" {
+		and = append(and, bson.M{"matchinfo.playlist.assetid": query.Playlist})
+	}
+	if query.Map != "" {
+		and = append(and, bson.M{"matchinfo.mapvariant.assetid": query.Map})
+	}
+	if query.GameVariantCategory != nil {
+		and = append(and, bson.M{"matchinfo.gamevariantcategory": *query.GameVariantCategory})
+	}
+	if !query.From.IsZero() {
+		and = append(and, bson.M{"matchinfo.starttime": bson.M{"$gte": matchTimeBound(query.From)}})
+	}
+	if !query.To.IsZero() {
+		and = append(and, bson.M{"matchinfo.starttime": bson.M{"$lt": matchTimeBound(query.To)}})
+	}
+	if query.Ranked != nil {
+		ranked := bson.M{"$or": bson.A{
+			bson.M{"matchinfo.playlistinfo.hascsr": true},
+			bson.M{"matchinfo.playlistinfo.publicname": rankedPlaylistPattern},
+		}}
+		if *query.Ranked {
+			and = append(and, ranked)
+		} else {
+			and = append(and, bson.M{"$nor": bson.A{ranked}})
+		}
+	}
+	if query.Cursor != "" {
+		startTime, matchID, err := decodeMatchCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"matchinfo.starttime": bson.M{"$lt": startTime}},
+			bson.M{"matchinfo.starttime": startTime, "MatchId": bson.M{"$lt": matchID}},
+		}})
+	}
+	return bson.M{"$and": and}, nil
+}
+
+// GetMatchHistory reads one page of a player's matches from detailed_matches, newest first
+func GetMatchHistory(ctx context.Context, query MatchHistoryQuery) (MatchHistoryPage, error) {
+	page := MatchHistoryPage{Results: []Result{}}
+	filter, err := matchHistoryFilter(query)
+	if err != nil {
+		return page, err
+	}
+
+	// One extra match tells whether there's a next page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "matchinfo.starttime", Value: -1}, {Key: "MatchId", Value: -1}}).
+		SetLimit(int64(query.Limit + 1))
+	cursor, err := db.GetCollection("detailed_matches").Find(ctx, filter, opts)
+	if err != nil {
+		return page, err
+	}
+	var matches []Match
+	if err := cursor.All(ctx, &matches); err != nil {
+		return page, err
+	}
+
+	if len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+		page.NextCursor = encodeMatchCursor(matches[len(matches)-1])
+	}
+
+	playerID := "xuid(" + query.XUID + ")"
+	for _, match := range matches {
+		result := Result{MatchId: match.MatchId, Match: match}
+		for _, player := range match.Players {
+			if player.PlayerId == playerID {
+				result.LastTeamId = player.LastTeamId
+				result.Outcome = player.Outcome
+				result.Rank = player.Rank
+				result.PresentAtEndOfMatch = player.ParticipationInfo.PresentAtCompletion
+				break
+			}
+		}
+		page.Results = append(page.Results, result)
+	}
+	return page, nil
+}
+
+// HandleMatchHistory serves GET /players/:xuid/matches from the stored matches, without calling Halo Waypoint
+func HandleMatchHistory(c *gin.Context) {
+	query, err := ParseMatchHistoryQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := GetMatchHistory(c.Request.Context(), query)
+	if err != nil {
+		if err == ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		HandleError(c, err)
+		return
+	}
+	creds := GetSessionGamerInfo(c).Credentials()
+	for i := range page.Results {
+		ResolveMatchMedals(c.Request.Context(), creds, &page.Results[i].Match)
+	}
+	c.JSON(http.StatusOK, page)
+}
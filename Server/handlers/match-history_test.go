@@ -0,0 +1,74 @@
+package spartanreport
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestMatchCursorRoundTrip(t *testing.T) {
+	tests := []Match{
+		{MatchId: "2a5d6a7e-1111-4c3e-9f00-000000000001", MatchInfo: MatchInfo{StartTime: "2024-03-10T18:00:00.123Z"}},
+		{MatchId: "2a5d6a7e-1111-4c3e-9f00-000000000002", MatchInfo: MatchInfo{StartTime: "2024-03-10T18:00:00Z"}},
+		// Only the first separator splits, whatever follows is the MatchId
+		{MatchId: "odd|id", MatchInfo: MatchInfo{StartTime: "2024-03-10T18:00:00Z"}},
+	}
+	for _, match := range tests {
+		cursor := encodeMatchCursor(match)
+		startTime, matchID, err := decodeMatchCursor(cursor)
+		if err != nil {
+			t.Fatalf("decodeMatchCursor(encodeMatchCursor(%q)): %v", match.MatchId, err)
+		}
+		if startTime != match.MatchInfo.StartTime || matchID != match.MatchId {
+			t.Errorf("round trip gave %q, %q, want %q, %q", startTime, matchID, match.MatchInfo.StartTime, match.MatchId)
+		}
+	}
+}
+
+func TestDecodeMatchCursorRejectsMalformed(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("2024-03-10T18:00:00Z|abcd"))},
+		{"standard alphabet", "+/+/"},
+		{"no separator", encode("2024-03-10T18:00:00Z")},
+		{"empty match id", encode("2024-03-10T18:00:00Z|")},
+		{"empty start time", encode("|abc")},
+		{"start time that isn't a time", encode("yesterday|abc")},
+		{"only a separator", encode("|")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if startTime, matchID, err := decodeMatchCursor(tt.cursor); err != ErrInvalidCursor {
+				t.Errorf("decodeMatchCursor = %q, %q, %v, want ErrInvalidCursor", startTime, matchID, err)
+			}
+		})
+	}
+}
+
+func TestMatchHistoryFilterRejectsBadCursor(t *testing.T) {
+	if _, err := matchHistoryFilter(MatchHistoryQuery{XUID: "1", Cursor: "!!!"}); err != ErrInvalidCursor {
+		t.Errorf("matchHistoryFilter with a bad cursor = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestMatchTimeBoundOrdersStoredStartTimes(t *testing.T) {
+	bound := matchTimeBound(time.Date(2024, time.March, 10, 18, 0, 0, 500, time.FixedZone("CET", 60*60)))
+	if bound != "2024-03-10T17:00:00" {
+		t.Fatalf("matchTimeBound = %q, want the UTC time truncated to the second", bound)
+	}
+	// From the bound's second on, whatever the length of their fractional seconds
+	for _, startTime := range []string{"2024-03-10T17:00:00Z", "2024-03-10T17:00:00.1Z", "2024-03-10T17:00:00.123456Z", "2024-03-10T17:00:01Z"} {
+		if startTime < bound {
+			t.Errorf("%q sorts before the bound %q", startTime, bound)
+		}
+	}
+	for _, startTime := range []string{"2024-03-10T16:59:59Z", "2024-03-10T16:59:59.99Z"} {
+		if startTime >= bound {
+			t.Errorf("%q sorts from the bound %q on", startTime, bound)
+		}
+	}
+}
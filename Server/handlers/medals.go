@@ -0,0 +1,378 @@
+package spartanreport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"spartanreport/db"
+	"spartanreport/haloapi"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// medalSpriteSize is the sprite sheet medals are cut from, the sheets come in small, medium and extra-large
+const medalSpriteSize = "medium"
+
+// medalSpritePrefix is where gamecms keeps the medal sprite sheets, GET /gamecms/*path serves them too
+const medalSpritePrefix = "hi/Waypoint/file/medals/images/"
+
+// rarestMedalCount is how many medals GET /players/:xuid/medals lists as the player's rarest
+const rarestMedalCount = 10
+
+// MedalMetadataFile is medals/metadata.json under hi/Waypoint/file/
+type MedalMetadataFile struct {
+	Difficulties []string `json:"difficulties"`
+	Types        []string `json:"types"`
+	Sprites      map[string]struct {
+		Path    string `json:"path"`
+		Size    int    `json:"size"`
+		Columns int    `json:"columns"`
+	} `json:"sprites"`
+	Medals []struct {
+		NameId          int64                  `json:"nameId"`
+		Name            struct{ Value string } `json:"name"`
+		Description     struct{ Value string } `json:"description"`
+		SpriteIndex     int                    `json:"spriteIndex"`
+		SortingWeight   int                    `json:"sortingWeight"`
+		DifficultyIndex int                    `json:"difficultyIndex"`
+		TypeIndex       int                    `json:"typeIndex"`
+		PersonalScore   int                    `json:"personalScore"`
+	} `json:"medals"`
+}
+
+// MedalDefinition is one medal of the catalog, stored in medal_metadata
+type MedalDefinition struct {
+	NameId          int64  `bson:"nameid" json:"NameId"`
+	Name            string `bson:"name" json:"Name"`
+	Description     string `bson:"description" json:"Description"`
+	Difficulty      string `bson:"difficulty" json:"Difficulty"`
+	DifficultyIndex int    `bson:"difficultyindex" json:"DifficultyIndex"` // Higher is harder, normal < heroic < legendary < mythic
+	Type            string `bson:"type" json:"Type"`
+	SortingWeight   int    `bson:"sortingweight" json:"SortingWeight"`
+	SpriteSheet     string `bson:"spritesheet" json:"SpriteSheet"` // gamecms path, served by GET /gamecms/*path
+	SpriteX         int    `bson:"spritex" json:"SpriteX"`
+	SpriteY         int    `bson:"spritey" json:"SpriteY"`
+	SpriteSize      int    `bson:"spritesize" json:"SpriteSize"`
+}
+
+const (
+	medalCatalogTTL      = 24 * time.Hour   // gamecms is asked for new medals this often
+	medalRefetchInterval = 10 * time.Minute // Least time between fetches for medals the catalog doesn't know
+)
+
+var (
+	medalCatalog          map[int64]MedalDefinition
+	medalCatalogCheckedAt time.Time // Last time gamecms was asked, zero since the server started
+	medalCatalogMutex     sync.Mutex
+)
+
+// CreateMedalIndexes creates the unique medal_metadata nameid index the catalog is upserted by.
+// medal_metadata is only a copy of gamecms' file, so one holding duplicates from before the index is dropped and refilled.
+func CreateMedalIndexes() error {
+	index := mongo.IndexModel{Keys: bson.D{{Key: "nameid", Value: 1}}, Options: options.Index().SetUnique(true)}
+	_, err := db.GetCollection("medal_metadata").Indexes().CreateOne(context.TODO(), index)
+	if mongo.IsDuplicateKeyError(err) {
+		fmt.Println("Dropping medal_metadata, it holds duplicate medals")
+		if err := db.GetCollection("medal_metadata").Drop(context.TODO()); err != nil {
+			return err
+		}
+		_, err = db.GetCollection("medal_metadata").Indexes().CreateOne(context.TODO(), index)
+	}
+	return err
+}
+
+// medalCatalogNeedsFetch reports whether gamecms should be asked for the catalog: there's none yet,
+// it's older than medalCatalogTTL, or one of nameIDs is missing and the last fetch is medalRefetchInterval ago
+func medalCatalogNeedsFetch(catalog map[int64]MedalDefinition, checkedAt time.Time, nameIDs []int64, now time.Time) bool {
+	if len(catalog) == 0 || now.Sub(checkedAt) >= medalCatalogTTL {
+		return true
+	}
+	if now.Sub(checkedAt) < medalRefetchInterval {
+		return false
+	}
+	for _, nameID := range nameIDs {
+		if _, ok := catalog[nameID]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMedalCatalog returns every medal keyed by NameId. nameIDs are the medals the caller is about to look up.
+// The catalog is read from medal_metadata and refreshed from gamecms once a day, or sooner when one of nameIDs is missing,
+// so medals added in a new season resolve. When gamecms can't be reached the catalog on hand is used.
+func GetMedalCatalog(ctx context.Context, creds haloapi.Credentials, nameIDs ...int64) (map[int64]MedalDefinition, error) {
+	medalCatalogMutex.Lock()
+	defer medalCatalogMutex.Unlock()
+	if medalCatalog == nil {
+		var medals []MedalDefinition
+		if err := db.BulkGetData("medal_metadata", bson.M{}, &medals); err != nil {
+			return nil, err
+		}
+		medalCatalog = medalCatalogOf(medals)
+	}
+	if !medalCatalogNeedsFetch(medalCatalog, medalCatalogCheckedAt, nameIDs, time.Now()) {
+		return medalCatalog, nil
+	}
+
+	medalCatalogCheckedAt = time.Now()
+	fetched, err := fetchMedalMetadata(ctx, creds)
+	if err == nil {
+		err = storeMedalDefinitions(ctx, fetched)
+	}
+	if err != nil {
+		if len(medalCatalog) == 0 {
+			return nil, err
+		}
+		fmt.Println("Error refreshing medal catalog: ", err)
+		return medalCatalog, nil
+	}
+	medalCatalog = medalCatalogOf(fetched)
+	return medalCatalog, nil
+}
+
+func medalCatalogOf(medals []MedalDefinition) map[int64]MedalDefinition {
+	catalog := make(map[int64]MedalDefinition, len(medals))
+	for _, medal := range medals {
+		catalog[medal.NameId] = medal
+	}
+	return catalog
+}
+
+// storeMedalDefinitions upserts the medals by NameId, so servers refreshing at once don't store a medal twice
+func storeMedalDefinitions(ctx context.Context, medals []MedalDefinition) error {
+	if len(medals) == 0 {
+		return nil
+	}
+	writes := make([]mongo.WriteModel, len(medals))
+	for i, medal := range medals {
+		writes[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"nameid": medal.NameId}).SetReplacement(medal).SetUpsert(true)
+	}
+	if _, err := db.GetCollection("medal_metadata").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("Error storing medal metadata: %v", err)
+	}
+	return nil
+}
+
+func fetchMedalMetadata(ctx context.Context, creds haloapi.Credentials) ([]MedalDefinition, error) {
+	var metadata MedalMetadataFile
+	if err := haloapi.Default.WaypointFile(ctx, creds, "medals/metadata.json", &metadata); err != nil {
+		return nil, fmt.Errorf("Error fetching medal metadata: %v", err)
+	}
+	sprite := metadata.Sprites[medalSpriteSize]
+	if sprite.Columns == 0 {
+		return nil, fmt.Errorf("medal metadata has no %s sprite sheet", medalSpriteSize)
+	}
+
+	medals := make([]MedalDefinition, 0, len(metadata.Medals))
+	for _, medal := range metadata.Medals {
+		definition := MedalDefinition{
+			NameId:          medal.NameId,
+			Name:            medal.Name.Value,
+			Description:     medal.Description.Value,
+			DifficultyIndex: medal.DifficultyIndex,
+			SortingWeight:   medal.SortingWeight,
+			SpriteSheet:     "hi/Waypoint/file/" + sprite.Path,
+			SpriteX:         (medal.SpriteIndex % sprite.Columns) * sprite.Size,
+			SpriteY:         (medal.SpriteIndex / sprite.Columns) * sprite.Size,
+			SpriteSize:      sprite.Size,
+		}
+		if medal.DifficultyIndex < len(metadata.Difficulties) {
+			definition.Difficulty = metadata.Difficulties[medal.DifficultyIndex]
+		}
+		if medal.TypeIndex < len(metadata.Types) {
+			definition.Type = metadata.Types[medal.TypeIndex]
+		}
+		medals = append(medals, definition)
+	}
+	return medals, nil
+}
+
+// ResolveMatchMedals fills in the catalog entry of every medal in a match.
+// Medals missing from the catalog keep just their NameId.
+func ResolveMatchMedals(ctx context.Context, creds haloapi.Credentials, match *Match) {
+	var nameIDs []int64
+	for _, team := range match.Teams {
+		for _, medal := range team.Stats.CoreStats.Medals {
+			nameIDs = append(nameIDs, medal.NameId)
+		}
+	}
+	for _, player := range match.Players {
+		for _, teamStats := range player.PlayerTeamStats {
+			for _, medal := range teamStats.Stats.CoreStats.Medals {
+				nameIDs = append(nameIDs, medal.NameId)
+			}
+		}
+	}
+	catalog, err := GetMedalCatalog(ctx, creds, nameIDs...)
+	if err != nil {
+		fmt.Println("Error loading medal catalog: ", err)
+		return
+	}
+	resolve := func(medals []Medal) {
+		for i := range medals {
+			if definition, ok := catalog[medals[i].NameId]; ok {
+				medals[i].Definition = &definition
+			}
+		}
+	}
+	for i := range match.Teams {
+		resolve(match.Teams[i].Stats.CoreStats.Medals)
+	}
+	for i := range match.Players {
+		for j := range match.Players[i].PlayerTeamStats {
+			resolve(match.Players[i].PlayerTeamStats[j].Stats.CoreStats.Medals)
+		}
+	}
+}
+
+// PlayerMedalStat is a player's record with one medal
+type PlayerMedalStat struct {
+	NameId      int64            `bson:"_id" json:"NameId"`
+	Count       int              `bson:"count" json:"Count"`
+	MatchesWith int              `bson:"matcheswith" json:"MatchesWith"` // Matches the medal was earned in at least once
+	PerGame     float64          `bson:"-" json:"PerGame"`
+	Definition  *MedalDefinition `bson:"-" json:"Definition,omitempty"`
+}
+
+// PlayerMedalStats is the response of GET /players/:xuid/medals
+type PlayerMedalStats struct {
+	Matches     int
+	TotalMedals int
+	Medals      []PlayerMedalStat // Most earned first
+	Rarest      []PlayerMedalStat // Hardest difficulty first, then least earned per game
+}
+
+// GetPlayerMedalStats totals a player's medals over their stored matches, the MatchHistoryQuery filters narrow the matches
+func GetPlayerMedalStats(ctx context.Context, creds haloapi.Credentials, query MatchHistoryQuery) (PlayerMedalStats, error) {
+	stats := PlayerMedalStats{Medals: []PlayerMedalStat{}, Rarest: []PlayerMedalStat{}}
+	query.Cursor = ""
+	filter, err := matchHistoryFilter(query)
+	if err != nil {
+		return stats, err
+	}
+
+	playerID := "xuid(" + query.XUID + ")"
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: bson.M{
+			"matches": bson.A{bson.M{"$count": "count"}},
+			"medals": bson.A{
+				bson.M{"$project": bson.M{"player": bson.M{"$arrayElemAt": bson.A{
+					bson.M{"$filter": bson.M{
+						"input": "$players",
+						"as":    "player",
+						"cond":  bson.M{"$eq": bson.A{"$$player.playerid", playerID}},
+					}},
+					0,
+				}}}},
+				bson.M{"$unwind": "$player.playerteamstats"},
+				bson.M{"$unwind": "$player.playerteamstats.stats.corestats.medals"},
+				bson.M{"$group": bson.M{
+					"_id":         "$player.playerteamstats.stats.corestats.medals.nameid",
+					"count":       bson.M{"$sum": "$player.playerteamstats.stats.corestats.medals.count"},
+					"matcheswith": bson.M{"$addToSet": "$_id"},
+				}},
+				bson.M{"$addFields": bson.M{"matcheswith": bson.M{"$size": "$matcheswith"}}},
+			},
+		}}},
+	}
+
+	cursor, err := db.GetCollection("detailed_matches").Aggregate(ctx, pipeline)
+	if err != nil {
+		return stats, err
+	}
+	defer cursor.Close(ctx)
+	var result struct {
+		Matches []struct {
+			Count int `bson:"count"`
+		} `bson:"matches"`
+		Medals []PlayerMedalStat `bson:"medals"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return stats, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return stats, err
+	}
+	if len(result.Matches) == 0 || len(result.Medals) == 0 {
+		return stats, nil
+	}
+	stats.Matches = result.Matches[0].Count
+
+	nameIDs := make([]int64, len(result.Medals))
+	for i, medal := range result.Medals {
+		nameIDs[i] = medal.NameId
+	}
+	catalog, err := GetMedalCatalog(ctx, creds, nameIDs...)
+	if err != nil {
+		fmt.Println("Error loading medal catalog: ", err)
+	}
+	for _, medal := range result.Medals {
+		medal.PerGame = float64(medal.Count) / float64(stats.Matches)
+		if definition, ok := catalog[medal.NameId]; ok {
+			medal.Definition = &definition
+		}
+		stats.TotalMedals += medal.Count
+		stats.Medals = append(stats.Medals, medal)
+	}
+	sort.Slice(stats.Medals, func(i, j int) bool {
+		if stats.Medals[i].Count != stats.Medals[j].Count {
+			return stats.Medals[i].Count > stats.Medals[j].Count
+		}
+		return stats.Medals[i].NameId < stats.Medals[j].NameId
+	})
+
+	stats.Rarest = append(stats.Rarest, stats.Medals...)
+	difficulty := func(medal PlayerMedalStat) int {
+		if medal.Definition == nil {
+			return -1
+		}
+		return medal.Definition.DifficultyIndex
+	}
+	sort.SliceStable(stats.Rarest, func(i, j int) bool {
+		if difficulty(stats.Rarest[i]) != difficulty(stats.Rarest[j]) {
+			return difficulty(stats.Rarest[i]) > difficulty(stats.Rarest[j])
+		}
+		return stats.Rarest[i].PerGame < stats.Rarest[j].PerGame
+	})
+	if len(stats.Rarest) > rarestMedalCount {
+		stats.Rarest = stats.Rarest[:rarestMedalCount]
+	}
+	return stats, nil
+}
+
+// HandlePlayerMedals serves GET /players/:xuid/medals, it takes the filters of GET /players/:xuid/matches
+func HandlePlayerMedals(c *gin.Context) {
+	query, err := ParseMatchHistoryQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := GetPlayerMedalStats(c.Request.Context(), GetSessionGamerInfo(c).Credentials(), query)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// HandleMedalCatalog serves GET /medals, the whole catalog keyed by NameId
+func HandleMedalCatalog(c *gin.Context) {
+	catalog, err := GetMedalCatalog(c.Request.Context(), GetSessionGamerInfo(c).Credentials())
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "private, max-age="+fmt.Sprint(int(time.Hour.Seconds())))
+	c.JSON(http.StatusOK, catalog)
+}
@@ -0,0 +1,32 @@
+package spartanreport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMedalCatalogNeedsFetch(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 18, 0, 0, 0, time.UTC)
+	catalog := map[int64]MedalDefinition{1512363953: {NameId: 1512363953, Name: "Perfect"}}
+	tests := []struct {
+		name      string
+		catalog   map[int64]MedalDefinition
+		checkedAt time.Time
+		nameIDs   []int64
+		want      bool
+	}{
+		{"nothing stored yet", nil, now, nil, true},
+		{"loaded from Mongo but never checked this run", catalog, time.Time{}, nil, true},
+		{"fresh and complete", catalog, now.Add(-time.Hour), []int64{1512363953}, false},
+		{"older than the TTL", catalog, now.Add(-medalCatalogTTL), nil, true},
+		{"unknown medal", catalog, now.Add(-medalRefetchInterval), []int64{1512363953, 622331684}, true},
+		{"unknown medal right after a fetch", catalog, now.Add(-time.Minute), []int64{622331684}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := medalCatalogNeedsFetch(tt.catalog, tt.checkedAt, tt.nameIDs, now); got != tt.want {
+				t.Errorf("medalCatalogNeedsFetch = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
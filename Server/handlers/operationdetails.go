@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"net/http"
 	"spartanreport/db"
-	requests "spartanreport/requests"
+	"spartanreport/haloapi"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 )
 
+// seasonTrackKey is the Redis hash operation reward tracks are cached in with their image URLs, keyed by OperationTrackPath
+const seasonTrackKey = "haloseasontracks"
+
 type OpsDetailsToReturn struct {
 	Season Season `json:"selectedSeason"`
 	Track  Track  `json:"track"`
@@ -25,8 +28,7 @@ func HandleOperationDetails(c *gin.Context) {
 	seasonFound := Season{}
 	// For each season in cachedSeasons check if the operation ID matches the one we are looking for
 	ctx := context.Background()
-	seasonID := "SeasonData"
-	cachedSeasons, exists := seasonCache.Get(ctx, seasonID)
+	cachedSeasons, _, exists := seasonCache.Get(ctx, seasonDataKey)
 	if exists {
 		for _, season := range cachedSeasons.Seasons {
 			if season.OperationTrackPath == operationPath {
@@ -39,17 +41,13 @@ func HandleOperationDetails(c *gin.Context) {
 		}
 	}
 
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error binding data": err.Error()})
-		return
-	}
+	gamerInfo := GetSessionGamerInfo(c)
 
 	key := seasonFound.OperationTrackPath
 	fmt.Println("key: ", key)
 
 	// Read from redis instead. Redis stores the data in a hash
-	obj, err := db.RedisClient.HGet(ctx, "haloseasondata", key).Result()
+	obj, err := db.RedisClient.HGet(ctx, seasonTrackKey, key).Result()
 	// Data exists, decode and return it
 	var trackData Track
 
@@ -63,7 +61,7 @@ func HandleOperationDetails(c *gin.Context) {
 		}
 
 		// Save the serialized JSON string to Redis
-		if err := db.RedisClient.HSet(ctx, "haloseasondata", key, trackJSON).Err(); err != nil {
+		if err := db.RedisClient.HSet(ctx, seasonTrackKey, key, trackJSON).Err(); err != nil {
 			fmt.Printf("error setting value in Redis: %v", err)
 		}
 
@@ -82,10 +80,7 @@ func HandleOperationDetails(c *gin.Context) {
 	// Retrieve user season progression and append it to the seasons data
 	userProgress := OperationRewardTracks{}
 	if gamerInfo.XUID != "" {
-		url := "https://economy.svc.halowaypoint.com/hi/players/xuid(" + gamerInfo.XUID + ")/rewardtracks/operations/" + operationID
-		fmt.Println("Querying: ", url)
-		hdrs := map[string]string{"343-clearance": gamerInfo.ClearanceCode}
-		if err := makeAPIRequest(gamerInfo.SpartanKey, url, hdrs, &userProgress); err != nil {
+		if err := haloapi.Default.OperationRewardTrack(c.Request.Context(), gamerInfo.Credentials(), gamerInfo.XUID, operationID, &userProgress); err != nil {
 			fmt.Println("Error while getting user season progression: ", err)
 			return
 		}
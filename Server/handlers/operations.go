@@ -3,10 +3,12 @@ package spartanreport
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
 	"spartanreport/db"
+	"spartanreport/haloapi"
 	requests "spartanreport/requests"
 	. "spartanreport/structures"
 	"strings"
@@ -14,7 +16,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis"
+	"github.com/go-redis/redis/v8"
 )
 
 type Date struct {
@@ -24,6 +26,7 @@ type Date struct {
 
 type Seasons struct {
 	Seasons []Season `json:"Seasons"`
+	Events  []Event  `json:"Events"`
 }
 
 type Season struct {
@@ -38,9 +41,16 @@ type Season struct {
 }
 
 type Event struct {
-	RewardTrackPath string `json:"RewardTrackPath"`
-	StartDate       Date   `json:"StartDate"`
-	EndDate         Date   `json:"EndDate"`
+	RewardTrackPath string                 `json:"RewardTrackPath"`
+	StartDate       Date                   `json:"StartDate"`
+	EndDate         Date                   `json:"EndDate"`
+	EventId         string                 `json:"EventId"`
+	IsActive        bool                   `json:"IsActive"`
+	IsUpcoming      bool                   `json:"IsUpcoming"`
+	Name            string                 `json:"Name"`
+	Description     string                 `json:"Description"`
+	EventImageURL   string                 `json:"EventImageURL"`
+	UserProgress    *OperationRewardTracks `json:"UserProgress,omitempty"`
 }
 
 type Root struct {
@@ -65,7 +75,7 @@ type SeasonMetadata struct {
 	NarrativeBlurb                  Field  `json:"NarrativeBlurb"`
 	BattlePassSeasonUpsellImagePath string `json:"BattlePassSeasonUpsellBackgroundImage"`
 	ProgressionBackgroundImage      string `json:"ProgressionBackgroundImage"`
-	SeasonImage                     string `json:"SeasonImage"`
+	SeasonImageURL                  string `json:"SeasonImageURL"`
 }
 
 type Field struct {
@@ -80,15 +90,15 @@ type InventoryReward struct {
 	InventoryItemPath string `json:"InventoryItemPath"`
 	Amount            int    `json:"Amount"`
 	Type              string `json:"Type"`
-	ItemImageData     string `json:"ItemImageData"`
+	ItemImageURL      string `json:"ItemImageURL"`
 	ItemMetaData      Item   `json:"Item"`
 }
 
 type CurrencyReward struct {
-	CurrencyPath  string `json:"CurrencyPath"`
-	Amount        int    `json:"Amount"`
-	ItemImageData string `json:"ItemImageData"`
-	ItemMetaData  Item   `json:"Item"`
+	CurrencyPath string `json:"CurrencyPath"`
+	Amount       int    `json:"Amount"`
+	ItemImageURL string `json:"ItemImageURL"`
+	ItemMetaData Item   `json:"Item"`
 }
 type Reward struct {
 	InventoryRewards []InventoryReward `json:"InventoryRewards"`
@@ -191,7 +201,7 @@ type SpecificOpsData struct {
 // Struct to hold the path and the image data
 type RewardResult struct {
 	Path         string
-	ImageData    string
+	ImageURL     string
 	Item         Item
 	DetailedItem ItemResponse
 }
@@ -223,46 +233,217 @@ type UserSeasonProgression struct {
 	ScheduledOperationRewardTrackPath string                `json:"ScheduledOperationRewardTrackPath"`
 }
 
+// seasonDataKey is the Redis key all season data is cached under
+const seasonDataKey = "SeasonData"
+
+// The season calendar is refetched this often, and as soon as a season in it starts or ends
+const seasonRefreshInterval = 6 * time.Hour
+
+// seasonRefreshRetry is how soon the background refresh tries again after failing
+const seasonRefreshRetry = time.Minute
+
+// seasonPartialRefresh is how soon a calendar is refetched when some of its season metadata or event tracks failed to load
+const seasonPartialRefresh = 10 * time.Minute
+
+// errNoSeasonCalendar is returned to signed out callers before any calendar has been cached
+var errNoSeasonCalendar = errors.New("The season calendar hasn't been loaded yet")
+
+// seasonCacheTTL only drops a calendar nothing has refreshed in a long while, a stale one is served until a refetch succeeds
+const seasonCacheTTL = 7 * 24 * time.Hour
+
 var (
-	seasonCache *SeasonCache
-	once        sync.Once
+	seasonCache        *SeasonCache
+	once               sync.Once
+	seasonRefreshMutex sync.Mutex // One refetch of the calendar at a time per server
 )
 
 type SeasonCache struct {
 }
 
-func (sc *SeasonCache) Get(ctx context.Context, seasonID string) (Seasons, bool) {
+// seasonCacheVersion is bumped when what processSeasons fills in changes, so calendars cached by older servers are refetched
+const seasonCacheVersion = 3
+
+// cachedSeasons is what's stored under seasonDataKey
+type cachedSeasons struct {
+	Version   int
+	Seasons   Seasons
+	FetchedAt time.Time
+	RefreshAt time.Time
+}
+
+// markActiveSeasons sets IsActive on the season and events running at now, and IsUpcoming on the events yet to start
+func markActiveSeasons(seasons *Seasons, now time.Time) {
+	for i := range seasons.Seasons {
+		startTime, _ := time.Parse(time.RFC3339, seasons.Seasons[i].StartDate.ISO8601Date)
+		endTime, _ := time.Parse(time.RFC3339, seasons.Seasons[i].EndDate.ISO8601Date)
+		seasons.Seasons[i].IsActive = now.After(startTime) && now.Before(endTime)
+	}
+	for i := range seasons.Events {
+		startTime, _ := time.Parse(time.RFC3339, seasons.Events[i].StartDate.ISO8601Date)
+		endTime, _ := time.Parse(time.RFC3339, seasons.Events[i].EndDate.ISO8601Date)
+		seasons.Events[i].IsActive = now.After(startTime) && now.Before(endTime)
+		seasons.Events[i].IsUpcoming = now.Before(startTime)
+	}
+}
+
+// nextSeasonRefresh is when a calendar fetched at now is due a refetch: the next season or event start or end, or the interval if that's sooner.
+// A partial calendar, one missing some of its metadata or tracks, is due within seasonPartialRefresh.
+func nextSeasonRefresh(seasons Seasons, partial bool, now time.Time) time.Time {
+	var dates []Date
+	for _, season := range seasons.Seasons {
+		dates = append(dates, season.StartDate, season.EndDate)
+	}
+	for _, event := range seasons.Events {
+		dates = append(dates, event.StartDate, event.EndDate)
+	}
+
+	refreshAt := now.Add(seasonRefreshInterval)
+	if partial {
+		refreshAt = now.Add(seasonPartialRefresh)
+	}
+	for _, date := range dates {
+		t, err := time.Parse(time.RFC3339, date.ISO8601Date)
+		if err == nil && t.After(now) && t.Before(refreshAt) {
+			refreshAt = t
+		}
+	}
+	return refreshAt
+}
+
+// Get returns the cached calendar with IsActive worked out at read time. stale is set once it's due a refetch,
+// callers without a session to refetch with can still serve it.
+func (sc *SeasonCache) Get(ctx context.Context, seasonID string) (seasons Seasons, stale bool, found bool) {
+	cached, found := sc.load(ctx, seasonID)
+	if !found {
+		return Seasons{}, false, false
+	}
+	now := time.Now().UTC()
+	markActiveSeasons(&cached.Seasons, now)
+	return cached.Seasons, cached.stale(now), true
+}
+
+func (sc *SeasonCache) load(ctx context.Context, seasonID string) (cachedSeasons, bool) {
+	var cached cachedSeasons
 	val, err := db.RedisClient.Get(ctx, seasonID).Result()
 	if err != nil {
-		if err == redis.Nil {
-			fmt.Println("Covered in nil check")
-			return Seasons{}, false
-		} else {
+		if err != redis.Nil {
 			fmt.Printf("Error getting from Redis: %v\n", err)
-			return Seasons{}, false
 		}
+		return cached, false
+	}
+	if err := json.Unmarshal([]byte(val), &cached); err != nil {
+		// Calendars cached before the refresh policy existed don't decode and get refetched
+		fmt.Println("Error decoding cached seasons:", err)
+		return cached, false
 	}
+	return cached, true
+}
 
-	var data Seasons
-	err = json.Unmarshal([]byte(val), &data)
+func (cached cachedSeasons) stale(now time.Time) bool {
+	return cached.Version != seasonCacheVersion || !now.Before(cached.RefreshAt)
+}
+
+// Set replaces the cached calendar in one SET, so readers get either the old or the new one
+func (sc *SeasonCache) Set(ctx context.Context, seasonID string, data Seasons, partial bool) {
+	now := time.Now().UTC()
+	jsonData, err := json.Marshal(cachedSeasons{Version: seasonCacheVersion, Seasons: data, FetchedAt: now, RefreshAt: nextSeasonRefresh(data, partial, now)})
 	if err != nil {
-		fmt.Println("Error getting", err) // Handle the error appropriately
+		fmt.Println(err)
+		return
+	}
+	if err := db.RedisClient.Set(ctx, seasonID, jsonData, seasonCacheTTL).Err(); err != nil {
+		fmt.Println("Error caching seasons:", err)
+	}
+}
+
+// GetSeasons returns the season calendar, refetching it when the cached one is missing or due a refresh.
+// A failed refetch falls back to the stale calendar. Signed out callers never refetch, they're served
+// whatever StartSeasonRefresher or signed in players last cached.
+func GetSeasons(ctx context.Context, gamerInfo requests.GamerInfo) (Seasons, error) {
+	cached, stale, found := seasonCache.Get(ctx, seasonDataKey)
+	if found && !stale {
+		return cached, nil
+	}
+	if gamerInfo.SpartanKey == "" {
+		if found {
+			return cached, nil
+		}
+		return Seasons{}, errNoSeasonCalendar
 	}
 
-	return data, true
+	seasonRefreshMutex.Lock()
+	defer seasonRefreshMutex.Unlock()
+	// Another request may have refetched it while this one waited
+	cached, stale, found = seasonCache.Get(ctx, seasonDataKey)
+	if found && !stale {
+		return cached, nil
+	}
+
+	seasons := Seasons{}
+	if err := haloapi.Default.ProgressionFile(ctx, gamerInfo.Credentials(), "calendars/seasons/seasoncalendar.json", &seasons); err != nil {
+		if found {
+			fmt.Println("Error refreshing season calendar, serving the cached one: ", err)
+			return cached, nil
+		}
+		return seasons, err
+	}
+	processSeasons(gamerInfo, &seasons, true)
+	return seasons, nil
 }
 
-func (sc *SeasonCache) Set(ctx context.Context, seasonID string, data Seasons) {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		fmt.Println(err) // Handle the error appropriately
+// StartSeasonRefresher refetches the season calendar as the service account whenever it's due, so it's kept current
+// for /home and the other readers without a session. It sleeps until the cached calendar's RefreshAt, retrying failures
+// after seasonRefreshRetry, and does nothing without a service account.
+func StartSeasonRefresher(ctx context.Context) {
+	go func() {
+		if _, err := requests.ServiceGamerInfo(ctx); err == requests.ErrNoServiceAccount {
+			fmt.Println("No service account, the season calendar is only refreshed by signed in requests")
+			return
+		}
+		for {
+			wait := refreshSeasonsInBackground(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// refreshSeasonsInBackground refetches the calendar if it's due and returns how long until it's next due
+func refreshSeasonsInBackground(ctx context.Context) time.Duration {
+	cached, found := seasonCache.load(ctx, seasonDataKey)
+	now := time.Now().UTC()
+	if found && !cached.stale(now) {
+		return seasonRefreshWait(cached.RefreshAt, now)
 	}
 
-	// Set in Redis only if it does not already exist
-	_, err = db.RedisClient.SetNX(ctx, seasonID, jsonData, 0).Result()
+	gamerInfo, err := requests.ServiceGamerInfo(ctx)
 	if err != nil {
-		fmt.Println(err) // Handle the error appropriately
+		fmt.Println("Error signing in the service account for the season refresh:", err)
+		return seasonRefreshRetry
+	}
+	if _, err := GetSeasons(ctx, gamerInfo); err != nil {
+		fmt.Println("Error refreshing the season calendar:", err)
+		return seasonRefreshRetry
+	}
+
+	// A failed refetch leaves the stale calendar cached, GetSeasons serves it rather than erroring
+	cached, found = seasonCache.load(ctx, seasonDataKey)
+	now = time.Now().UTC()
+	if !found || cached.stale(now) {
+		return seasonRefreshRetry
+	}
+	return seasonRefreshWait(cached.RefreshAt, now)
+}
+
+// seasonRefreshWait is how long to sleep until refreshAt, never less than seasonRefreshRetry so the loop can't spin
+func seasonRefreshWait(refreshAt time.Time, now time.Time) time.Duration {
+	if wait := refreshAt.Sub(now); wait > seasonRefreshRetry {
+		return wait
 	}
+	return seasonRefreshRetry
 }
 
 func getCoreFromInventoryItemPath(inventoryItemPath string) string {
@@ -314,50 +495,34 @@ func getCoreIDFromInventoryItemPath(inventoryItemPath string) string {
 }
 
 func HandleOperations(c *gin.Context) {
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		fmt.Println("Couldn't bind")
-		return
-	}
-
-	ctx := context.Background()
-	seasonCache := &SeasonCache{}
-
-	// Use a static key for all season data
-	const seasonDataKey = "SeasonData"
+	gamerInfo := GetSessionGamerInfo(c)
 
-	// Check Redis cache first
-	seasonsData, found := seasonCache.Get(ctx, seasonDataKey)
-	if !found {
-		// Make API request if data not in cache
-		seasons := Seasons{}
-		err := makeAPIRequest(gamerInfo.SpartanKey, "https://gamecms-hacs.svc.halowaypoint.com/hi/progression/file/calendars/seasons/seasoncalendar.json", nil, &seasons)
-		if err != nil {
-			fmt.Println("Error Obtaining Season Info")
-			return
-		}
-
-		processSeasons(gamerInfo, &seasons, true)
-		seasonsData = seasons
+	seasonsData, err := GetSeasons(c.Request.Context(), gamerInfo)
+	if err != nil {
+		fmt.Println("Error Obtaining Season Info")
+		return
 	}
 
 	// Respond with the seasons data
 	data := OperationsData{
 		Seasons:   seasonsData,
-		GamerInfo: gamerInfo,
+		GamerInfo: gamerInfo.WithoutTokens(),
 	}
 	c.JSON(http.StatusOK, data)
 }
+
+// processSeasons fills in the season metadata and events of a freshly fetched calendar and caches it.
+// A calendar with any of those fetches failed is cached as partial so it's refetched soon.
 func processSeasons(gamerInfo requests.GamerInfo, seasons *Seasons, cache bool) {
-	// Populate IsActive flag
-	currentTime := time.Now().UTC()
+	// Populate IsActive flag, readers of the cache work it out again as they go
+	markActiveSeasons(seasons, time.Now().UTC())
+	partial := false
 	for i := range seasons.Seasons {
-		startTime, _ := time.Parse(time.RFC3339, seasons.Seasons[i].StartDate.ISO8601Date)
-		endTime, _ := time.Parse(time.RFC3339, seasons.Seasons[i].EndDate.ISO8601Date)
-		seasons.Seasons[i].IsActive = currentTime.After(startTime) && currentTime.Before(endTime)
-		seasons.Seasons[i].SeasonMetadataDetails = GetSeasonMetadata(gamerInfo, seasons.Seasons[i])
-
+		metadata, err := GetSeasonMetadata(gamerInfo, seasons.Seasons[i])
+		if err != nil {
+			partial = true
+		}
+		seasons.Seasons[i].SeasonMetadataDetails = metadata
 	}
 
 	// Sort seasons by start date in descending order
@@ -366,20 +531,21 @@ func processSeasons(gamerInfo requests.GamerInfo, seasons *Seasons, cache bool)
 		startTimeJ, _ := time.Parse(time.RFC3339, seasons.Seasons[j].StartDate.ISO8601Date)
 		return startTimeI.After(startTimeJ)
 	})
-
-	ctx := context.Background()
-	seasonCache := &SeasonCache{}
+	if err := processEvents(gamerInfo, seasons.Events); err != nil {
+		fmt.Println("Season calendar is missing events, refetching it soon: ", err)
+		partial = true
+	}
 
 	// Cache the processed seasons data using a static key
-	const seasonDataKey = "SeasonData"
-	seasonCache.Set(ctx, seasonDataKey, *seasons)
+	if cache {
+		seasonCache.Set(context.Background(), seasonDataKey, *seasons, partial)
+	}
 
 }
 
 func GetSeasonRewards(gamerInfo requests.GamerInfo, season Season) Track {
-	url := "https://gamecms-hacs.svc.halowaypoint.com/hi/progression/file/" + season.OperationTrackPath
 	trackData := Track{}
-	err := makeAPIRequest(gamerInfo.SpartanKey, url, nil, &trackData)
+	err := haloapi.Default.ProgressionFile(context.Background(), gamerInfo.Credentials(), season.OperationTrackPath, &trackData)
 	if err != nil {
 		fmt.Println("Error when getting track data: ", err)
 		return trackData
@@ -395,58 +561,58 @@ func GetTrackImages(gamerInfo requests.GamerInfo, Ranks []Rank) []Rank {
 	makeRequest := func(path string) {
 		// Determine the core based on InventoryItemPath
 		core := getCoreFromInventoryItemPath(path)
-		url := "https://gamecms-hacs.svc.halowaypoint.com/hi/progression/file/" + path
 		currentItemResponse := ItemResponse{}
 
 		// Make API Request to get item data
-		err := makeAPIRequest(gamerInfo.SpartanKey, url, nil, &currentItemResponse)
+		err := haloapi.Default.ProgressionFile(context.Background(), gamerInfo.Credentials(), path, &currentItemResponse)
 		if err != nil {
 			fmt.Println("Error making request for item data: ", err)
 		}
 		itemImagePath := currentItemResponse.CommonData.Media.Media.MediaUrl.Path
-		url = "https://gamecms-hacs.svc.halowaypoint.com/hi/images/file/" + itemImagePath
-		rawImageData, err := makeAPIRequestImage(gamerInfo.SpartanKey, url, nil)
+		imageURL, err := fetchImageURL(context.Background(), gamerInfo.Credentials(), itemImagePath)
 		if err != nil {
 			fmt.Println("Error getting item image: ", err)
 			results <- RewardResult{} // Send an empty result to ensure channel doesn't block
 		} else {
 			currentItemResponse.CommonData.CoreTitle = core // Assign Core
-			results <- RewardResult{Path: path, ImageData: rawImageData, Item: currentItemResponse.CommonData}
+			results <- RewardResult{Path: path, ImageURL: imageURL, Item: currentItemResponse.CommonData}
 		}
 	}
 
-	totalPaths := 0
+	var paths []string
 
 	for _, rank := range Ranks {
 		// Free Rewards
 		for _, invReward := range rank.FreeRewards.InventoryRewards {
 			if invReward.InventoryItemPath != "" {
-				go makeRequest(invReward.InventoryItemPath)
-				totalPaths++
+				paths = append(paths, invReward.InventoryItemPath)
 			}
 		}
 		for _, currReward := range rank.FreeRewards.CurrencyRewards {
 			if currReward.CurrencyPath != "" {
-				go makeRequest(currReward.CurrencyPath)
-				totalPaths++
+				paths = append(paths, currReward.CurrencyPath)
 			}
 		}
 
 		// Paid Rewards
 		for _, invReward := range rank.PaidRewards.InventoryRewards {
 			if invReward.InventoryItemPath != "" {
-				go makeRequest(invReward.InventoryItemPath)
-				totalPaths++
+				paths = append(paths, invReward.InventoryItemPath)
 			}
 		}
 		for _, currReward := range rank.PaidRewards.CurrencyRewards {
 			if currReward.CurrencyPath != "" {
-				go makeRequest(currReward.CurrencyPath)
-				totalPaths++
+				paths = append(paths, currReward.CurrencyPath)
 			}
 		}
 	}
 
+	// Run the requests on a bounded pool while the results are collected below
+	totalPaths := len(paths)
+	go haloapi.Default.FanOut(totalPaths, func(i int) {
+		makeRequest(paths[i])
+	})
+
 	// Collect results from the channel and update the InventoryReward/CurrencyReward structs
 	for i := 0; i < totalPaths; i++ {
 		result := <-results
@@ -454,8 +620,7 @@ func GetTrackImages(gamerInfo requests.GamerInfo, Ranks []Rank) []Rank {
 			// Update Free Rewards
 			for idx, invReward := range rank.FreeRewards.InventoryRewards {
 				if invReward.InventoryItemPath == result.Path {
-					result.ImageData, _ = compressPNGWithImaging(result.ImageData, false, 0, 0)
-					rank.FreeRewards.InventoryRewards[idx].ItemImageData = result.ImageData
+					rank.FreeRewards.InventoryRewards[idx].ItemImageURL = result.ImageURL
 					rank.FreeRewards.InventoryRewards[idx].ItemMetaData = result.Item
 					ctx := context.Background()
 					itemPath := rank.FreeRewards.InventoryRewards[idx].InventoryItemPath
@@ -470,9 +635,7 @@ func GetTrackImages(gamerInfo requests.GamerInfo, Ranks []Rank) []Rank {
 			}
 			for idx, currReward := range rank.FreeRewards.CurrencyRewards {
 				if currReward.CurrencyPath == result.Path {
-					result.ImageData, _ = compressPNGWithImaging(result.ImageData, false, 0, 0)
-
-					rank.FreeRewards.CurrencyRewards[idx].ItemImageData = result.ImageData
+					rank.FreeRewards.CurrencyRewards[idx].ItemImageURL = result.ImageURL
 					rank.FreeRewards.CurrencyRewards[idx].ItemMetaData = result.Item
 
 				}
@@ -481,9 +644,7 @@ func GetTrackImages(gamerInfo requests.GamerInfo, Ranks []Rank) []Rank {
 			// Update Paid Rewards
 			for idx, invReward := range rank.PaidRewards.InventoryRewards {
 				if invReward.InventoryItemPath == result.Path {
-					result.ImageData, _ = compressPNGWithImaging(result.ImageData, false, 0, 0)
-
-					rank.PaidRewards.InventoryRewards[idx].ItemImageData = result.ImageData
+					rank.PaidRewards.InventoryRewards[idx].ItemImageURL = result.ImageURL
 					rank.PaidRewards.InventoryRewards[idx].ItemMetaData = result.Item
 					ctx := context.Background()
 					itemPath := rank.PaidRewards.InventoryRewards[idx].InventoryItemPath
@@ -499,9 +660,7 @@ func GetTrackImages(gamerInfo requests.GamerInfo, Ranks []Rank) []Rank {
 			}
 			for idx, currReward := range rank.PaidRewards.CurrencyRewards {
 				if currReward.CurrencyPath == result.Path {
-					result.ImageData, _ = compressPNGWithImaging(result.ImageData, false, 0, 0)
-
-					rank.PaidRewards.CurrencyRewards[idx].ItemImageData = result.ImageData
+					rank.PaidRewards.CurrencyRewards[idx].ItemImageURL = result.ImageURL
 					rank.PaidRewards.CurrencyRewards[idx].ItemMetaData = result.Item
 
 				}
@@ -511,30 +670,37 @@ func GetTrackImages(gamerInfo requests.GamerInfo, Ranks []Rank) []Rank {
 	return Ranks
 }
 
-func GetSeasonMetadata(gamerInfo requests.GamerInfo, season Season) SeasonMetadata {
+// GetSeasonMetadata fetches a season's metadata and card image. The error is set when either failed,
+// metadata is still returned without its image.
+func GetSeasonMetadata(gamerInfo requests.GamerInfo, season Season) (SeasonMetadata, error) {
+	ctx := context.Background()
+	creds := gamerInfo.Credentials()
 	metadata := SeasonMetadata{}
-	url := "https://gamecms-hacs.svc.halowaypoint.com/hi/progression/file/" + season.SeasonMetadata
-	err := makeAPIRequest(gamerInfo.SpartanKey, url, nil, &metadata)
+	err := haloapi.Default.ProgressionFile(ctx, creds, season.SeasonMetadata, &metadata)
 	if err != nil {
 		fmt.Println("Error while getting season metadata: ", err)
-		return metadata
+		return metadata, err
 	}
 
 	// Special Case: WC3
 	if season.OperationTrackPath == "RewardTracks/Operations/S05OpPassM02.json" {
-		metadata.SeasonImage, err = makeAPIRequestImage("", "https://wpassets.halowaypoint.com/wp-content/2023/10/OperationWinterContingency.jpg", nil)
-		return metadata
+		imageData, err := haloapi.Default.GetURLBytes(ctx, haloapi.Credentials{}, "https://wpassets.halowaypoint.com/wp-content/2023/10/OperationWinterContingency.jpg")
+		if err != nil {
+			fmt.Println("Error while getting season image: ", err)
+			return metadata, err
+		}
+		hash, err := StoreImage(ctx, imageData)
+		if err != nil {
+			fmt.Println("Error while storing season image: ", err)
+			return metadata, err
+		}
+		metadata.SeasonImageURL = imageURL(hash)
+		return metadata, nil
 	}
 	// Get Season Background Image
-	url = "https://gamecms-hacs.svc.halowaypoint.com/hi/images/file/" + metadata.CardBackgroundImage
-	metadata.SeasonImage, err = makeAPIRequestImage(gamerInfo.SpartanKey, url, nil)
+	metadata.SeasonImageURL, err = fetchImageURL(ctx, creds, metadata.CardBackgroundImage)
 	if err != nil {
 		fmt.Println("Error while getting season image: ", err)
-		return metadata
-
 	}
-
-	// Compress Images
-	metadata.SeasonImage, err = compressPNGWithImaging(metadata.SeasonImage, false, 0, 0)
-	return metadata
+	return metadata, err
 }
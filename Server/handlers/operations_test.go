@@ -0,0 +1,141 @@
+package spartanreport
+
+import (
+	"testing"
+	"time"
+)
+
+var calendarNow = time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+func calendarDate(offset time.Duration) Date {
+	return Date{ISO8601Date: calendarNow.Add(offset).Format(time.RFC3339)}
+}
+
+func TestNextSeasonRefresh(t *testing.T) {
+	tests := []struct {
+		name    string
+		seasons Seasons
+		partial bool
+		want    time.Time
+	}{
+		{
+			name: "empty calendar waits the interval",
+			want: calendarNow.Add(seasonRefreshInterval),
+		},
+		{
+			name: "boundaries past the interval wait the interval",
+			seasons: Seasons{Seasons: []Season{{
+				StartDate: calendarDate(-30 * 24 * time.Hour),
+				EndDate:   calendarDate(30 * 24 * time.Hour),
+			}}},
+			want: calendarNow.Add(seasonRefreshInterval),
+		},
+		{
+			name: "season ending within the interval",
+			seasons: Seasons{Seasons: []Season{{
+				StartDate: calendarDate(-30 * 24 * time.Hour),
+				EndDate:   calendarDate(2 * time.Hour),
+			}}},
+			want: calendarNow.Add(2 * time.Hour),
+		},
+		{
+			name: "soonest of season and event boundaries",
+			seasons: Seasons{
+				Seasons: []Season{{StartDate: calendarDate(-time.Hour), EndDate: calendarDate(5 * time.Hour)}},
+				Events:  []Event{{StartDate: calendarDate(3 * time.Hour), EndDate: calendarDate(4 * time.Hour)}},
+			},
+			want: calendarNow.Add(3 * time.Hour),
+		},
+		{
+			name: "boundary at now is already past",
+			seasons: Seasons{Events: []Event{{
+				StartDate: calendarDate(0),
+				EndDate:   calendarDate(time.Hour),
+			}}},
+			want: calendarNow.Add(time.Hour),
+		},
+		{
+			name:    "partial calendar is refetched soon",
+			partial: true,
+			seasons: Seasons{Seasons: []Season{{
+				StartDate: calendarDate(-30 * 24 * time.Hour),
+				EndDate:   calendarDate(30 * 24 * time.Hour),
+			}}},
+			want: calendarNow.Add(seasonPartialRefresh),
+		},
+		{
+			name:    "partial calendar still refetched at an earlier boundary",
+			partial: true,
+			seasons: Seasons{Events: []Event{{
+				StartDate: calendarDate(-time.Hour),
+				EndDate:   calendarDate(5 * time.Minute),
+			}}},
+			want: calendarNow.Add(5 * time.Minute),
+		},
+		{
+			name: "unparseable dates are skipped",
+			seasons: Seasons{Events: []Event{{
+				StartDate: Date{ISO8601Date: "soon"},
+				EndDate:   Date{},
+			}}},
+			want: calendarNow.Add(seasonRefreshInterval),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextSeasonRefresh(tt.seasons, tt.partial, calendarNow); !got.Equal(tt.want) {
+				t.Errorf("nextSeasonRefresh = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkActiveSeasons(t *testing.T) {
+	tests := []struct {
+		name         string
+		start, end   time.Duration
+		wantActive   bool
+		wantUpcoming bool
+	}{
+		{name: "running", start: -time.Hour, end: time.Hour, wantActive: true},
+		{name: "ended", start: -2 * time.Hour, end: -time.Hour},
+		{name: "upcoming", start: time.Hour, end: 2 * time.Hour, wantUpcoming: true},
+		{name: "starting at now", start: 0, end: time.Hour},
+		{name: "ending at now", start: -time.Hour, end: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seasons := Seasons{
+				// Stale flags are overwritten
+				Seasons: []Season{{StartDate: calendarDate(tt.start), EndDate: calendarDate(tt.end), IsActive: !tt.wantActive}},
+				Events:  []Event{{StartDate: calendarDate(tt.start), EndDate: calendarDate(tt.end), IsActive: !tt.wantActive, IsUpcoming: !tt.wantUpcoming}},
+			}
+			markActiveSeasons(&seasons, calendarNow)
+			if got := seasons.Seasons[0].IsActive; got != tt.wantActive {
+				t.Errorf("season IsActive = %v, want %v", got, tt.wantActive)
+			}
+			if got := seasons.Events[0].IsActive; got != tt.wantActive {
+				t.Errorf("event IsActive = %v, want %v", got, tt.wantActive)
+			}
+			if got := seasons.Events[0].IsUpcoming; got != tt.wantUpcoming {
+				t.Errorf("event IsUpcoming = %v, want %v", got, tt.wantUpcoming)
+			}
+		})
+	}
+}
+
+func TestSeasonRefreshWait(t *testing.T) {
+	tests := []struct {
+		refreshAt time.Time
+		want      time.Duration
+	}{
+		{calendarNow.Add(3 * time.Hour), 3 * time.Hour},
+		{calendarNow.Add(time.Second), seasonRefreshRetry},
+		{calendarNow.Add(-time.Hour), seasonRefreshRetry},
+	}
+	for _, tt := range tests {
+		if got := seasonRefreshWait(tt.refreshAt, calendarNow); got != tt.want {
+			t.Errorf("seasonRefreshWait(%v) = %v, want %v", tt.refreshAt, got, tt.want)
+		}
+	}
+}
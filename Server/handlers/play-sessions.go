@@ -0,0 +1,370 @@
+package spartanreport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"spartanreport/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A session ends when the player goes longer than the gap without starting a match
+const (
+	defaultSessionGap = 30 * time.Minute
+	minSessionGap     = 5 * time.Minute
+	maxSessionGap     = 12 * time.Hour
+)
+
+const (
+	defaultPlaySessionLimit = 20
+	maxPlaySessionLimit     = 100
+)
+
+// SessionGame is the best or worst match of a session, ranked by KDA then personal score
+type SessionGame struct {
+	MatchId       string    `bson:"matchid"`
+	StartTime     time.Time `bson:"starttime"`
+	Playlist      string    `bson:"playlist"`
+	Outcome       int       `bson:"outcome"`
+	KDA           float64   `bson:"kda"`
+	PersonalScore int       `bson:"personalscore"`
+}
+
+// SessionPlaylist is how many matches of a session were played in one playlist
+type SessionPlaylist struct {
+	AssetId    string `bson:"assetid"`
+	PublicName string `bson:"publicname"`
+	Matches    int    `bson:"matches"`
+}
+
+// PlaySession is a run of matches with no idle gap longer than GapMinutes.
+// Only sessions of the default gap are stored in play_sessions, keyed by the player, the gap and the first match so rebuilding one replaces it.
+type PlaySession struct {
+	Id         string            `bson:"_id" json:"Id"`
+	XUID       string            `bson:"xuid" json:"-"`
+	GapMinutes int               `bson:"gapminutes" json:"-"`
+	Start      time.Time         `bson:"start"`
+	End        time.Time         `bson:"end"`
+	Matches    int               `bson:"matches"`
+	TimePlayed string            `bson:"timeplayed"` // Summed match lengths, idle time between matches isn't counted
+	Wins       int               `bson:"wins"`
+	Losses     int               `bson:"losses"`
+	Ties       int               `bson:"ties"`
+	DNFs       int               `bson:"dnfs"`
+	Kills      int               `bson:"kills"`
+	Deaths     int               `bson:"deaths"`
+	Assists    int               `bson:"assists"`
+	NetKDA     float64           `bson:"netkda"` // Halo's KDA over the whole session, kills + assists / 3 - deaths
+	BestGame   SessionGame       `bson:"bestgame"`
+	WorstGame  SessionGame       `bson:"worstgame"`
+	Playlists  []SessionPlaylist `bson:"playlists"`        // Most played first
+	Skipped    int               `bson:"skipped" json:"-"` // Matches left out for unreadable times, counted so they don't look new on every update
+}
+
+// PlaySessions is the response of GET /players/:xuid/sessions, newest first
+type PlaySessions struct {
+	GapMinutes int
+	Sessions   []PlaySession
+}
+
+// CreatePlaySessionIndexes creates the play_sessions index used to read a player's sessions
+func CreatePlaySessionIndexes() error {
+	return db.CreateIndex("play_sessions", bson.D{{Key: "xuid", Value: 1}, {Key: "gapminutes", Value: 1}, {Key: "start", Value: -1}})
+}
+
+// nonDefaultPlaySessionsDropped marks the sessions of other gaps, stored before only the default gap was, as dropped
+const nonDefaultPlaySessionsDropped = "migrations:non_default_play_sessions_dropped"
+
+// DropNonDefaultPlaySessions deletes the stored sessions of gaps other than the default, nothing reads them anymore.
+// It only runs once, later starts find the marker and leave play_sessions alone.
+func DropNonDefaultPlaySessions(ctx context.Context) error {
+	first, err := db.RedisClient.SetNX(ctx, nonDefaultPlaySessionsDropped, time.Now().UTC().Format(time.RFC3339), 0).Result()
+	if err != nil || !first {
+		return err
+	}
+	if _, err := db.GetCollection("play_sessions").DeleteMany(ctx, bson.M{"gapminutes": bson.M{"$ne": int(defaultSessionGap.Minutes())}}); err != nil {
+		// Leave it to the next start
+		db.RedisClient.Del(ctx, nonDefaultPlaySessionsDropped)
+		return err
+	}
+	return nil
+}
+
+// betterSessionGame reports whether a ranks above b within a session
+func betterSessionGame(a SessionGame, b SessionGame) bool {
+	if a.KDA != b.KDA {
+		return a.KDA > b.KDA
+	}
+	return a.PersonalScore > b.PersonalScore
+}
+
+func playSessionID(xuid string, gap time.Duration, firstMatchID string) string {
+	return fmt.Sprintf("%s:%d:%s", xuid, int(gap.Minutes()), firstMatchID)
+}
+
+// rowsFromMatch drops the rows sorted before the match, false when it isn't among them
+func rowsFromMatch(rows []playerMatchRow, matchID string) ([]playerMatchRow, bool) {
+	for i, row := range rows {
+		if row.MatchId == matchID {
+			return rows[i:], true
+		}
+	}
+	return nil, false
+}
+
+// buildPlaySessions splits matches sorted by start time into sessions.
+// A match with an unreadable start or end time is skipped and counted in the Skipped of the session around it.
+func buildPlaySessions(xuid string, gap time.Duration, rows []playerMatchRow) []PlaySession {
+	var sessions []PlaySession
+	var current *PlaySession
+	skipped := 0 // Skipped before the first session
+	var timePlayed time.Duration
+	playlists := map[string]*SessionPlaylist{}
+
+	finish := func() {
+		if current == nil {
+			return
+		}
+		current.TimePlayed = timePlayed.String()
+		current.Playlists = []SessionPlaylist{}
+		for _, playlist := range playlists {
+			current.Playlists = append(current.Playlists, *playlist)
+		}
+		sort.Slice(current.Playlists, func(i, j int) bool {
+			if current.Playlists[i].Matches != current.Playlists[j].Matches {
+				return current.Playlists[i].Matches > current.Playlists[j].Matches
+			}
+			return current.Playlists[i].PublicName < current.Playlists[j].PublicName
+		})
+		sessions = append(sessions, *current)
+	}
+
+	for _, row := range rows {
+		start, startErr := time.Parse(time.RFC3339Nano, row.StartTime)
+		end, endErr := time.Parse(time.RFC3339Nano, row.EndTime)
+		if startErr != nil || endErr != nil {
+			fmt.Println("Skipping match", row.MatchId, "in play sessions, its times can't be read:", row.StartTime, row.EndTime)
+			if current != nil {
+				current.Skipped++
+			} else {
+				skipped++
+			}
+			continue
+		}
+
+		if current == nil || start.Sub(current.End) > gap {
+			finish()
+			current = &PlaySession{
+				Id:         playSessionID(xuid, gap, row.MatchId),
+				XUID:       xuid,
+				GapMinutes: int(gap.Minutes()),
+				Start:      start,
+				Skipped:    skipped,
+			}
+			skipped = 0
+			timePlayed = 0
+			playlists = map[string]*SessionPlaylist{}
+		}
+
+		if end.After(current.End) {
+			current.End = end
+		}
+		timePlayed += end.Sub(start)
+		current.Matches++
+		switch row.Outcome {
+		case matchOutcomes["win"]:
+			current.Wins++
+		case matchOutcomes["loss"]:
+			current.Losses++
+		case matchOutcomes["tie"]:
+			current.Ties++
+		case matchOutcomes["dnf"]:
+			current.DNFs++
+		}
+		current.Kills += int(row.Kills)
+		current.Deaths += int(row.Deaths)
+		current.Assists += int(row.Assists)
+		current.NetKDA = float64(current.Kills) + float64(current.Assists)/3 - float64(current.Deaths)
+
+		game := SessionGame{
+			MatchId:       row.MatchId,
+			StartTime:     start,
+			Playlist:      row.PlaylistName,
+			Outcome:       row.Outcome,
+			KDA:           row.KDA,
+			PersonalScore: int(row.PersonalScore),
+		}
+		if current.Matches == 1 || betterSessionGame(game, current.BestGame) {
+			current.BestGame = game
+		}
+		if current.Matches == 1 || betterSessionGame(current.WorstGame, game) {
+			current.WorstGame = game
+		}
+
+		playlist, ok := playlists[row.PlaylistId]
+		if !ok {
+			playlist = &SessionPlaylist{AssetId: row.PlaylistId, PublicName: row.PlaylistName}
+			playlists[row.PlaylistId] = playlist
+		}
+		playlist.Matches++
+	}
+	finish()
+	return sessions
+}
+
+func loadPlayerMatchRows(ctx context.Context, filter bson.M, xuid string) ([]playerMatchRow, error) {
+	pipeline := append(playerMatchRows(filter, xuid),
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "startTime", Value: 1}, {Key: "matchId", Value: 1}}}},
+	)
+	cursor, err := db.GetCollection("detailed_matches").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []playerMatchRow
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// UpdatePlaySessions brings a player's stored sessions, those of the default gap, up to date with detailed_matches.
+// Matches newer than the stored sessions only rebuild the latest session, anything else rebuilds them all.
+func UpdatePlaySessions(ctx context.Context, xuid string) error {
+	gap := defaultSessionGap
+	playerID := "xuid(" + xuid + ")"
+	sessionFilter := bson.M{"xuid": xuid, "gapminutes": int(gap.Minutes())}
+	collection := db.GetCollection("play_sessions")
+
+	matchCount, err := db.GetCollection("detailed_matches").CountDocuments(ctx, bson.M{"players.playerid": playerID})
+	if err != nil {
+		return err
+	}
+
+	var stored []PlaySession
+	cursor, err := collection.Find(ctx, sessionFilter, options.Find().SetSort(bson.D{{Key: "start", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+	storedMatches := 0
+	for _, session := range stored {
+		storedMatches += session.Matches + session.Skipped
+	}
+	if int64(storedMatches) == matchCount {
+		return nil
+	}
+
+	var rows []playerMatchRow
+	rebuildAll := len(stored) == 0
+	if !rebuildAll {
+		// The bound drops fractional seconds, backing off a second keeps the session's first match in.
+		// Whatever else that lets in is dropped by cutting the rows at the first match.
+		last := stored[len(stored)-1]
+		rows, err = loadPlayerMatchRows(ctx, bson.M{
+			"players.playerid":    playerID,
+			"matchinfo.starttime": bson.M{"$gte": matchTimeBound(last.Start.Add(-time.Second))},
+		}, xuid)
+		if err != nil {
+			return err
+		}
+		var found bool
+		rows, found = rowsFromMatch(rows, strings.TrimPrefix(last.Id, playSessionID(xuid, gap, "")))
+		// Matches stored before the latest session mean the earlier sessions are stale too
+		rebuildAll = !found || int64(storedMatches-last.Matches-last.Skipped+len(rows)) != matchCount
+	}
+	if rebuildAll {
+		rows, err = loadPlayerMatchRows(ctx, bson.M{"players.playerid": playerID}, xuid)
+		if err != nil {
+			return err
+		}
+		if _, err := collection.DeleteMany(ctx, sessionFilter); err != nil {
+			return err
+		}
+	}
+
+	sessions := buildPlaySessions(xuid, gap, rows)
+	if len(sessions) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, len(sessions))
+	for i, session := range sessions {
+		models[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": session.Id}).SetReplacement(session).SetUpsert(true)
+	}
+	if _, err := collection.BulkWrite(ctx, models); err != nil {
+		return fmt.Errorf("Error storing play sessions: %v", err)
+	}
+	return nil
+}
+
+// GetPlaySessions returns a player's newest sessions. The default gap's come from play_sessions after bringing them up to date,
+// any other gap's are built from the matches for this request only, so a player can't fill play_sessions with one set per gap.
+func GetPlaySessions(ctx context.Context, xuid string, gap time.Duration, limit int) (PlaySessions, error) {
+	result := PlaySessions{GapMinutes: int(gap.Minutes()), Sessions: []PlaySession{}}
+	if gap != defaultSessionGap {
+		rows, err := loadPlayerMatchRows(ctx, bson.M{"players.playerid": "xuid(" + xuid + ")"}, xuid)
+		if err != nil {
+			return result, err
+		}
+		sessions := buildPlaySessions(xuid, gap, rows)
+		for i := len(sessions) - 1; i >= 0 && len(result.Sessions) < limit; i-- {
+			result.Sessions = append(result.Sessions, sessions[i])
+		}
+		return result, nil
+	}
+
+	if err := UpdatePlaySessions(ctx, xuid); err != nil {
+		return result, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "start", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := db.GetCollection("play_sessions").Find(ctx, bson.M{"xuid": xuid, "gapminutes": result.GapMinutes}, opts)
+	if err != nil {
+		return result, err
+	}
+	if err := cursor.All(ctx, &result.Sessions); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// HandlePlaySessions serves GET /players/:xuid/sessions.
+// gap is the idle time in minutes that ends a session and limit caps how many sessions are returned.
+func HandlePlaySessions(c *gin.Context) {
+	xuid := c.Param("xuid")
+	if !xuidPattern.MatchString(xuid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid xuid"})
+		return
+	}
+
+	gap := defaultSessionGap
+	if value := c.Query("gap"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		gap = time.Duration(minutes) * time.Minute
+		if err != nil || gap < minSessionGap || gap > maxSessionGap {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("gap must be between %d and %d minutes", int(minSessionGap.Minutes()), int(maxSessionGap.Minutes()))})
+			return
+		}
+	}
+	limit, err := boundedIntQuery(c, "limit", defaultPlaySessionLimit, maxPlaySessionLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessions, err := GetPlaySessions(c.Request.Context(), xuid, gap, limit)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
@@ -0,0 +1,166 @@
+package spartanreport
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var sessionsStart = time.Date(2024, time.March, 10, 18, 0, 0, 0, time.UTC)
+
+// sessionRow is a ten minute match starting at offset minutes past sessionsStart
+func sessionRow(id string, offset float64, playlist string, outcome int, kda float64, score float64) playerMatchRow {
+	start := sessionsStart.Add(time.Duration(offset * float64(time.Minute)))
+	return playerMatchRow{
+		MatchId:       id,
+		StartTime:     start.Format(time.RFC3339Nano),
+		EndTime:       start.Add(10 * time.Minute).Format(time.RFC3339Nano),
+		PlaylistId:    playlist + "-id",
+		PlaylistName:  playlist,
+		Outcome:       outcome,
+		KDA:           kda,
+		PersonalScore: score,
+	}
+}
+
+func sessionMatchIDs(sessions []PlaySession) [][]string {
+	var ids [][]string
+	for _, session := range sessions {
+		ids = append(ids, []string{session.Id, session.BestGame.MatchId, session.WorstGame.MatchId})
+	}
+	return ids
+}
+
+func TestBuildPlaySessions(t *testing.T) {
+	win, loss := matchOutcomes["win"], matchOutcomes["loss"]
+	gap := 30 * time.Minute
+	tests := []struct {
+		name string
+		rows []playerMatchRow
+		want [][]string // Session Id, best game and worst game of each session
+	}{
+		{
+			name: "no matches",
+		},
+		{
+			name: "gap of exactly the limit stays in the session",
+			// a ends at 10, b starts 30 minutes later
+			rows: []playerMatchRow{sessionRow("a", 0, "Quick Play", win, 1, 100), sessionRow("b", 40, "Quick Play", win, 2, 100)},
+			want: [][]string{{"xuid:30:a", "b", "a"}},
+		},
+		{
+			name: "gap just over the limit starts a new session",
+			rows: []playerMatchRow{sessionRow("a", 0, "Quick Play", win, 1, 100), sessionRow("b", 40+1.0/60, "Quick Play", win, 2, 100)},
+			want: [][]string{{"xuid:30:a", "a", "a"}, {"xuid:30:b", "b", "b"}},
+		},
+		{
+			name: "gap measured from the latest end, not the latest start",
+			// b ends before a does, c is within the gap of a's end only
+			rows: []playerMatchRow{
+				{MatchId: "a", StartTime: sessionsStart.Format(time.RFC3339), EndTime: sessionsStart.Add(50 * time.Minute).Format(time.RFC3339)},
+				sessionRow("b", 5, "Quick Play", win, 0, 0),
+				sessionRow("c", 75, "Quick Play", win, 0, 0),
+			},
+			want: [][]string{{"xuid:30:a", "a", "a"}},
+		},
+		{
+			name: "equal KDA ranks by personal score",
+			rows: []playerMatchRow{sessionRow("a", 0, "Quick Play", win, 1.5, 900), sessionRow("b", 15, "Quick Play", loss, 1.5, 1200), sessionRow("c", 30, "Quick Play", loss, 1.5, 600)},
+			want: [][]string{{"xuid:30:a", "b", "c"}},
+		},
+		{
+			name: "full ties keep the earliest match as both best and worst",
+			rows: []playerMatchRow{sessionRow("a", 0, "Quick Play", win, 1, 500), sessionRow("b", 15, "Quick Play", win, 1, 500)},
+			want: [][]string{{"xuid:30:a", "a", "a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := buildPlaySessions("xuid", gap, tt.rows)
+			if got := sessionMatchIDs(sessions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sessions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPlaySessionsTotals(t *testing.T) {
+	rows := []playerMatchRow{
+		sessionRow("a", 0, "Ranked Arena", matchOutcomes["win"], 2, 100),
+		sessionRow("b", 12, "Quick Play", matchOutcomes["loss"], 1, 100),
+		sessionRow("c", 24, "Ranked Arena", matchOutcomes["tie"], 1, 100),
+		sessionRow("d", 36, "Big Team Battle", matchOutcomes["dnf"], 1, 100),
+	}
+	rows[0].Kills, rows[0].Deaths, rows[0].Assists = 10, 4, 6
+	rows[1].Kills, rows[1].Deaths, rows[1].Assists = 3, 8, 3
+
+	sessions := buildPlaySessions("xuid", 30*time.Minute, rows)
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(sessions))
+	}
+	session := sessions[0]
+	if session.Matches != 4 || session.Wins != 1 || session.Losses != 1 || session.Ties != 1 || session.DNFs != 1 {
+		t.Errorf("outcomes = %d matches %d/%d/%d/%d, want 4 matches 1/1/1/1", session.Matches, session.Wins, session.Losses, session.Ties, session.DNFs)
+	}
+	if session.Kills != 13 || session.Deaths != 12 || session.Assists != 9 || session.NetKDA != 4 {
+		t.Errorf("stats = %d/%d/%d net %v, want 13/12/9 net 4", session.Kills, session.Deaths, session.Assists, session.NetKDA)
+	}
+	if session.TimePlayed != (40 * time.Minute).String() {
+		t.Errorf("TimePlayed = %s, want 40m0s", session.TimePlayed)
+	}
+	if !session.End.Equal(sessionsStart.Add(46 * time.Minute)) {
+		t.Errorf("End = %v, want %v", session.End, sessionsStart.Add(46*time.Minute))
+	}
+
+	// Most played first, ties by name
+	want := []SessionPlaylist{
+		{AssetId: "Ranked Arena-id", PublicName: "Ranked Arena", Matches: 2},
+		{AssetId: "Big Team Battle-id", PublicName: "Big Team Battle", Matches: 1},
+		{AssetId: "Quick Play-id", PublicName: "Quick Play", Matches: 1},
+	}
+	if !reflect.DeepEqual(session.Playlists, want) {
+		t.Errorf("Playlists = %v, want %v", session.Playlists, want)
+	}
+}
+
+func TestBuildPlaySessionsSkipsBadTimes(t *testing.T) {
+	badStart := playerMatchRow{MatchId: "bad-start", StartTime: "yesterday", EndTime: sessionsStart.Format(time.RFC3339)}
+	badEnd := playerMatchRow{MatchId: "bad-end", StartTime: sessionsStart.Format(time.RFC3339), EndTime: ""}
+	rows := []playerMatchRow{
+		badStart,
+		sessionRow("a", 0, "Quick Play", matchOutcomes["win"], 1, 100),
+		badEnd,
+		sessionRow("b", 15, "Quick Play", matchOutcomes["win"], 1, 100),
+		sessionRow("c", 120, "Quick Play", matchOutcomes["win"], 1, 100),
+	}
+
+	sessions := buildPlaySessions("xuid", 30*time.Minute, rows)
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	// The bad row before any session goes to the first one, like the one inside it
+	if sessions[0].Id != "xuid:30:a" || sessions[0].Matches != 2 || sessions[0].Skipped != 2 {
+		t.Errorf("first session = %s with %d matches, %d skipped, want xuid:30:a with 2 matches, 2 skipped", sessions[0].Id, sessions[0].Matches, sessions[0].Skipped)
+	}
+	if sessions[1].Matches != 1 || sessions[1].Skipped != 0 {
+		t.Errorf("second session has %d matches, %d skipped, want 1 and 0", sessions[1].Matches, sessions[1].Skipped)
+	}
+
+	if sessions := buildPlaySessions("xuid", 30*time.Minute, []playerMatchRow{badStart}); len(sessions) != 0 {
+		t.Errorf("only bad rows gave %d sessions, want none", len(sessions))
+	}
+}
+
+func TestRowsFromMatch(t *testing.T) {
+	rows := []playerMatchRow{{MatchId: "previous"}, {MatchId: "first"}, {MatchId: "next"}}
+	got, found := rowsFromMatch(rows, "first")
+	if !found || len(got) != 2 || got[0].MatchId != "first" {
+		t.Errorf("rowsFromMatch(first) = %v, %v, want the rows from first on", got, found)
+	}
+	if _, found := rowsFromMatch(rows, "missing"); found {
+		t.Error("rowsFromMatch found a match that isn't there")
+	}
+	if playSessionID("xuid", 30*time.Minute, "first") != "xuid:30:first" {
+		t.Errorf("playSessionID = %q", playSessionID("xuid", 30*time.Minute, "first"))
+	}
+}
@@ -0,0 +1,372 @@
+package spartanreport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"spartanreport/db"
+	requests "spartanreport/requests"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// Jobs are pushed onto the queue list and moved to the processing list of the server running them.
+// Each server keeps a heartbeat, and the jobs of a server whose heartbeat expired are put back on the queue.
+const (
+	progressionQueueKey      = "progression:jobs"
+	progressionProcessingKey = "progression:jobs:processing" // Shared by every server before processing lists were per server
+	progressionWorkersKey    = "progression:workers"         // Set of the servers that have run workers
+)
+
+const (
+	progressionHeartbeatTTL      = time.Minute
+	progressionHeartbeatInterval = 20 * time.Second
+)
+
+const (
+	progressionJobTTL        = 24 * time.Hour
+	progressionJobTimeout    = 15 * time.Minute // Bounds a single sync, a first import of 200 matches takes a few minutes
+	defaultProgressionWorker = 2
+)
+
+// Job statuses
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// ErrJobNotFound is returned for unknown or expired job IDs
+var ErrJobNotFound = errors.New("job not found")
+
+// progressionInstanceID names this server's processing list and heartbeat, set by StartProgressionWorkers
+var progressionInstanceID string
+
+// claimActiveJob extends the player's active job key when it still points at the job, or takes it back when it expired
+var claimActiveJob = redis.NewScript(`
+local active = redis.call("GET", KEYS[1])
+if active == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+elseif not active then
+	return redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2]) and 1 or 0
+end
+return 0
+`)
+
+// ProgressionJob is a match history sync queued by POST /progression
+type ProgressionJob struct {
+	Id           string    `json:"id"`
+	XUID         string    `json:"xuid"`
+	SessionID    string    `json:"sessionId,omitempty"` // The worker loads the caller's tokens from the session, never sent to the browser
+	Status       string    `json:"status"`
+	Phase        string    `json:"phase"`
+	MatchesDone  int       `json:"matchesDone"`
+	MatchesTotal int       `json:"matchesTotal"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+func progressionJobKey(jobID string) string {
+	return "progression:job:" + jobID
+}
+
+// Points at the queued or running job of a player so repeated requests don't start a second sync
+func activeProgressionJobKey(xuid string) string {
+	return "progression:active:" + xuid
+}
+
+func progressionProcessingListKey(instanceID string) string {
+	return "progression:jobs:processing:" + instanceID
+}
+
+func progressionHeartbeatKey(instanceID string) string {
+	return "progression:worker:" + instanceID
+}
+
+func saveProgressionJob(ctx context.Context, job ProgressionJob) error {
+	job.UpdatedAt = time.Now().UTC()
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("Error marshaling job: %v", err)
+	}
+	if err := db.RedisClient.Set(ctx, progressionJobKey(job.Id), jobBytes, progressionJobTTL).Err(); err != nil {
+		return fmt.Errorf("Error storing job: %v", err)
+	}
+	return nil
+}
+
+// GetProgressionJob looks up a job by ID
+func GetProgressionJob(ctx context.Context, jobID string) (ProgressionJob, error) {
+	var job ProgressionJob
+	val, err := db.RedisClient.Get(ctx, progressionJobKey(jobID)).Result()
+	if err == redis.Nil {
+		return job, ErrJobNotFound
+	} else if err != nil {
+		return job, fmt.Errorf("Error getting job: %v", err)
+	}
+	if err := json.Unmarshal([]byte(val), &job); err != nil {
+		return job, fmt.Errorf("Error unmarshaling job: %v", err)
+	}
+	return job, nil
+}
+
+// EnqueueProgressionJob queues a sync for the session's player, or returns the one already queued or running
+func EnqueueProgressionJob(ctx context.Context, sessionID string, xuid string) (ProgressionJob, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ProgressionJob{}, fmt.Errorf("Error generating job ID: %v", err)
+	}
+	now := time.Now().UTC()
+	job := ProgressionJob{
+		Id:        hex.EncodeToString(buf),
+		XUID:      xuid,
+		SessionID: sessionID,
+		Status:    JobQueued,
+		CreatedAt: now,
+	}
+
+	claimed, err := db.RedisClient.SetNX(ctx, activeProgressionJobKey(xuid), job.Id, progressionJobTimeout).Result()
+	if err != nil {
+		return job, fmt.Errorf("Error claiming job: %v", err)
+	}
+	if !claimed {
+		activeID, err := db.RedisClient.Get(ctx, activeProgressionJobKey(xuid)).Result()
+		if err == nil {
+			if active, err := GetProgressionJob(ctx, activeID); err == nil {
+				return active, nil
+			}
+		}
+		// The active job expired in between, take its place
+		if err := db.RedisClient.Set(ctx, activeProgressionJobKey(xuid), job.Id, progressionJobTimeout).Err(); err != nil {
+			return job, fmt.Errorf("Error claiming job: %v", err)
+		}
+	}
+
+	if err := saveProgressionJob(ctx, job); err != nil {
+		return job, err
+	}
+	if err := db.RedisClient.LPush(ctx, progressionQueueKey, job.Id).Err(); err != nil {
+		return job, fmt.Errorf("Error queueing job: %v", err)
+	}
+	return job, nil
+}
+
+// StartProgressionWorkers starts the workers, along with the heartbeat that requeues the jobs of servers that stopped.
+// The worker count comes from PROGRESSION_WORKERS.
+func StartProgressionWorkers() {
+	workers, err := strconv.Atoi(os.Getenv("PROGRESSION_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = defaultProgressionWorker
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		fmt.Println("Error generating worker ID: ", err)
+		return
+	}
+	progressionInstanceID = hex.EncodeToString(buf)
+
+	ctx := context.Background()
+	beatProgressionHeartbeat(ctx)
+	requeueInterruptedProgressionJobs(ctx)
+	// Only emptied at boot, servers still running the old code may be running the jobs on it
+	requeueProgressionJobs(ctx, progressionProcessingKey)
+	go func() {
+		for range time.Tick(progressionHeartbeatInterval) {
+			beatProgressionHeartbeat(ctx)
+			requeueInterruptedProgressionJobs(ctx)
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go progressionWorker()
+	}
+}
+
+func beatProgressionHeartbeat(ctx context.Context) {
+	if err := db.RedisClient.Set(ctx, progressionHeartbeatKey(progressionInstanceID), 1, progressionHeartbeatTTL).Err(); err != nil {
+		fmt.Println("Error storing progression worker heartbeat: ", err)
+	}
+	if err := db.RedisClient.SAdd(ctx, progressionWorkersKey, progressionInstanceID).Err(); err != nil {
+		fmt.Println("Error registering progression worker: ", err)
+	}
+}
+
+// requeueInterruptedProgressionJobs puts the jobs of servers whose heartbeat expired back on the queue
+func requeueInterruptedProgressionJobs(ctx context.Context) {
+	instances, err := db.RedisClient.SMembers(ctx, progressionWorkersKey).Result()
+	if err != nil {
+		fmt.Println("Error listing progression workers: ", err)
+		return
+	}
+	for _, instanceID := range instances {
+		alive, err := db.RedisClient.Exists(ctx, progressionHeartbeatKey(instanceID)).Result()
+		if err != nil {
+			fmt.Println("Error checking progression worker heartbeat: ", err)
+			continue
+		}
+		if alive > 0 {
+			continue
+		}
+		if requeueProgressionJobs(ctx, progressionProcessingListKey(instanceID)) {
+			db.RedisClient.SRem(ctx, progressionWorkersKey, instanceID)
+		}
+	}
+}
+
+// requeueProgressionJobs moves every job on a processing list back to the queue, reporting whether the list was emptied
+func requeueProgressionJobs(ctx context.Context, processingKey string) bool {
+	for {
+		jobID, err := db.RedisClient.RPopLPush(ctx, processingKey, progressionQueueKey).Result()
+		if err == redis.Nil {
+			return true
+		} else if err != nil {
+			fmt.Println("Error requeueing progression jobs: ", err)
+			return false
+		}
+		fmt.Println("Requeued interrupted progression job", jobID)
+	}
+}
+
+func progressionWorker() {
+	ctx := context.Background()
+	processingKey := progressionProcessingListKey(progressionInstanceID)
+	for {
+		jobID, err := db.RedisClient.BRPopLPush(ctx, progressionQueueKey, processingKey, 0).Result()
+		if err != nil {
+			fmt.Println("Error waiting for progression jobs: ", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		runProgressionJob(jobID)
+		db.RedisClient.LRem(ctx, processingKey, 1, jobID)
+	}
+}
+
+func runProgressionJob(jobID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), progressionJobTimeout)
+	defer cancel()
+
+	job, err := GetProgressionJob(ctx, jobID)
+	if err != nil {
+		fmt.Println("Error loading progression job: ", err)
+		return
+	}
+	defer func() {
+		if activeID, _ := db.RedisClient.Get(context.Background(), activeProgressionJobKey(job.XUID)).Result(); activeID == job.Id {
+			db.RedisClient.Del(context.Background(), activeProgressionJobKey(job.XUID))
+		}
+	}()
+
+	fail := func(err error) {
+		fmt.Println("Progression job", job.Id, "failed: ", err)
+		job.Status = JobFailed
+		job.Error = err.Error()
+		if err := saveProgressionJob(context.Background(), job); err != nil {
+			fmt.Println(err)
+		}
+		publishProgressionEvent(job.Id, ProgressionEventFailed, job.public())
+	}
+
+	session, err := requests.GetSession(ctx, job.SessionID)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if session.NeedsRefresh() {
+		if refreshed, err := requests.RefreshSession(ctx, job.SessionID); err == nil {
+			session = refreshed
+		} else {
+			fmt.Println("Error refreshing session: ", err)
+		}
+	}
+
+	// The key was set when the job was queued and may have run out while it waited, it has to last the whole sync
+	if err := claimActiveJob.Run(ctx, db.RedisClient, []string{activeProgressionJobKey(job.XUID)}, job.Id, progressionJobTimeout.Milliseconds()).Err(); err != nil {
+		fmt.Println("Error extending active progression job: ", err)
+	}
+
+	job.Status = JobRunning
+	if err := saveProgressionJob(ctx, job); err != nil {
+		fmt.Println(err)
+	}
+
+	var mu sync.Mutex
+	hooks := progressionEventHooks(job.Id, "xuid("+job.XUID+")")
+	hooks.Progress = func(phase string, done, total int) {
+		mu.Lock()
+		defer mu.Unlock()
+		job.Phase = phase
+		job.MatchesDone = done
+		job.MatchesTotal = total
+		if err := saveProgressionJob(ctx, job); err != nil {
+			fmt.Println(err)
+		}
+		publishProgressionEvent(job.Id, ProgressionEventStatus, job.public())
+	}
+
+	if _, err := SyncMatchHistory(ctx, session.GamerInfo, hooks); err != nil {
+		fail(err)
+		return
+	}
+	if err := UpdatePlaySessions(ctx, job.XUID); err != nil {
+		fmt.Println("Error updating play sessions: ", err)
+	}
+	if err := RecordCSR(ctx, session.GamerInfo.Credentials(), job.XUID); err != nil {
+		fmt.Println("Error recording CSR: ", err)
+	}
+	if err := RecordCareerXP(ctx, session.GamerInfo.Credentials(), job.XUID); err != nil {
+		fmt.Println("Error recording career XP: ", err)
+	}
+
+	job.Status = JobDone
+	job.Phase = ""
+	if err := saveProgressionJob(context.Background(), job); err != nil {
+		fmt.Println(err)
+	}
+	publishProgressionEvent(job.Id, ProgressionEventDone, job.public())
+}
+
+// public returns a copy of the job that is safe to hand to the browser
+func (job ProgressionJob) public() ProgressionJob {
+	job.SessionID = ""
+	return job
+}
+
+// HandleProgression queues a match history sync for the caller and answers with the job to poll
+func HandleProgression(c *gin.Context) {
+	gamerInfo := GetSessionGamerInfo(c)
+	sessionID, _ := c.Cookie(requests.SessionCookieName)
+
+	job, err := EnqueueProgressionJob(c.Request.Context(), sessionID, gamerInfo.XUID)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"jobId": job.Id, "status": job.Status})
+}
+
+// HandleProgressionJob reports how far a sync job has got
+func HandleProgressionJob(c *gin.Context) {
+	gamerInfo := GetSessionGamerInfo(c)
+	job, err := GetProgressionJob(c.Request.Context(), c.Param("id"))
+	if err == ErrJobNotFound || (err == nil && job.XUID != gamerInfo.XUID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	} else if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job.public())
+}
@@ -0,0 +1,187 @@
+package spartanreport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"spartanreport/db"
+	requests "spartanreport/requests"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Event names sent on GET /progression/stream
+const (
+	ProgressionEventStatus     = "status"     // The job, with its phase and matches done out of total
+	ProgressionEventPage       = "page"       // A page of match history landed
+	ProgressionEventMatch      = "match"      // A new match is in detailed_matches
+	ProgressionEventAggregates = "aggregates" // The per playlist averages are ready
+	ProgressionEventDone       = "done"
+	ProgressionEventFailed     = "failed"
+)
+
+// progressionStreamHeartbeat keeps proxies from closing a stream while a page is being fetched
+const progressionStreamHeartbeat = 15 * time.Second
+
+// Workers publish a job's events here and every stream following the job relays them
+func progressionEventsChannel(jobID string) string {
+	return "progression:events:" + jobID
+}
+
+// ProgressionEvent is what's published on a job's channel, Data is the JSON of the SSE event
+type ProgressionEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// ProgressionPageEvent is sent as each page of match history lands
+type ProgressionPageEvent struct {
+	Start    int      `json:"start"`
+	MatchIds []string `json:"matchIds"`
+}
+
+// ProgressionMatchEvent is sent as each new match is stored
+type ProgressionMatchEvent struct {
+	MatchId       string `json:"matchId"`
+	Playlist      string `json:"playlist"`
+	Map           string `json:"map"`
+	StartTime     string `json:"startTime"`
+	Outcome       int    `json:"outcome"`
+	PersonalScore int    `json:"personalScore"`
+}
+
+// ProgressionAggregatesEvent uses the same field names as ProgressionDataToSend so the client can merge it in
+type ProgressionAggregatesEvent struct {
+	AdjustedAverages map[string]int
+	AverageDurations map[string]string
+}
+
+func publishProgressionEvent(jobID string, event string, data interface{}) {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshaling progression event: ", err)
+		return
+	}
+	eventBytes, err := json.Marshal(ProgressionEvent{Event: event, Data: dataBytes})
+	if err != nil {
+		fmt.Println("Error marshaling progression event: ", err)
+		return
+	}
+	if err := db.RedisClient.Publish(context.Background(), progressionEventsChannel(jobID), eventBytes).Err(); err != nil {
+		fmt.Println("Error publishing progression event: ", err)
+	}
+}
+
+// progressionEventHooks publishes the page, match and aggregates events of a sync
+func progressionEventHooks(jobID string, targetPlayerId string) SyncHooks {
+	return SyncHooks{
+		Page: func(page HaloData) {
+			event := ProgressionPageEvent{Start: page.Start, MatchIds: []string{}}
+			for _, result := range page.Results {
+				event.MatchIds = append(event.MatchIds, result.MatchId)
+			}
+			publishProgressionEvent(jobID, ProgressionEventPage, event)
+		},
+		Match: func(result Result) {
+			event := ProgressionMatchEvent{
+				MatchId:   result.MatchId,
+				Playlist:  result.Match.MatchInfo.PlaylistInfo.PublicName,
+				Map:       result.Match.MatchInfo.PublicName,
+				StartTime: result.Match.MatchInfo.StartTime,
+				Outcome:   result.Outcome,
+			}
+			for _, player := range result.Match.Players {
+				if player.PlayerId != targetPlayerId {
+					continue
+				}
+				for _, teamStat := range player.PlayerTeamStats {
+					event.PersonalScore += teamStat.Stats.CoreStats.PersonalScore
+				}
+			}
+			publishProgressionEvent(jobID, ProgressionEventMatch, event)
+		},
+		Aggregates: func(adjustedAverages map[string]int, averageDurations map[string]string) {
+			publishProgressionEvent(jobID, ProgressionEventAggregates, ProgressionAggregatesEvent{
+				AdjustedAverages: adjustedAverages,
+				AverageDurations: averageDurations,
+			})
+		},
+	}
+}
+
+// HandleProgressionStream follows a sync job as Server-Sent Events.
+// Without a jobId query parameter it queues a sync for the caller (or joins the one already running).
+func HandleProgressionStream(c *gin.Context) {
+	gamerInfo := GetSessionGamerInfo(c)
+	ctx := c.Request.Context()
+
+	jobID := c.Query("jobId")
+	if jobID == "" {
+		sessionID, _ := c.Cookie(requests.SessionCookieName)
+		job, err := EnqueueProgressionJob(ctx, sessionID, gamerInfo.XUID)
+		if err != nil {
+			HandleError(c, err)
+			return
+		}
+		jobID = job.Id
+	}
+
+	// Subscribe before reading the job so nothing published in between is missed
+	pubsub := db.RedisClient.Subscribe(ctx, progressionEventsChannel(jobID))
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		HandleError(c, err)
+		return
+	}
+
+	job, err := GetProgressionJob(ctx, jobID)
+	if err == ErrJobNotFound || (err == nil && job.XUID != gamerInfo.XUID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	} else if err != nil {
+		HandleError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent(ProgressionEventStatus, job.public())
+	switch job.Status {
+	case JobDone:
+		c.SSEvent(ProgressionEventDone, job.public())
+		return
+	case JobFailed:
+		c.SSEvent(ProgressionEventFailed, job.public())
+		return
+	}
+	c.Writer.Flush()
+
+	messages := pubsub.Channel()
+	heartbeat := time.NewTicker(progressionStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			return true
+		case msg, ok := <-messages:
+			if !ok {
+				return false
+			}
+			var event ProgressionEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				fmt.Println("Error unmarshaling progression event: ", err)
+				return true
+			}
+			c.SSEvent(event.Event, string(event.Data))
+			return event.Event != ProgressionEventDone && event.Event != ProgressionEventFailed
+		}
+	})
+}
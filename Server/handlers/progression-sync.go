@@ -0,0 +1,432 @@
+package spartanreport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"spartanreport/db"
+	"spartanreport/haloapi"
+	requests "spartanreport/requests"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// As of 2/24, the limit for match history stored upstream is 200 matches
+const (
+	matchHistoryPageSize = 25
+	matchHistoryLimit    = 200
+)
+
+var durationPattern = regexp.MustCompile(`PT(\d+)M(\d+(\.\d+)?)S`)
+
+// ErrSyncConflict is returned when another sync for the same player stored its results first
+var ErrSyncConflict = errors.New("match history was synced concurrently")
+
+// Sync phases reported to SyncHooks.Progress
+const (
+	SyncPhaseMatchHistory = "Fetching match history"
+	SyncPhaseMatches      = "Fetching matches"
+	SyncPhaseSaving       = "Saving"
+)
+
+// SyncHooks let a caller follow a sync as it runs. Any of them can be nil,
+// and they can be called from several goroutines at once.
+type SyncHooks struct {
+	Progress   func(phase string, done, total int) // done and total count the new matches
+	Page       func(page HaloData)                 // A page of match history landed
+	Match      func(result Result)                 // A new match is in detailed_matches, Result.Match is filled in
+	Aggregates func(adjustedAverages map[string]int, averageDurations map[string]string)
+}
+
+func (hooks SyncHooks) progress(phase string, done, total int) {
+	if hooks.Progress != nil {
+		hooks.Progress(phase, done, total)
+	}
+}
+
+func (hooks SyncHooks) page(page HaloData) {
+	if hooks.Page != nil {
+		hooks.Page(page)
+	}
+}
+
+func (hooks SyncHooks) match(result Result) {
+	if hooks.Match != nil {
+		hooks.Match(result)
+	}
+}
+
+func (hooks SyncHooks) aggregates(adjustedAverages map[string]int, averageDurations map[string]string) {
+	if hooks.Aggregates != nil {
+		hooks.Aggregates(adjustedAverages, averageDurations)
+	}
+}
+
+// PlaylistAggregate holds the running totals behind a playlist's averages.
+// They're keyed by playlist AssetId in progression_data and only ever incremented by new matches.
+type PlaylistAggregate struct {
+	PublicName  string
+	ScoreSum    int
+	ScoreCount  int
+	DurationSum time.Duration
+	MatchCount  int
+}
+
+// ProgressionSyncState is the part of a progression_data document owned by the sync engine
+type ProgressionSyncState struct {
+	GamerInfo          requests.GamerInfo
+	MatchDetails       []TruncatedResultsToStore // Newest first
+	LatestMatchId      string                    // Newest match seen upstream, paging stops once it's reached
+	PlaylistAggregates map[string]PlaylistAggregate
+	AdjustedAverages   map[string]int // PlaylistAggregates averaged under scoring rules RulesVersion
+	AverageDurations   map[string]string
+	RulesVersion       int
+}
+
+// SyncMatchHistory brings the stored match history of a player up to date.
+// Only match history pages newer than LatestMatchId are fetched, matches already in detailed_matches are
+// reused instead of refetched, and the playlist aggregates are updated with the new matches alone.
+func SyncMatchHistory(ctx context.Context, gamerInfo requests.GamerInfo, hooks SyncHooks) (ProgressionSyncState, error) {
+	hooks.progress(SyncPhaseMatchHistory, 0, 0)
+
+	rules, err := GetScoringRules(ctx)
+	if err != nil {
+		return ProgressionSyncState{}, err
+	}
+	state, err := loadSyncState(gamerInfo.XUID)
+	if err != nil {
+		return state, err
+	}
+
+	var newResults []Result
+	if state.LatestMatchId == "" {
+		newResults, err = fetchFullMatchHistory(ctx, gamerInfo, hooks)
+	} else {
+		newResults, err = fetchMatchesSince(ctx, gamerInfo, state.LatestMatchId, hooks)
+	}
+	if err != nil {
+		return state, err
+	}
+	if len(newResults) == 0 {
+		return finishSync(ctx, gamerInfo.XUID, state, rules, hooks)
+	}
+
+	// Matches stored by an older sync (before LatestMatchId was tracked) are skipped
+	known := make(map[string]bool, len(state.MatchDetails))
+	if state.LatestMatchId != "" {
+		for _, detail := range state.MatchDetails {
+			known[detail.MatchId] = true
+		}
+	}
+	var unseen []Result
+	for _, result := range newResults {
+		if !known[result.MatchId] {
+			unseen = append(unseen, result)
+		}
+	}
+
+	details, err := loadOrFetchMatches(ctx, gamerInfo.Credentials(), unseen, hooks)
+	if err != nil {
+		return state, err
+	}
+
+	var newDetails []TruncatedResultsToStore
+	var storedResults []Result
+	for _, result := range unseen {
+		match, ok := details[result.MatchId]
+		if !ok {
+			continue
+		}
+		result.Match = match
+		storedResults = append(storedResults, result)
+		newDetails = append(newDetails, TruncatedResultsToStore{
+			MatchId:             result.MatchId,
+			LastTeamId:          result.LastTeamId,
+			Outcome:             result.Outcome,
+			Rank:                result.Rank,
+			PresentAtEndOfMatch: result.PresentAtEndOfMatch,
+		})
+	}
+
+	hooks.progress(SyncPhaseSaving, len(unseen), len(unseen))
+	deltas := make(map[string]PlaylistAggregate)
+	accumulatePlaylistAggregates(deltas, storedResults, "xuid("+gamerInfo.XUID+")", rules.AveragesCutoff)
+
+	if err := saveSyncState(gamerInfo, state, newResults[0].MatchId, newDetails, deltas); err != nil && err != ErrSyncConflict {
+		return state, err
+	} else if err == nil {
+		fmt.Printf("Synced %d new matches for %s\n", len(newDetails), gamerInfo.Gamertag)
+	}
+
+	state, err = loadSyncState(gamerInfo.XUID)
+	if err != nil {
+		return state, err
+	}
+	return finishSync(ctx, gamerInfo.XUID, state, rules, hooks)
+}
+
+// finishSync stores the player's AdjustedAverages under the rules the sync ran with.
+// A sync that lands in between already stored them, so the state is just reloaded.
+func finishSync(ctx context.Context, xuid string, state ProgressionSyncState, rules *ScoringRules, hooks SyncHooks) (ProgressionSyncState, error) {
+	refreshed, err := refreshAdjustedAverages(ctx, state, rules)
+	if err == ErrSyncConflict {
+		refreshed, err = loadSyncState(xuid)
+	}
+	if err != nil {
+		return state, err
+	}
+	hooks.aggregates(refreshed.AdjustedAverages, refreshed.AverageDurations)
+	return refreshed, nil
+}
+
+func loadSyncState(xuid string) (ProgressionSyncState, error) {
+	var state ProgressionSyncState
+	err := db.GetCollection("progression_data").FindOne(context.TODO(), bson.M{"gamerinfo.xuid": xuid}).Decode(&state)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return state, fmt.Errorf("Error loading progression data: %v", err)
+	}
+	return state, nil
+}
+
+// saveSyncState pushes the new matches and increments the aggregates in one update.
+// The update only applies if LatestMatchId hasn't moved since the state was loaded, so a match is never counted twice.
+func saveSyncState(gamerInfo requests.GamerInfo, state ProgressionSyncState, latestMatchID string, newDetails []TruncatedResultsToStore, deltas map[string]PlaylistAggregate) error {
+	collection := db.GetCollection("progression_data")
+
+	// Custom kits can create the document before any sync has run
+	_, err := collection.UpdateOne(context.TODO(),
+		bson.M{"gamerinfo.xuid": gamerInfo.XUID},
+		bson.M{"$setOnInsert": bson.M{"gamerinfo": gamerInfo.WithoutTokens()}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("Error creating progression data: %v", err)
+	}
+
+	filter := bson.M{"gamerinfo.xuid": gamerInfo.XUID}
+	set := bson.M{"gamerinfo": gamerInfo.WithoutTokens(), "latestmatchid": latestMatchID}
+	update := bson.M{"$set": set}
+
+	if state.LatestMatchId == "" {
+		// First sync, anything stored before aggregates were tracked is replaced
+		filter["latestmatchid"] = bson.M{"$in": bson.A{nil, ""}}
+		if newDetails == nil {
+			newDetails = []TruncatedResultsToStore{}
+		}
+		set["matchdetails"] = newDetails
+		set["playlistaggregates"] = deltas
+	} else {
+		filter["latestmatchid"] = state.LatestMatchId
+		if len(newDetails) > 0 {
+			update["$push"] = bson.M{"matchdetails": bson.M{"$each": newDetails, "$position": 0}}
+		}
+		inc := bson.M{}
+		for assetID, delta := range deltas {
+			prefix := "playlistaggregates." + assetID + "."
+			set[prefix+"publicname"] = delta.PublicName
+			inc[prefix+"scoresum"] = delta.ScoreSum
+			inc[prefix+"scorecount"] = delta.ScoreCount
+			inc[prefix+"durationsum"] = delta.DurationSum
+			inc[prefix+"matchcount"] = delta.MatchCount
+		}
+		if len(inc) > 0 {
+			update["$inc"] = inc
+		}
+	}
+
+	res, err := collection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return fmt.Errorf("Error storing progression data: %v", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrSyncConflict
+	}
+	return nil
+}
+
+// fetchFullMatchHistory returns every match the upstream still has for a player, newest first
+func fetchFullMatchHistory(ctx context.Context, gamerInfo requests.GamerInfo, hooks SyncHooks) ([]Result, error) {
+	playerMatchCount := PlayerMatchCount{}
+	if err := haloapi.Default.PlayerMatchCount(ctx, gamerInfo.Credentials(), gamerInfo.XUID, &playerMatchCount); err != nil {
+		return nil, fmt.Errorf("Error getting match count: %v", err)
+	}
+
+	pages, err := GetProgression(ctx, gamerInfo, playerMatchCount.MatchmadeMatchesPlayedCount, hooks.page)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Start < pages[j].Start })
+
+	var results []Result
+	for _, page := range pages {
+		results = append(results, page.Results...)
+	}
+	return results, nil
+}
+
+// fetchMatchesSince pages through the match history newest first until latestMatchID turns up
+func fetchMatchesSince(ctx context.Context, gamerInfo requests.GamerInfo, latestMatchID string, hooks SyncHooks) ([]Result, error) {
+	var results []Result
+	for start := 0; start < matchHistoryLimit; start += matchHistoryPageSize {
+		var page HaloData
+		if err := haloapi.Default.PlayerMatches(ctx, gamerInfo.Credentials(), gamerInfo.XUID, start, matchHistoryPageSize, &page); err != nil {
+			return nil, err
+		}
+		hooks.page(page)
+		for _, result := range page.Results {
+			if result.MatchId == latestMatchID {
+				return results, nil
+			}
+			results = append(results, result)
+		}
+		if len(page.Results) < matchHistoryPageSize {
+			break
+		}
+	}
+	return results, nil
+}
+
+// loadOrFetchMatches returns the formatted match for each result, keyed by MatchId.
+// Matches another player already synced come from detailed_matches, the rest are fetched and inserted.
+// Matches without a playlist (customs) are left out.
+func loadOrFetchMatches(ctx context.Context, creds haloapi.Credentials, results []Result, hooks SyncHooks) (map[string]Match, error) {
+	matches := make(map[string]Match, len(results))
+	if len(results) == 0 {
+		return matches, nil
+	}
+
+	matchIDs := make([]string, len(results))
+	resultsByID := make(map[string]Result, len(results))
+	for i, result := range results {
+		matchIDs[i] = result.MatchId
+		resultsByID[result.MatchId] = result
+	}
+	var stored []Match
+	if err := db.BulkGetData("detailed_matches", bson.M{"MatchId": bson.M{"$in": matchIDs}}, &stored); err != nil {
+		return nil, err
+	}
+	for _, match := range stored {
+		if match.MatchInfo.PlaylistInfo.AssetId != "" {
+			matches[match.MatchId] = match
+			result := resultsByID[match.MatchId]
+			result.Match = match
+			hooks.match(result)
+		}
+	}
+
+	var missing []string
+	for _, matchID := range matchIDs {
+		if _, ok := matches[matchID]; !ok {
+			missing = append(missing, matchID)
+		}
+	}
+	done := len(matchIDs) - len(missing)
+	hooks.progress(SyncPhaseMatches, done, len(matchIDs))
+
+	// A match that fails to fetch fails the sync, otherwise LatestMatchId would move past it for good
+	var firstErr error
+	var mu sync.Mutex
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+	haloapi.Default.FanOut(len(missing), func(i int) {
+		defer func() {
+			mu.Lock()
+			done++
+			hooks.progress(SyncPhaseMatches, done, len(matchIDs))
+			mu.Unlock()
+		}()
+		matchID := missing[i]
+		fetchedMatch, err := GetMatchStats(ctx, creds, matchID)
+		if err != nil {
+			fail(err)
+			return
+		}
+		formattedMatch := formatMatchStats(ctx, creds, fetchedMatch)
+		formattedMatch.MatchInfo = formatMatchTimes(formattedMatch.MatchInfo)
+
+		playlistAssetID := formattedMatch.MatchInfo.Playlist.AssetId
+		playlistVersionID := formattedMatch.MatchInfo.Playlist.VersionId
+		if playlistAssetID == "" || playlistVersionID == "" {
+			return
+		}
+		var playlistInfo PlaylistInfo
+		if err := FetchPlaylistDetails(ctx, creds, playlistAssetID, playlistVersionID, &playlistInfo); err != nil {
+			fail(fmt.Errorf("Error fetching playlist details: %v", err))
+			return
+		}
+		formattedMatch.MatchInfo.PlaylistInfo = playlistInfo
+
+		if err := db.StoreDataMatch("detailed_matches", formattedMatch, matchID); err != nil {
+			fail(fmt.Errorf("Error storing detailed match: %v", err))
+			return
+		}
+
+		mu.Lock()
+		matches[matchID] = formattedMatch
+		mu.Unlock()
+
+		result := resultsByID[matchID]
+		result.Match = formattedMatch
+		hooks.match(result)
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return matches, nil
+}
+
+// accumulatePlaylistAggregates adds the target player's score and the match duration of each result to aggregates,
+// matches before the cutoff were scored differently and are left out
+func accumulatePlaylistAggregates(aggregates map[string]PlaylistAggregate, results []Result, targetPlayerId string, cutoff time.Time) {
+	for _, result := range results {
+		if !result.PresentAtEndOfMatch {
+			continue
+		}
+
+		startTime, err := time.Parse(time.RFC3339Nano, result.Match.MatchInfo.StartTime)
+		if err != nil {
+			fmt.Println("Error parsing start time:", err)
+			continue
+		}
+		if startTime.Before(cutoff) {
+			continue
+		}
+
+		matches := durationPattern.FindStringSubmatch(result.Match.MatchInfo.Duration)
+		if matches == nil {
+			fmt.Println("Failed to parse Duration:", result.Match.MatchInfo.Duration)
+			continue
+		}
+		minutes, _ := strconv.Atoi(matches[1])
+		seconds, _ := strconv.ParseFloat(matches[2], 64)
+		duration := time.Duration(minutes)*time.Minute + time.Duration(seconds*1e9)*time.Nanosecond
+
+		playlist := result.Match.MatchInfo.PlaylistInfo
+		aggregate := aggregates[playlist.AssetId]
+		aggregate.PublicName = playlist.PublicName
+		aggregate.DurationSum += duration
+		aggregate.MatchCount++
+
+		for _, player := range result.Match.Players {
+			if player.PlayerId != targetPlayerId {
+				continue
+			}
+			for _, teamStat := range player.PlayerTeamStats {
+				aggregate.ScoreSum += teamStat.Stats.CoreStats.PersonalScore
+				aggregate.ScoreCount++
+			}
+		}
+		aggregates[playlist.AssetId] = aggregate
+	}
+}
@@ -2,17 +2,15 @@ package spartanreport
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
-	"regexp"
 	"sort"
 	"spartanreport/db"
+	"spartanreport/haloapi"
 	requests "spartanreport/requests"
-	"strconv"
-	"strings"
 	"sync"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -93,16 +91,12 @@ type PlayerMatchCount struct {
 
 func SendRanks(c *gin.Context) {
 	initCache()
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+	gamerInfo := GetSessionGamerInfo(c)
 	careerTrack := GetCareerStats(gamerInfo, c)
 
 	careerLadder := GetCareerLadder(gamerInfo, c)
 
-	careerTrack.CurrentProgress.TotalXPEarned = CalculateTotalXPGainedSoFar(careerLadder, careerTrack.CurrentProgress.Rank) + careerTrack.CurrentProgress.PartialProgress
+	careerTrack.CurrentProgress.TotalXPEarned = CalculateTotalXPGainedSoFar(careerLadder, careerTrack.CurrentProgress)
 
 	rankImages, err := GetRankImageByRank(careerTrack.CurrentProgress.Rank)
 	if err != nil {
@@ -114,7 +108,7 @@ func SendRanks(c *gin.Context) {
 		fmt.Println("Error getting rank images from database ", err)
 	}
 	data := ProgressionDataToSend{
-		GamerInfo:        gamerInfo,
+		GamerInfo:        gamerInfo.WithoutTokens(),
 		CareerTrack:      careerTrack,
 		CareerLadder:     careerLadder,
 		RankImageCurrent: rankImages,
@@ -211,11 +205,27 @@ func GetRankImageByRank(rank int) (RankImage, error) {
 	return rankImage, nil
 }
 
-func PopulatePlayerProgressionData(progressionData *ProgressionDataToSend, gamerInfo requests.GamerInfo, c *gin.Context) {
-	progressionData.GamerInfo = gamerInfo
+// currentAdjustedAverages returns the stored averages of a player, unless the scoring rules changed
+// and the recompute hasn't reached them yet
+func currentAdjustedAverages(ctx context.Context, state ProgressionSyncState) (map[string]int, map[string]string) {
+	rules, err := GetScoringRules(ctx)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if err == nil && state.RulesVersion != rules.Version {
+		return adjustedPlaylistAverages(state.PlaylistAggregates, rules)
+	}
+	return state.AdjustedAverages, state.AverageDurations
+}
+
+func PopulatePlayerProgressionData(progressionData *ProgressionDataToSend, gamerInfo requests.GamerInfo, state ProgressionSyncState, c *gin.Context) {
+	progressionData.GamerInfo = gamerInfo.WithoutTokens()
+
+	progressionData.AdjustedAverages, progressionData.AverageDurations = currentAdjustedAverages(c.Request.Context(), state)
+
 	careerTrack := GetCareerStats(gamerInfo, c)
 	careerLadder := GetCareerLadder(gamerInfo, c)
-	careerTrack.CurrentProgress.TotalXPEarned = CalculateTotalXPGainedSoFar(careerLadder, careerTrack.CurrentProgress.Rank) + careerTrack.CurrentProgress.PartialProgress
+	careerTrack.CurrentProgress.TotalXPEarned = CalculateTotalXPGainedSoFar(careerLadder, careerTrack.CurrentProgress)
 	GetCareerRankImage(careerLadder, &careerTrack, gamerInfo)
 
 	targetPlayerId := "xuid(" + gamerInfo.XUID + ")"
@@ -240,296 +250,21 @@ func PopulatePlayerProgressionData(progressionData *ProgressionDataToSend, gamer
 	progressionData.RankImageNext = nextRankImage
 	progressionData.RankImageCurrent = currentRankImage
 	progressionData.RankImages = rankImages
-	progressionData.RankImages = rankImages
-	// Fetch match IDs from the progression_data document
-	var dataWithMatchIDs struct {
-		GamerInfo    requests.GamerInfo
-		MatchDetails []TruncatedResultsToStore
-	}
-	err = db.GetData("progression_data", bson.M{"gamerinfo.xuid": gamerInfo.XUID}, &dataWithMatchIDs)
-	if err != nil {
-		HandleError(c, err)
-		return
-	}
-
-	// Extract match IDs to a slice
-	matchIDs := make([]string, len(dataWithMatchIDs.MatchDetails))
-	for i, truncatedResult := range dataWithMatchIDs.MatchDetails {
-		matchIDs[i] = truncatedResult.MatchId
-	}
-
-	// Batch fetching from MongoDB
-	filter := bson.M{"MatchId": bson.M{"$in": matchIDs}}
-	cursor, err := db.GetCollection("detailed_matches").Find(context.TODO(), filter)
-	if err != nil {
-		HandleError(c, err)
-		return
-	}
-
-	matchDataMap := make(map[string]Match)
-	for cursor.Next(context.TODO()) {
-		var detailedMatch Match
-		if err := cursor.Decode(&detailedMatch); err != nil {
-			HandleError(c, err)
-			return
-		}
-		matchDataMap[detailedMatch.MatchId] = detailedMatch
-	}
-
-	cursor.Close(context.TODO())
-
-	var haloData HaloData
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-
-	for _, truncatedResult := range dataWithMatchIDs.MatchDetails {
-		wg.Add(1)
-		go func(matchID string, LastTeamId int, Outcome int, Rank int, PresentAtEndOfMatch bool) {
-			defer wg.Done()
-			detailedMatch, exists := matchDataMap[matchID]
-			if !exists {
-				fmt.Println("Data for MatchId not found:", matchID)
-				return
-			}
-
-			mu.Lock()
-			haloData.Results = append(haloData.Results, Result{Match: detailedMatch, MatchId: matchID, LastTeamId: LastTeamId, Outcome: Outcome, Rank: Rank, PresentAtEndOfMatch: PresentAtEndOfMatch})
-			mu.Unlock()
-		}(truncatedResult.MatchId, truncatedResult.LastTeamId, truncatedResult.Outcome, truncatedResult.Rank, truncatedResult.PresentAtEndOfMatch)
-	}
-
-	wg.Wait()
-	playerMatchHistory := haloData
-	playerMatchHistory.Count = len(haloData.Results)
-	playerMatchHistory.ResultCount = len(haloData.Results)
-
-	averages, averageDuration := calculateAveragePersonalScoreForPlaylists(playerMatchHistory, targetPlayerId)
-
-	// Apply multipliers
-	adjustedAverages := applyMultiplierToScores(averages)
-
-	progressionData.GamerInfo = gamerInfo
-	progressionData.AdjustedAverages = adjustedAverages
-	progressionData.AverageDurations = averageDuration
-
 }
 
-func HandleProgression(c *gin.Context) {
-	// Get GamerInfo from front end
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Check for cached data
-	var progressionData ProgressionDataToSend
-	var rankImages []RankImage
-	err := db.GetData("progression_data", bson.M{"gamerinfo.xuid": gamerInfo.XUID}, &progressionData)
-	// Match Data from Database found!
-	fmt.Println("Match Data found!: ", progressionData.AverageDurations)
-	if err == nil && len(fmt.Sprintf("%#v", progressionData.AverageDurations))/1024 != 0 {
-		PopulatePlayerProgressionData(&progressionData, gamerInfo, c)
-		c.JSON(http.StatusOK, progressionData)
-
-		fmt.Println("Size of rankimages: ", len(fmt.Sprintf("%#v", progressionData.RankImages))/1024)
-		fmt.Println("Size of careerLadder: ", len(fmt.Sprintf("%#v", progressionData.CareerLadder))/1024)
-		fmt.Println("Size of careerTrack: ", len(fmt.Sprintf("%#v", progressionData.CareerTrack))/1024)
-		fmt.Println("Size of adjustedAverages: ", len(fmt.Sprintf("%#v", progressionData.AdjustedAverages))/1024)
-		fmt.Println("Size of averageDurations: ", len(fmt.Sprintf("%#v", progressionData.AverageDurations))/1024)
-
-		return
-	}
-	// Endpoint to get total matches played by user
-	playerMatchCount := PlayerMatchCount{}
-	url := fmt.Sprintf("https://halostats.svc.halowaypoint.com/hi/players/xuid(%s)/matches/count", gamerInfo.XUID)
-	makeAPIRequest(gamerInfo.SpartanKey, url, nil, &playerMatchCount)
-
-	matchCount := playerMatchCount.MatchmadeMatchesPlayedCount
-
-	// Get Every Single Match Stat for the player
-	allHaloStats, err := GetProgression(gamerInfo, c, matchCount)
-	if err != nil {
-		HandleError(c, err)
-		return
-	}
-
-	mergedHaloData := HaloData{}
-	var mu sync.Mutex // Mutex for concurrent writes
-
-	if err != nil {
-		HandleError(c, err)
-		return
-	}
-
-	var wg sync.WaitGroup
-	// Get Details for each match
-	for _, haloStats := range allHaloStats {
-		// Loop through the matches in each HaloData batch
-		for i := range haloStats.Results {
-			wg.Add(1) // Increment the WaitGroup counter
-
-			go func(haloStats HaloData, i int) {
-				defer wg.Done() // Decrement the counter when the goroutine completes
-				matchID := haloStats.Results[i].MatchId
-
-				// Fetch and format MatchInfo
-				fetchedMatch, _ := GetMatchStats(c, gamerInfo.SpartanKey, matchID)
-				formattedMatch := formatMatchStats(gamerInfo.SpartanKey, fetchedMatch)
-				formattedMatch.MatchInfo = formatMatchTimes(formattedMatch.MatchInfo)
-				haloStats.Results[i].Match = formattedMatch
-
-				mu.Lock()
-				mergedHaloData.Results = append(mergedHaloData.Results, haloStats.Results[i])
-				mu.Unlock()
-
-			}(haloStats, i)
-		}
-	}
-
-	wg.Wait() // Wait for all goroutines to complete
-
-	var MatchIDsToStore []string
-	var LastTeamIdsToStore []int
-	var OutcomesToStore []int
-	var RankToStore []int
-	var PresentAtEndOfMatch []bool
-
-	for _, haloStats := range allHaloStats {
-		for i := range haloStats.Results {
-			wg.Add(1)
-
-			go func(haloStats HaloData, i int) {
-				defer wg.Done()
-				matchID := haloStats.Results[i].MatchId
-				TeamID := haloStats.Results[i].LastTeamId
-				Outcome := haloStats.Results[i].Outcome
-				Present := haloStats.Results[i].PresentAtEndOfMatch
-				Rank := haloStats.Results[i].Rank
-
-				detailedMatch, _ := GetMatchStats(c, gamerInfo.SpartanKey, matchID)
-				formattedMatch := formatMatchStats(gamerInfo.SpartanKey, detailedMatch)
-				formattedMatch.MatchInfo = formatMatchTimes(formattedMatch.MatchInfo)
-				haloStats.Results[i].Match = formattedMatch
-
-				// For PlaylistInfo
-				playlistAssetID := haloStats.Results[i].Match.MatchInfo.Playlist.AssetId
-				playlistVersionID := haloStats.Results[i].Match.MatchInfo.Playlist.VersionId
-				if playlistAssetID == "" || playlistVersionID == "" {
-					return
-				}
-				var playlistInfo PlaylistInfo
-				fmt.Println("Checking Playlist Details")
-				err := FetchPlaylistDetails(gamerInfo.SpartanKey, playlistAssetID, playlistVersionID, &playlistInfo)
-
-				if err != nil {
-					fmt.Println("Error fetching playlist details ", err)
-					return
-				} else {
-					// Add playlist info to BOTH storage match and match info to be returned
-					formattedMatch.MatchInfo.PlaylistInfo = playlistInfo
-					haloStats.Results[i].Match.MatchInfo.PlaylistInfo = playlistInfo
-
-				}
-
-				// Save detailed match data to a new collection in MongoDB
-				err = db.StoreDataMatch("detailed_matches", formattedMatch, matchID)
-				if err != nil {
-					fmt.Println("Error storing detailed match:", err)
-					return
-				}
-
-				mu.Lock()
-				MatchIDsToStore = append(MatchIDsToStore, matchID)
-				LastTeamIdsToStore = append(LastTeamIdsToStore, TeamID)
-				OutcomesToStore = append(OutcomesToStore, Outcome)
-				RankToStore = append(RankToStore, Rank)
-				PresentAtEndOfMatch = append(PresentAtEndOfMatch, Present)
-
-				mu.Unlock()
-			}(haloStats, i)
-		}
-	}
-
-	wg.Wait()
-	careerTrack := GetCareerStats(gamerInfo, c)
-	careerLadder := GetCareerLadder(gamerInfo, c)
-	careerTrack.CurrentProgress.TotalXPEarned = CalculateTotalXPGainedSoFar(careerLadder, careerTrack.CurrentProgress.Rank) + careerTrack.CurrentProgress.PartialProgress
-	fmt.Println("Got All Rank Images!")
-	rankImages, err = GetRankImagesFromDB()
-	if err != nil {
-		fmt.Println("Error getting rank images from database ", err)
-	}
-
-	// Get next rank and current rank images
-	nextRank := careerTrack.CurrentProgress.Rank + 1
-	currentRankImage, err := GetRankImageByRank(careerTrack.CurrentProgress.Rank)
-	if err != nil {
-		fmt.Println(err) // Assume HandleError is a function you've defined to handle errors
-		return
-	}
-	nextRankImage, err := GetRankImageByRank(nextRank)
-	if err != nil {
-		fmt.Println(err) // Assume HandleError is a function you've defined to handle errors
-		return
-	}
-
-	targetPlayerId := "xuid(" + gamerInfo.XUID + ")"
-	averages, averageDurations := calculateAveragePersonalScoreForPlaylists(mergedHaloData, targetPlayerId)
-
-	// Apply multipliers
-	adjustedAverages := applyMultiplierToScores(averages)
-
-	data := ProgressionDataToSend{
-		RankImageCurrent: currentRankImage,
-		RankImageNext:    nextRankImage,
-		GamerInfo:        gamerInfo,
-		CareerTrack:      careerTrack,
-		CareerLadder:     careerLadder,
-		AdjustedAverages: adjustedAverages,
-		AverageDurations: averageDurations,
-		RankImages:       rankImages,
-	}
-	data.RankImages = rankImages
-	// Remove sensitive information from storing
-	truncatedGamerInfo := gamerInfo
-	truncatedGamerInfo.XBLToken = ""
-	truncatedGamerInfo.SpartanKey = ""
-	dataToStore := struct {
-		GamerInfo    requests.GamerInfo
-		MatchDetails []TruncatedResultsToStore
-	}{
-		GamerInfo: truncatedGamerInfo,
-	}
+// HandleProgressionData returns the caller's progression with the per playlist averages from the last sync
+func HandleProgressionData(c *gin.Context) {
+	gamerInfo := GetSessionGamerInfo(c)
 
-	// Populate the MatchDetails slice
-	for i := range MatchIDsToStore {
-		dataToStore.MatchDetails = append(dataToStore.MatchDetails, TruncatedResultsToStore{
-			MatchId:             MatchIDsToStore[i],
-			LastTeamId:          LastTeamIdsToStore[i],
-			Outcome:             OutcomesToStore[i],
-			Rank:                RankToStore[i],
-			PresentAtEndOfMatch: PresentAtEndOfMatch[i],
-		})
-	}
-	// Cache the data for future use
-	err = db.StoreOrUpdateData("progression_data", dataToStore, dataToStore.GamerInfo)
+	state, err := loadSyncState(gamerInfo.XUID)
 	if err != nil {
 		HandleError(c, err)
 		return
 	}
-	var progressionDataSend ProgressionDataToSend
-	err = db.GetData("progression_data", bson.M{"gamerinfo.xuid": gamerInfo.XUID}, &progressionDataSend)
-	// Match Data from Database found!
-	if err == nil {
-		PopulatePlayerProgressionData(&progressionDataSend, gamerInfo, c)
-		c.JSON(http.StatusOK, progressionDataSend)
-		// Print out size of progressionDataSend in kb
-		fmt.Println("Size of progressionDataSend: ", len(fmt.Sprintf("%#v", progressionDataSend))/1024)
-
-		return
-	}
 
+	var progressionData ProgressionDataToSend
+	PopulatePlayerProgressionData(&progressionData, gamerInfo, state, c)
+	c.JSON(http.StatusOK, progressionData)
 }
 
 func AreRankImagesStored() (bool, error) {
@@ -560,37 +295,22 @@ func GetAllRankImages(careerLadder CareerLadderResponse, gamerInfo requests.Game
 	cacheMutex.RUnlock()
 	var rankImages RankImageSlice
 	var mu sync.Mutex
-	var wg sync.WaitGroup
-
-	for i := 0; i < len(careerLadder.Ranks); i++ {
-		wg.Add(1)
-		go func(rankIndex int) {
-			defer wg.Done()
-			rankLargeIcon := careerLadder.Ranks[rankIndex].RankLargeIcon
-			url := fmt.Sprintf("https://gamecms-hacs.svc.halowaypoint.com/hi/images/file/%s", rankLargeIcon)
-
-			imageData, err := makeAPIRequestImage(gamerInfo.SpartanKey, url, nil)
-
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			imageData, err = compressPNGWithImaging(imageData, false, 0, 0)
 
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
+	haloapi.Default.FanOut(len(careerLadder.Ranks), func(rankIndex int) {
+		rankLargeIcon := careerLadder.Ranks[rankIndex].RankLargeIcon
+		imageData, err := rankIconBase64(context.Background(), gamerInfo.Credentials(), fmt.Sprint(rankLargeIcon))
+		if err != nil {
+			log.Println(err)
+			return
+		}
 
-			mu.Lock()
-			rankImages = append(rankImages, RankImage{
-				Rank:  rankIndex,
-				Image: imageData,
-			})
-			mu.Unlock()
-		}(i)
-	}
-	wg.Wait()
+		mu.Lock()
+		rankImages = append(rankImages, RankImage{
+			Rank:  rankIndex,
+			Image: imageData,
+		})
+		mu.Unlock()
+	})
 
 	// Sort rankImages by Rank in ascending order
 	sort.Sort(rankImages)
@@ -609,22 +329,27 @@ func GetAllRankImages(careerLadder CareerLadderResponse, gamerInfo requests.Game
 	return rankImages, nil
 }
 
+// rankIconBase64 fetches and optimizes a rank icon. Progression responses still carry rank icons as base64
+func rankIconBase64(ctx context.Context, creds haloapi.Credentials, path string) (string, error) {
+	data, err := haloapi.Default.Image(ctx, creds, path)
+	if err != nil {
+		return "", err
+	}
+	data, err = compressPNGWithImaging(data, false, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
 func GetCareerRankImage(careerLadder CareerLadderResponse, careerTrack *RewardTrackResponse, gamerInfo requests.GamerInfo) {
 	currentRankIndex := careerTrack.CurrentProgress.Rank
 
 	// Get image data for current rank
+	ctx := context.Background()
+	creds := gamerInfo.Credentials()
 	rankLargeIcon := careerLadder.Ranks[currentRankIndex].RankLargeIcon
-	url := fmt.Sprintf("https://gamecms-hacs.svc.halowaypoint.com/hi/images/file/%s", rankLargeIcon)
-
-	imageData, err := makeAPIRequestImage(gamerInfo.SpartanKey, url, nil)
-
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	imageData, err = compressPNGWithImaging(imageData, false, 0, 0)
-
+	imageData, err := rankIconBase64(ctx, creds, fmt.Sprint(rankLargeIcon))
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -634,13 +359,10 @@ func GetCareerRankImage(careerLadder CareerLadderResponse, careerTrack *RewardTr
 	// Get image data for previous rank, if applicable
 	if currentRankIndex > 0 {
 		rankLargeIcon := careerLadder.Ranks[currentRankIndex-1].RankLargeIcon
-		url := fmt.Sprintf("https://gamecms-hacs.svc.halowaypoint.com/hi/images/file/%s", rankLargeIcon)
-
-		imageData, err := makeAPIRequestImage(gamerInfo.SpartanKey, url, nil)
+		imageData, err := rankIconBase64(ctx, creds, fmt.Sprint(rankLargeIcon))
 		if err != nil {
 			fmt.Println(err)
 		} else {
-			imageData, err = compressPNGWithImaging(imageData, false, 0, 0)
 			careerTrack.CurrentProgress.PreviousRankIconData = imageData
 		}
 	}
@@ -648,41 +370,29 @@ func GetCareerRankImage(careerLadder CareerLadderResponse, careerTrack *RewardTr
 	// Get image data for next rank, if applicable
 	if currentRankIndex < len(careerLadder.Ranks)-1 {
 		rankLargeIcon := careerLadder.Ranks[currentRankIndex+1].RankLargeIcon
-		url := fmt.Sprintf("https://gamecms-hacs.svc.halowaypoint.com/hi/images/file/%s", rankLargeIcon)
-
-		imageData, err := makeAPIRequestImage(gamerInfo.SpartanKey, url, nil)
+		imageData, err := rankIconBase64(ctx, creds, fmt.Sprint(rankLargeIcon))
 		if err != nil {
 			fmt.Println(err)
 		} else {
-			imageData, err = compressPNGWithImaging(imageData, false, 0, 0)
-
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-
 			careerTrack.CurrentProgress.NextRankIconData = imageData
 		}
 	}
 }
 
-func CalculateTotalXPGainedSoFar(careerLadder CareerLadderResponse, currentRank int) int {
-	totalXpRequiredForRank := 0
-
-	for _, rankInfo := range careerLadder.Ranks {
-		totalXpRequiredForRank += rankInfo.XpRequiredForRank
-
-		if rankInfo.Rank >= currentRank {
-			break
-		}
+// CalculateTotalXPGainedSoFar is the XP earned towards Hero, Ranks[i].XpRequiredForRank being the XP it takes
+// to go from rank i to the next (the client reads the next rank's XP off Ranks[Rank] the same way)
+func CalculateTotalXPGainedSoFar(careerLadder CareerLadderResponse, progress CurrentProgress) int {
+	total := progress.PartialProgress
+	for i := 0; i < progress.Rank && i < len(careerLadder.Ranks); i++ {
+		total += careerLadder.Ranks[i].XpRequiredForRank
 	}
-
-	return totalXpRequiredForRank
+	return total
 }
 
-func GetProgression(gamerInfo requests.GamerInfo, c *gin.Context, matchCount int) ([]HaloData, error) {
-	// As of 2/24, the limit for match history stored is 200 matches.
-	targetMatchCount := 200
+// GetProgression fetches every page of a player's match history, the pages come back in no particular order.
+// onPage (if non-nil) is called as each page lands.
+func GetProgression(ctx context.Context, gamerInfo requests.GamerInfo, matchCount int, onPage func(HaloData)) ([]HaloData, error) {
+	targetMatchCount := matchHistoryLimit
 
 	// If match count is less than the limit of matches stored then we can store all of their matches.
 	if matchCount < targetMatchCount {
@@ -690,55 +400,39 @@ func GetProgression(gamerInfo requests.GamerInfo, c *gin.Context, matchCount int
 
 	}
 	var allData []HaloData
-	dataChan := make(chan HaloData)
-	errChan := make(chan error)
-
-	numRequests := (targetMatchCount + 24) / 25
-	var wg sync.WaitGroup
-	wg.Add(numRequests)
-
-	for start := 0; start < targetMatchCount; start += 25 {
-		go func(start int) {
-			defer wg.Done()
-			var data HaloData
-			url := fmt.Sprintf("https://halostats.svc.halowaypoint.com/hi/players/xuid(%s)/matches?start=%d&count=25", gamerInfo.XUID, start)
-
-			if err := makeAPIRequest(gamerInfo.SpartanKey, url, nil, &data); err != nil {
-				errChan <- err
-				return
-			}
+	var firstErr error
+	var mu sync.Mutex
 
-			dataChan <- data
-		}(start)
-	}
+	numRequests := (targetMatchCount + matchHistoryPageSize - 1) / matchHistoryPageSize
+	haloapi.Default.FanOut(numRequests, func(page int) {
+		var data HaloData
+		if err := haloapi.Default.PlayerMatches(ctx, gamerInfo.Credentials(), gamerInfo.XUID, page*matchHistoryPageSize, matchHistoryPageSize, &data); err != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			return
+		}
 
-	go func() {
-		wg.Wait()
-		close(dataChan)
-		close(errChan)
-	}()
-
-	for i := 0; i < numRequests; i++ {
-		select {
-		case data := <-dataChan:
-			allData = append(allData, data)
-		case err := <-errChan:
-			return nil, err
+		mu.Lock()
+		allData = append(allData, data)
+		mu.Unlock()
+		if onPage != nil {
+			onPage(data)
 		}
-	}
+	})
 
+	if firstErr != nil {
+		return nil, firstErr
+	}
 	return allData, nil
 }
 
 func GetCareerLadder(gamerInfo requests.GamerInfo, c *gin.Context) CareerLadderResponse {
-	url := "https://gamecms-hacs.svc.halowaypoint.com/hi/Progression/file/RewardTracks/CareerRanks/careerRank1.json"
 	var careerLadder CareerLadderResponse
 
-	headers := map[string]string{
-		"343-clearance": gamerInfo.ClearanceCode,
-	}
-
-	if err := makeAPIRequest(gamerInfo.SpartanKey, url, headers, &careerLadder); err != nil {
+	if err := haloapi.Default.ProgressionFile(c.Request.Context(), gamerInfo.Credentials(), "RewardTracks/CareerRanks/careerRank1.json", &careerLadder); err != nil {
 		fmt.Println("Error:", err)
 	}
 
@@ -746,124 +440,11 @@ func GetCareerLadder(gamerInfo requests.GamerInfo, c *gin.Context) CareerLadderR
 }
 
 func GetCareerStats(gamerInfo requests.GamerInfo, c *gin.Context) RewardTrackResponse {
-	url := fmt.Sprintf("https://economy.svc.halowaypoint.com/hi/players/xuid(%s)/rewardtracks/careerranks/careerrank1", gamerInfo.XUID)
 	var careerTrack RewardTrackResponse
 
-	headers := map[string]string{
-		"343-clearance": gamerInfo.ClearanceCode,
-	}
-
-	if err := makeAPIRequest(gamerInfo.SpartanKey, url, headers, &careerTrack); err != nil {
+	if err := haloapi.Default.CareerRankTrack(c.Request.Context(), gamerInfo.Credentials(), gamerInfo.XUID, &careerTrack); err != nil {
 		fmt.Println("Error:", err)
 	}
 
 	return careerTrack
 }
-
-func calculateAveragePersonalScoreForPlaylists(data HaloData, targetPlayerId string) (map[string]float64, map[string]string) {
-	sums := make(map[string]int)
-	counts := make(map[string]int)
-	layoutInput := time.RFC3339Nano // Layout to parse the input time
-	averageDurations := make(map[string]string)
-	durationSums := make(map[string]time.Duration)
-	cutoffDate, err := time.Parse("01/02/2006", "06/20/2023")
-	if err != nil {
-		fmt.Println("Error parsing cutoff date:", err)
-		return map[string]float64{}, nil
-	}
-	for _, result := range data.Results {
-		if !result.PresentAtEndOfMatch {
-			continue
-		}
-
-		// Parse the start time and check if it's before the cutoff date
-		startTime, err := time.Parse(layoutInput, result.Match.MatchInfo.StartTime)
-		if err != nil {
-			fmt.Println("Error parsing start time:", err)
-			continue
-		}
-
-		if startTime.Before(cutoffDate) {
-			continue
-		}
-
-		// Identify the playlist name
-		playlistName := result.Match.MatchInfo.PlaylistInfo.PublicName
-		// Parse duration
-		durationStr := result.Match.MatchInfo.Duration
-		re := regexp.MustCompile(`PT(\d+)M(\d+(\.\d+)?)S`)
-		matches := re.FindStringSubmatch(durationStr)
-		if matches == nil {
-			fmt.Println("Failed to parse Duration:", durationStr)
-			continue
-		}
-		minutes, _ := strconv.Atoi(matches[1])
-		seconds, _ := strconv.ParseFloat(matches[2], 64)
-		duration := time.Duration(minutes)*time.Minute + time.Duration(seconds*1e9)*time.Nanosecond
-
-		if _, exists := durationSums[playlistName]; !exists {
-			durationSums[playlistName] = 0
-		}
-		durationSums[playlistName] += duration
-
-		for _, player := range result.Match.Players {
-			if player.PlayerId != targetPlayerId {
-				continue
-			}
-
-			// Loop through the player's team stats to get the PersonalScore.
-			for _, teamStat := range player.PlayerTeamStats {
-				personalScore := teamStat.Stats.CoreStats.PersonalScore
-
-				if _, exists := sums[playlistName]; !exists {
-					sums[playlistName] = 0
-					counts[playlistName] = 0
-				}
-				sums[playlistName] += personalScore
-				counts[playlistName]++
-			}
-		}
-	}
-
-	// Calculate averages
-	averages := make(map[string]float64)
-	for playlist, sum := range sums {
-		averages[playlist] = float64(sum) / float64(counts[playlist])
-		averageDuration := durationSums[playlist] / time.Duration(counts[playlist])
-		minutes := int(averageDuration.Minutes())
-		seconds := int(averageDuration.Seconds()) % 60
-		averageDurations[playlist] = fmt.Sprintf("%02d:%02d", minutes, seconds)
-	}
-
-	return averages, averageDurations
-}
-
-func getPlaylistMultipliers() map[string]float64 {
-	return map[string]float64{
-		"Bot Bootcamp": 0.2,
-		// Add other playlist names and multipliers here
-	}
-}
-
-// Some playlist allow for higher score multipliers
-func applyMultiplierToScores(averages map[string]float64) map[string]int {
-	multipliers := getPlaylistMultipliers()
-	adjustedAverages := make(map[string]int)
-
-	for playlist, average := range averages {
-		multiplier, exists := multipliers[playlist]
-
-		// Default Multiplier
-		if !exists {
-			multiplier = 1.0
-		}
-		// Big Team Battle Multiplier
-		if strings.Contains(playlist, "BTB") || strings.Contains(playlist, "Big Team") {
-			multiplier = 1.8
-		}
-
-		adjustedAverages[playlist] = int(average * multiplier)
-	}
-
-	return adjustedAverages
-}
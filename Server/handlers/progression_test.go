@@ -0,0 +1,29 @@
+package spartanreport
+
+import "testing"
+
+func TestCalculateTotalXPGainedSoFar(t *testing.T) {
+	ladder := CareerLadderResponse{Ranks: []RankInfo{
+		{Rank: 1, XpRequiredForRank: 50},
+		{Rank: 2, XpRequiredForRank: 100},
+		{Rank: 3, XpRequiredForRank: 200},
+	}}
+	tests := []struct {
+		name     string
+		progress CurrentProgress
+		want     int
+	}{
+		{"new player", CurrentProgress{Rank: 0, PartialProgress: 20}, 20},
+		{"first rank up", CurrentProgress{Rank: 1, PartialProgress: 0}, 50},
+		{"partway up the ladder", CurrentProgress{Rank: 2, PartialProgress: 30}, 180},
+		{"top of the ladder", CurrentProgress{Rank: 3, PartialProgress: 0}, 350},
+		{"rank past the ladder", CurrentProgress{Rank: 7, PartialProgress: 5}, 355},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CalculateTotalXPGainedSoFar(ladder, tt.progress); got != tt.want {
+				t.Errorf("CalculateTotalXPGainedSoFar = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package spartanreport
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"spartanreport/db"
+	"spartanreport/haloapi"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The recent rate of XP gain is taken over the career XP recorded by the syncs of this window
+const xpRateWindow = 14 * 24 * time.Hour
+
+// xpRateMinMatches is the fewest synced matches in the window before the rate is trusted over the averages alone
+const xpRateMinMatches = 5
+
+// The calibration is clamped so a snapshot missing a few matches can't throw the forecast off entirely
+const (
+	minXPCalibration = 0.25
+	maxXPCalibration = 4.0
+)
+
+// CareerXPSnapshot is a player's career rank progress after a sync, stored in career_xp_history when it moved
+type CareerXPSnapshot struct {
+	XUID            string    `bson:"xuid"`
+	RecordedAt      time.Time `bson:"recordedat"`
+	Rank            int       `bson:"rank"`
+	PartialProgress int       `bson:"partialprogress"`
+	TotalXP         int       `bson:"totalxp"`
+}
+
+// RankMilestone is a rank the forecast counts down to: the next rank, the first rank of each tier above and Hero
+type RankMilestone struct {
+	Rank       int
+	Title      string
+	Grade      int
+	XPRequired int // From the current progress
+}
+
+// MilestoneETA is how long reaching a milestone takes in one playlist
+type MilestoneETA struct {
+	Rank    int
+	Matches int
+	Hours   float64
+}
+
+// PlaylistForecast is the estimated XP rate of a playlist with the time it takes to reach each milestone
+type PlaylistForecast struct {
+	Playlist        string
+	XPPerMatch      float64 // The playlist's adjusted average, scaled by the calibration
+	MinutesPerMatch float64
+	XPPerHour       float64
+	Milestones      []MilestoneETA
+}
+
+// RecentXPRate is the XP the player actually gained over their recently synced matches
+type RecentXPRate struct {
+	Since       time.Time
+	Matches     int
+	XPGained    int
+	XPPerMatch  float64
+	XPPerHour   float64 // Over time spent in matches
+	Calibration float64 // XPGained over what the adjusted averages predicted for the same matches, 1 without enough history
+}
+
+// RankForecast is the response of GET /progression/forecast, Playlists are fastest to level first
+type RankForecast struct {
+	CurrentRank       int
+	PartialProgress   int
+	HasReachedMaxRank bool
+	Recent            RecentXPRate
+	Milestones        []RankMilestone
+	Playlists         []PlaylistForecast
+}
+
+// CreateCareerXPIndexes creates the career_xp_history index used to read a player's recent snapshots
+func CreateCareerXPIndexes() error {
+	return db.CreateIndex("career_xp_history", bson.D{{Key: "xuid", Value: 1}, {Key: "recordedat", Value: 1}})
+}
+
+// rankTitle reads the English title of a rank, RankTitle is a localized string
+func rankTitle(rank RankInfo) string {
+	if title, ok := rank.RankTitle.(map[string]interface{}); ok {
+		if value, ok := title["value"].(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// RecordCareerXP stores the player's career rank progress, it runs after each sync so the snapshot lines up with the stored matches
+func RecordCareerXP(ctx context.Context, creds haloapi.Credentials, xuid string) error {
+	var track RewardTrackResponse
+	if err := haloapi.Default.CareerRankTrack(ctx, creds, xuid, &track); err != nil {
+		return err
+	}
+	var ladder CareerLadderResponse
+	if err := haloapi.Default.ProgressionFile(ctx, creds, "RewardTracks/CareerRanks/careerRank1.json", &ladder); err != nil {
+		return err
+	}
+	snapshot := CareerXPSnapshot{
+		XUID:            xuid,
+		RecordedAt:      time.Now().UTC(),
+		Rank:            track.CurrentProgress.Rank,
+		PartialProgress: track.CurrentProgress.PartialProgress,
+		TotalXP:         CalculateTotalXPGainedSoFar(ladder, track.CurrentProgress),
+	}
+
+	collection := db.GetCollection("career_xp_history")
+	var last CareerXPSnapshot
+	err := collection.FindOne(ctx, bson.M{"xuid": xuid}, options.FindOne().SetSort(bson.D{{Key: "recordedat", Value: -1}})).Decode(&last)
+	if err == nil && last.TotalXP == snapshot.TotalXP {
+		return nil
+	} else if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if _, err := collection.InsertOne(ctx, snapshot); err != nil {
+		return fmt.Errorf("Error storing career XP: %v", err)
+	}
+	return nil
+}
+
+// recentXPRate compares the XP gained between the oldest and newest snapshots of the window
+// with what the adjusted averages predict for the matches synced in between
+func recentXPRate(ctx context.Context, xuid string, adjustedAverages map[string]int) (RecentXPRate, error) {
+	rate := RecentXPRate{Calibration: 1}
+	cursor, err := db.GetCollection("career_xp_history").Find(ctx,
+		bson.M{"xuid": xuid, "recordedat": bson.M{"$gte": time.Now().Add(-xpRateWindow)}},
+		options.Find().SetSort(bson.D{{Key: "recordedat", Value: 1}}))
+	if err != nil {
+		return rate, err
+	}
+	var snapshots []CareerXPSnapshot
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		return rate, err
+	}
+	if len(snapshots) < 2 {
+		return rate, nil
+	}
+	first, last := snapshots[0], snapshots[len(snapshots)-1]
+
+	rows, err := loadPlayerMatchRows(ctx, bson.M{
+		"players.playerid": "xuid(" + xuid + ")",
+		"matchinfo.starttime": bson.M{
+			"$gte": matchTimeBound(first.RecordedAt),
+			"$lt":  matchTimeBound(last.RecordedAt),
+		},
+	}, xuid)
+	if err != nil {
+		return rate, err
+	}
+
+	var played time.Duration
+	predicted := 0
+	for _, row := range rows {
+		start, startErr := time.Parse(time.RFC3339Nano, row.StartTime)
+		end, endErr := time.Parse(time.RFC3339Nano, row.EndTime)
+		if startErr == nil && endErr == nil {
+			played += end.Sub(start)
+		}
+		predicted += adjustedAverages[row.PlaylistName]
+	}
+
+	rate.Since = first.RecordedAt
+	rate.Matches = len(rows)
+	rate.XPGained = last.TotalXP - first.TotalXP
+	if rate.Matches > 0 {
+		rate.XPPerMatch = float64(rate.XPGained) / float64(rate.Matches)
+	}
+	if played > 0 {
+		rate.XPPerHour = float64(rate.XPGained) / played.Hours()
+	}
+	if rate.Matches >= xpRateMinMatches && predicted > 0 && rate.XPGained > 0 {
+		rate.Calibration = math.Max(minXPCalibration, math.Min(maxXPCalibration, float64(rate.XPGained)/float64(predicted)))
+	}
+	return rate, nil
+}
+
+// rankMilestones lists the ranks between the current one and Hero worth a forecast
+func rankMilestones(ladder CareerLadderResponse, progress CurrentProgress) []RankMilestone {
+	var milestones []RankMilestone
+	last := len(ladder.Ranks) - 1
+	xpRequired := -progress.PartialProgress
+	for target := progress.Rank + 1; target <= last; target++ {
+		xpRequired += ladder.Ranks[target-1].XpRequiredForRank
+		newTier := rankTitle(ladder.Ranks[target]) != rankTitle(ladder.Ranks[target-1])
+		if target == progress.Rank+1 || newTier || target == last {
+			milestones = append(milestones, RankMilestone{
+				Rank:       target,
+				Title:      rankTitle(ladder.Ranks[target]),
+				Grade:      ladder.Ranks[target].RankGrade,
+				XPRequired: xpRequired,
+			})
+		}
+	}
+	return milestones
+}
+
+// parseAverageDuration reads the mm:ss of AverageDurations in minutes
+func parseAverageDuration(duration string) float64 {
+	parts := strings.SplitN(duration, ":", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+	minutes, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+	seconds, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0
+	}
+	return float64(minutes) + float64(seconds)/60
+}
+
+// ForecastRankProgress estimates how many matches and hours each playlist takes to reach the next rank and each milestone up to Hero.
+// A playlist's XP per match is its adjusted average scaled by how the player's recent XP gain compared with those averages.
+func ForecastRankProgress(ladder CareerLadderResponse, progress CurrentProgress, adjustedAverages map[string]int, averageDurations map[string]string, recent RecentXPRate) RankForecast {
+	forecast := RankForecast{
+		CurrentRank:       progress.Rank,
+		PartialProgress:   progress.PartialProgress,
+		HasReachedMaxRank: progress.HasReachedMaxRank || progress.Rank >= len(ladder.Ranks)-1,
+		Recent:            recent,
+		Milestones:        []RankMilestone{},
+		Playlists:         []PlaylistForecast{},
+	}
+	if forecast.HasReachedMaxRank {
+		return forecast
+	}
+	forecast.Milestones = rankMilestones(ladder, progress)
+
+	for playlist, average := range adjustedAverages {
+		minutes := parseAverageDuration(averageDurations[playlist])
+		xpPerMatch := float64(average) * recent.Calibration
+		if xpPerMatch <= 0 || minutes <= 0 {
+			continue
+		}
+		playlistForecast := PlaylistForecast{
+			Playlist:        playlist,
+			XPPerMatch:      xpPerMatch,
+			MinutesPerMatch: minutes,
+			XPPerHour:       xpPerMatch * 60 / minutes,
+		}
+		for _, milestone := range forecast.Milestones {
+			matches := int(math.Ceil(float64(milestone.XPRequired) / xpPerMatch))
+			playlistForecast.Milestones = append(playlistForecast.Milestones, MilestoneETA{
+				Rank:    milestone.Rank,
+				Matches: matches,
+				Hours:   float64(matches) * minutes / 60,
+			})
+		}
+		forecast.Playlists = append(forecast.Playlists, playlistForecast)
+	}
+	sort.Slice(forecast.Playlists, func(i, j int) bool {
+		if forecast.Playlists[i].XPPerHour != forecast.Playlists[j].XPPerHour {
+			return forecast.Playlists[i].XPPerHour > forecast.Playlists[j].XPPerHour
+		}
+		return forecast.Playlists[i].Playlist < forecast.Playlists[j].Playlist
+	})
+	return forecast
+}
+
+// HandleRankForecast serves GET /progression/forecast for the caller, using the averages of their last sync
+func HandleRankForecast(c *gin.Context) {
+	gamerInfo := GetSessionGamerInfo(c)
+	ctx := c.Request.Context()
+
+	state, err := loadSyncState(gamerInfo.XUID)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	adjustedAverages, averageDurations := currentAdjustedAverages(ctx, state)
+	recent, err := recentXPRate(ctx, gamerInfo.XUID, adjustedAverages)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+
+	careerTrack := GetCareerStats(gamerInfo, c)
+	careerLadder := GetCareerLadder(gamerInfo, c)
+	if len(careerLadder.Ranks) == 0 {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Couldn't load the career ladder"})
+		return
+	}
+	c.JSON(http.StatusOK, ForecastRankProgress(careerLadder, careerTrack.CurrentProgress, adjustedAverages, averageDurations, recent))
+}
@@ -0,0 +1,208 @@
+package spartanreport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"spartanreport/db"
+	"spartanreport/haloapi"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultRelationshipLimit      = 10
+	maxRelationshipLimit          = 50
+	defaultRelationshipMinMatches = 3
+	maxRelationshipMinMatches     = 1000
+)
+
+// profileBatchSize is how many XUIDs go into one profile lookup
+const profileBatchSize = 100
+
+// PlayerRelationship is the record of matches played with or against another player.
+// Wins, Losses and Ties are the player's own outcomes, so against an opponent they're the head to head record.
+type PlayerRelationship struct {
+	XUID     string  `bson:"xuid"`
+	Gamertag string  `bson:"-"`
+	Matches  int     `bson:"matches"`
+	Wins     int     `bson:"wins"`
+	Losses   int     `bson:"losses"`
+	Ties     int     `bson:"ties"`
+	WinRate  float64 `bson:"winRate"`
+}
+
+// PlayerRelationships is the response of GET /players/:xuid/relationships
+type PlayerRelationships struct {
+	Teammates     []PlayerRelationship `bson:"teammates"`     // Most matches together first
+	BestTeammates []PlayerRelationship `bson:"bestTeammates"` // Highest win rate first, over teammates with at least MinMatches together
+	Opponents     []PlayerRelationship `bson:"opponents"`     // Most matches against first
+	MinMatches    int                  `bson:"-"`
+}
+
+// relationshipFacet keeps teammates or opponents, sorts them and adds the fields of PlayerRelationship
+func relationshipFacet(teammate bool, minMatches int, sortBy bson.D, limit int) bson.A {
+	return bson.A{
+		bson.M{"$match": bson.M{"_id.teammate": teammate, "matches": bson.M{"$gte": minMatches}}},
+		bson.M{"$addFields": bson.M{
+			"xuid":    bson.M{"$substrBytes": bson.A{"$_id.playerid", 5, bson.M{"$subtract": bson.A{bson.M{"$strLenBytes": "$_id.playerid"}, 6}}}},
+			"winRate": bson.M{"$divide": bson.A{"$wins", "$matches"}},
+		}},
+		bson.M{"$sort": append(sortBy, bson.E{Key: "_id.playerid", Value: 1})},
+		bson.M{"$limit": limit},
+	}
+}
+
+// GetPlayerRelationships runs one aggregation over detailed_matches for the players a player has played with and against.
+// A teammate is anyone who finished the match on the player's last team. Bots are left out.
+func GetPlayerRelationships(ctx context.Context, creds haloapi.Credentials, query MatchHistoryQuery, limit int, minMatches int) (PlayerRelationships, error) {
+	relationships := PlayerRelationships{
+		Teammates:     []PlayerRelationship{},
+		BestTeammates: []PlayerRelationship{},
+		Opponents:     []PlayerRelationship{},
+		MinMatches:    minMatches,
+	}
+	query.Cursor = ""
+	filter, err := matchHistoryFilter(query)
+	if err != nil {
+		return relationships, err
+	}
+
+	playerID := "xuid(" + query.XUID + ")"
+	countOutcome := func(outcome string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$me.outcome", matchOutcomes[outcome]}}, 1, 0}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$project", Value: bson.M{
+			"me": bson.M{"$arrayElemAt": bson.A{
+				bson.M{"$filter": bson.M{
+					"input": "$players",
+					"as":    "player",
+					"cond":  bson.M{"$eq": bson.A{"$$player.playerid", playerID}},
+				}},
+				0,
+			}},
+			"others": bson.M{"$filter": bson.M{
+				"input": "$players",
+				"as":    "player",
+				"cond": bson.M{"$and": bson.A{
+					bson.M{"$ne": bson.A{"$$player.playerid", playerID}},
+					bson.M{"$ne": bson.A{bson.M{"$substrBytes": bson.A{"$$player.playerid", 0, 4}}, "bid("}},
+				}},
+			}},
+		}}},
+		{{Key: "$unwind", Value: "$others"}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"playerid": "$others.playerid",
+				"teammate": bson.M{"$eq": bson.A{"$others.lastteamid", "$me.lastteamid"}},
+			},
+			"matches": bson.M{"$sum": 1},
+			"wins":    countOutcome("win"),
+			"losses":  countOutcome("loss"),
+			"ties":    countOutcome("tie"),
+		}}},
+		{{Key: "$facet", Value: bson.M{
+			"teammates":     relationshipFacet(true, 1, bson.D{{Key: "matches", Value: -1}}, limit),
+			"bestTeammates": relationshipFacet(true, minMatches, bson.D{{Key: "winRate", Value: -1}, {Key: "matches", Value: -1}}, limit),
+			"opponents":     relationshipFacet(false, 1, bson.D{{Key: "matches", Value: -1}}, limit),
+		}}},
+	}
+
+	cursor, err := db.GetCollection("detailed_matches").Aggregate(ctx, pipeline)
+	if err != nil {
+		return relationships, err
+	}
+	defer cursor.Close(ctx)
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&relationships); err != nil {
+			return relationships, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return relationships, err
+	}
+
+	var xuids []string
+	for _, list := range [][]PlayerRelationship{relationships.Teammates, relationships.BestTeammates, relationships.Opponents} {
+		for _, relationship := range list {
+			xuids = append(xuids, relationship.XUID)
+		}
+	}
+	gamertags := resolveGamertags(ctx, creds, xuids)
+	for _, list := range [][]PlayerRelationship{relationships.Teammates, relationships.BestTeammates, relationships.Opponents} {
+		for i := range list {
+			list[i].Gamertag = gamertags[list[i].XUID]
+		}
+	}
+	return relationships, nil
+}
+
+// resolveGamertags looks up the gamertags of a set of XUIDs, XUIDs that can't be resolved are left out
+func resolveGamertags(ctx context.Context, creds haloapi.Credentials, xuids []string) map[string]string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, xuid := range xuids {
+		if !seen[xuid] {
+			seen[xuid] = true
+			unique = append(unique, xuid)
+		}
+	}
+
+	gamertags := make(map[string]string, len(unique))
+	var mu sync.Mutex
+	batches := (len(unique) + profileBatchSize - 1) / profileBatchSize
+	haloapi.Default.FanOut(batches, func(i int) {
+		end := (i + 1) * profileBatchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		var profiles []PlayerProfile
+		if err := haloapi.Default.Users(ctx, creds, unique[i*profileBatchSize:end], &profiles); err != nil {
+			fmt.Println("Error while fetching player profiles:", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, profile := range profiles {
+			gamertags[profile.XUID] = profile.Gamertag
+		}
+	})
+	return gamertags
+}
+
+// HandlePlayerRelationships serves GET /players/:xuid/relationships.
+// It takes the filters of GET /players/:xuid/matches plus limit, the length of each list,
+// and minMatches, the fewest matches together for a teammate to count towards BestTeammates.
+func HandlePlayerRelationships(c *gin.Context) {
+	query, err := ParseMatchHistoryQuery(c)
+	if err == nil && c.Query("cursor") != "" {
+		err = errors.New("cursor isn't supported here")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := boundedIntQuery(c, "limit", defaultRelationshipLimit, maxRelationshipLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	minMatches, err := boundedIntQuery(c, "minMatches", defaultRelationshipMinMatches, maxRelationshipMinMatches)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	relationships, err := GetPlayerRelationships(c.Request.Context(), GetSessionGamerInfo(c).Credentials(), query, limit, minMatches)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, relationships)
+}
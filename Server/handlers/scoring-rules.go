@@ -0,0 +1,346 @@
+package spartanreport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"spartanreport/db"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// scoringRulesTTL is how long a server keeps the rules before rereading them, so edits made through another server show up
+const scoringRulesTTL = time.Minute
+
+// MultiplierRule scales the average personal score of a playlist.
+// A rule has either a PlaylistAssetId, matched exactly, or a NamePattern, a case insensitive regular expression
+// over the playlist name that only applies to playlists no AssetId rule names. The first matching pattern wins.
+type MultiplierRule struct {
+	PlaylistAssetId string  `bson:"playlistassetid,omitempty" json:"playlistAssetId,omitempty"`
+	NamePattern     string  `bson:"namepattern,omitempty" json:"namePattern,omitempty"`
+	Multiplier      float64 `bson:"multiplier" json:"multiplier"`
+}
+
+// ScoringRules is one version of the rules behind AdjustedAverages, stored in scoring_rules.
+// Versions are never edited, a change stores the next version and the highest one is in force.
+type ScoringRules struct {
+	Version        int              `bson:"version" json:"version"`
+	AveragesCutoff time.Time        `bson:"averagescutoff" json:"averagesCutoff"` // Matches before this were scored differently and are left out
+	Multipliers    []MultiplierRule `bson:"multipliers" json:"multipliers"`
+	Note           string           `bson:"note" json:"note,omitempty"`
+	CreatedAt      time.Time        `bson:"createdat" json:"createdAt"`
+
+	patterns []*regexp.Regexp // Compiled NamePatterns, nil for AssetId rules
+}
+
+// defaultScoringRules seed scoring_rules with what used to be hardcoded
+var defaultScoringRules = ScoringRules{
+	Version:        1,
+	AveragesCutoff: time.Date(2023, time.June, 20, 0, 0, 0, 0, time.UTC),
+	Multipliers: []MultiplierRule{
+		{NamePattern: "^Bot Bootcamp$", Multiplier: 0.2},
+		{NamePattern: "BTB|Big Team", Multiplier: 1.8},
+	},
+	Note: "Initial rules",
+}
+
+var (
+	scoringRules         *ScoringRules
+	scoringRulesLoadedAt time.Time
+	scoringRulesMutex    sync.Mutex
+)
+
+// compile checks the rules and compiles their name patterns
+func (rules *ScoringRules) compile() error {
+	rules.patterns = make([]*regexp.Regexp, len(rules.Multipliers))
+	for i, rule := range rules.Multipliers {
+		if (rule.PlaylistAssetId == "") == (rule.NamePattern == "") {
+			return fmt.Errorf("multiplier %d needs either a playlistAssetId or a namePattern", i)
+		}
+		if rule.Multiplier <= 0 {
+			return fmt.Errorf("multiplier %d must be above 0", i)
+		}
+		if rule.NamePattern != "" {
+			pattern, err := regexp.Compile("(?i)" + rule.NamePattern)
+			if err != nil {
+				return fmt.Errorf("multiplier %d has an invalid namePattern: %v", i, err)
+			}
+			rules.patterns[i] = pattern
+		}
+	}
+	return nil
+}
+
+// Multiplier returns the multiplier of a playlist, 1 when no rule matches
+func (rules *ScoringRules) Multiplier(assetID string, publicName string) float64 {
+	for _, rule := range rules.Multipliers {
+		if rule.PlaylistAssetId != "" && rule.PlaylistAssetId == assetID {
+			return rule.Multiplier
+		}
+	}
+	for i, rule := range rules.Multipliers {
+		if rules.patterns[i] != nil && rules.patterns[i].MatchString(publicName) {
+			return rule.Multiplier
+		}
+	}
+	return 1.0
+}
+
+// CreateScoringRulesIndexes creates the unique scoring_rules version index, which also keeps two edits from taking the same version
+func CreateScoringRulesIndexes() error {
+	_, err := db.GetCollection("scoring_rules").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// GetScoringRules returns the rules in force, seeding scoring_rules with defaultScoringRules when it's empty
+func GetScoringRules(ctx context.Context) (*ScoringRules, error) {
+	scoringRulesMutex.Lock()
+	defer scoringRulesMutex.Unlock()
+	if scoringRules != nil && time.Since(scoringRulesLoadedAt) < scoringRulesTTL {
+		return scoringRules, nil
+	}
+
+	var rules ScoringRules
+	err := db.GetCollection("scoring_rules").FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})).Decode(&rules)
+	if err == mongo.ErrNoDocuments {
+		rules = defaultScoringRules
+		rules.CreatedAt = time.Now().UTC()
+		if _, err := db.GetCollection("scoring_rules").InsertOne(ctx, rules); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("Error seeding scoring rules: %v", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("Error loading scoring rules: %v", err)
+	}
+	if err := rules.compile(); err != nil {
+		return nil, fmt.Errorf("Stored scoring rules version %d are invalid: %v", rules.Version, err)
+	}
+
+	scoringRules = &rules
+	scoringRulesLoadedAt = time.Now()
+	return scoringRules, nil
+}
+
+// adjustedPlaylistAverages turns the aggregates into the per playlist name averages the client shows,
+// with the multipliers applied to the scores. Aggregates are kept per AssetId, the ones sharing a name
+// (a playlist reissued under a new AssetId) are summed before averaging.
+func adjustedPlaylistAverages(aggregates map[string]PlaylistAggregate, rules *ScoringRules) (map[string]int, map[string]string) {
+	type namedTotals struct {
+		adjustedScoreSum float64
+		scoreCount       int
+		durationSum      time.Duration
+	}
+	byName := make(map[string]*namedTotals)
+	for assetID, aggregate := range aggregates {
+		if aggregate.ScoreCount == 0 {
+			continue
+		}
+		totals := byName[aggregate.PublicName]
+		if totals == nil {
+			totals = &namedTotals{}
+			byName[aggregate.PublicName] = totals
+		}
+		totals.adjustedScoreSum += float64(aggregate.ScoreSum) * rules.Multiplier(assetID, aggregate.PublicName)
+		totals.scoreCount += aggregate.ScoreCount
+		totals.durationSum += aggregate.DurationSum
+	}
+
+	adjustedAverages := make(map[string]int, len(byName))
+	averageDurations := make(map[string]string, len(byName))
+	for name, totals := range byName {
+		adjustedAverages[name] = int(totals.adjustedScoreSum / float64(totals.scoreCount))
+		averageDuration := totals.durationSum / time.Duration(totals.scoreCount)
+		minutes := int(averageDuration.Minutes())
+		seconds := int(averageDuration.Seconds()) % 60
+		averageDurations[name] = fmt.Sprintf("%02d:%02d", minutes, seconds)
+	}
+	return adjustedAverages, averageDurations
+}
+
+// rebuildPlaylistAggregates recomputes a player's aggregates from their stored matches
+func rebuildPlaylistAggregates(state ProgressionSyncState, cutoff time.Time) (map[string]PlaylistAggregate, error) {
+	aggregates := make(map[string]PlaylistAggregate)
+	if len(state.MatchDetails) == 0 {
+		return aggregates, nil
+	}
+	matchIDs := make([]string, len(state.MatchDetails))
+	for i, detail := range state.MatchDetails {
+		matchIDs[i] = detail.MatchId
+	}
+	var matches []Match
+	if err := db.BulkGetData("detailed_matches", bson.M{"MatchId": bson.M{"$in": matchIDs}}, &matches); err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Match, len(matches))
+	for _, match := range matches {
+		byID[match.MatchId] = match
+	}
+
+	var results []Result
+	for _, detail := range state.MatchDetails {
+		if match, ok := byID[detail.MatchId]; ok {
+			results = append(results, Result{MatchId: detail.MatchId, PresentAtEndOfMatch: detail.PresentAtEndOfMatch, Match: match})
+		}
+	}
+	accumulatePlaylistAggregates(aggregates, results, "xuid("+state.GamerInfo.XUID+")", cutoff)
+	return aggregates, nil
+}
+
+// refreshAdjustedAverages stores the AdjustedAverages and AverageDurations of a player under the rules in force.
+// A player last averaged under an older version has their aggregates rebuilt first, since the cutoff may have moved.
+// The update only applies if no sync landed in between, otherwise ErrSyncConflict is returned.
+func refreshAdjustedAverages(ctx context.Context, state ProgressionSyncState, rules *ScoringRules) (ProgressionSyncState, error) {
+	if state.LatestMatchId == "" {
+		return state, nil
+	}
+	set := bson.M{"rulesversion": rules.Version}
+	if state.RulesVersion != rules.Version {
+		aggregates, err := rebuildPlaylistAggregates(state, rules.AveragesCutoff)
+		if err != nil {
+			return state, err
+		}
+		state.PlaylistAggregates = aggregates
+		set["playlistaggregates"] = aggregates
+	}
+	state.AdjustedAverages, state.AverageDurations = adjustedPlaylistAverages(state.PlaylistAggregates, rules)
+	state.RulesVersion = rules.Version
+	set["adjustedaverages"] = state.AdjustedAverages
+	set["averagedurations"] = state.AverageDurations
+
+	res, err := db.GetCollection("progression_data").UpdateOne(ctx,
+		bson.M{"gamerinfo.xuid": state.GamerInfo.XUID, "latestmatchid": state.LatestMatchId},
+		bson.M{"$set": set})
+	if err != nil {
+		return state, fmt.Errorf("Error storing adjusted averages: %v", err)
+	}
+	if res.MatchedCount == 0 {
+		return state, ErrSyncConflict
+	}
+	return state, nil
+}
+
+// RecomputeAdjustedAverages brings every player averaged under an older version of the rules up to date.
+// It runs at startup and after every rules change, players synced while it runs are retried.
+func RecomputeAdjustedAverages(ctx context.Context) error {
+	rules, err := GetScoringRules(ctx)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"latestmatchid": bson.M{"$nin": bson.A{nil, ""}}, "rulesversion": bson.M{"$ne": rules.Version}}
+	cursor, err := db.GetCollection("progression_data").Find(ctx, filter, options.Find().SetProjection(bson.M{"gamerinfo.xuid": 1}))
+	if err != nil {
+		return err
+	}
+	var players []struct {
+		GamerInfo struct {
+			XUID string `bson:"xuid"`
+		} `bson:"gamerinfo"`
+	}
+	if err := cursor.All(ctx, &players); err != nil {
+		return err
+	}
+
+	recomputed := 0
+	for _, player := range players {
+		for attempt := 0; attempt < 3; attempt++ {
+			state, err := loadSyncState(player.GamerInfo.XUID)
+			if err == nil {
+				_, err = refreshAdjustedAverages(ctx, state, rules)
+			}
+			if err == ErrSyncConflict {
+				continue
+			}
+			if err != nil {
+				fmt.Println("Error recomputing adjusted averages of", player.GamerInfo.XUID, ": ", err)
+			} else {
+				recomputed++
+			}
+			break
+		}
+	}
+	if recomputed > 0 {
+		fmt.Println("Recomputed adjusted averages of", recomputed, "players under scoring rules version", rules.Version)
+	}
+	return nil
+}
+
+// ScoringRulesResponse is the response of GET /admin/scoring-rules
+type ScoringRulesResponse struct {
+	Current  *ScoringRules  `json:"current"`
+	Versions []ScoringRules `json:"versions"` // Newest first
+}
+
+// HandleGetScoringRules serves GET /admin/scoring-rules
+func HandleGetScoringRules(c *gin.Context) {
+	current, err := GetScoringRules(c.Request.Context())
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	cursor, err := db.GetCollection("scoring_rules").Find(c.Request.Context(), bson.M{}, options.Find().SetSort(bson.D{{Key: "version", Value: -1}}))
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	versions := []ScoringRules{}
+	if err := cursor.All(c.Request.Context(), &versions); err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ScoringRulesResponse{Current: current, Versions: versions})
+}
+
+// HandlePutScoringRules serves PUT /admin/scoring-rules. The body becomes the next version of the rules
+// and every player's AdjustedAverages are recomputed in the background.
+func HandlePutScoringRules(c *gin.Context) {
+	var rules ScoringRules
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rules.AveragesCutoff.IsZero() {
+		rules.AveragesCutoff = time.Unix(0, 0).UTC()
+	}
+	if rules.Multipliers == nil {
+		rules.Multipliers = []MultiplierRule{}
+	}
+	if err := rules.compile(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	current, err := GetScoringRules(c.Request.Context())
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	rules.Version = current.Version + 1
+	rules.CreatedAt = time.Now().UTC()
+	if _, err := db.GetCollection("scoring_rules").InsertOne(c.Request.Context(), rules); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The rules were changed concurrently, reload them and try again"})
+			return
+		}
+		HandleError(c, err)
+		return
+	}
+
+	scoringRulesMutex.Lock()
+	scoringRules = nil
+	scoringRulesMutex.Unlock()
+
+	go func() {
+		if err := RecomputeAdjustedAverages(context.Background()); err != nil {
+			fmt.Println("Error recomputing adjusted averages: ", err)
+		}
+	}()
+	c.JSON(http.StatusOK, rules)
+}
@@ -0,0 +1,108 @@
+package spartanreport
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScoringRulesCompileRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule MultiplierRule
+		want string
+	}{
+		{"neither AssetId nor pattern", MultiplierRule{Multiplier: 2}, "needs either a playlistAssetId or a namePattern"},
+		{"both AssetId and pattern", MultiplierRule{PlaylistAssetId: "btb", NamePattern: "BTB", Multiplier: 2}, "needs either a playlistAssetId or a namePattern"},
+		{"zero multiplier", MultiplierRule{PlaylistAssetId: "btb"}, "must be above 0"},
+		{"negative multiplier", MultiplierRule{NamePattern: "BTB", Multiplier: -1}, "must be above 0"},
+		{"invalid pattern", MultiplierRule{NamePattern: "Big (Team", Multiplier: 2}, "invalid namePattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The bad rule comes second so the error names its index
+			rules := &ScoringRules{Multipliers: []MultiplierRule{{PlaylistAssetId: "ok", Multiplier: 1}, tt.rule}}
+			err := rules.compile()
+			if err == nil || !strings.Contains(err.Error(), "multiplier 1 ") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("compile() = %v, want an error about multiplier 1 containing %q", err, tt.want)
+			}
+		})
+	}
+
+	defaults := defaultScoringRules
+	if err := defaults.compile(); err != nil {
+		t.Errorf("the default rules don't compile: %v", err)
+	}
+}
+
+func TestScoringRulesMultiplier(t *testing.T) {
+	rules := &ScoringRules{Multipliers: []MultiplierRule{
+		{NamePattern: "big team", Multiplier: 1.8},
+		{NamePattern: "^Big Team Battle$", Multiplier: 3},
+		{PlaylistAssetId: "btb-heavies", Multiplier: 2.5},
+		{NamePattern: "^Bot Bootcamp$", Multiplier: 0.2},
+	}}
+	if err := rules.compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		assetID    string
+		publicName string
+		want       float64
+	}{
+		{"AssetId rule wins over an earlier pattern", "btb-heavies", "Big Team Heavies", 2.5},
+		{"first matching pattern wins", "btb", "Big Team Battle", 1.8},
+		{"patterns ignore case", "btb-social", "BIG TEAM Social", 1.8},
+		{"anchored pattern", "bootcamp", "Bot Bootcamp", 0.2},
+		{"anchored pattern needs the whole name", "bootcamp-2", "Bot Bootcamp 2", 1.0},
+		{"no rule matches", "quickplay", "Quick Play", 1.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Multiplier(tt.assetID, tt.publicName); got != tt.want {
+				t.Errorf("Multiplier(%q, %q) = %v, want %v", tt.assetID, tt.publicName, got, tt.want)
+			}
+		})
+	}
+
+	empty := &ScoringRules{}
+	if err := empty.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if got := empty.Multiplier("btb", "Big Team Battle"); got != 1.0 {
+		t.Errorf("Multiplier without rules = %v, want 1", got)
+	}
+}
+
+func TestAdjustedPlaylistAveragesMergesSharedNames(t *testing.T) {
+	rules := &ScoringRules{Multipliers: []MultiplierRule{{PlaylistAssetId: "btb-new", Multiplier: 2}}}
+	if err := rules.compile(); err != nil {
+		t.Fatal(err)
+	}
+	aggregates := map[string]PlaylistAggregate{
+		"btb-old": {PublicName: "Big Team Battle", ScoreSum: 3000, ScoreCount: 3, DurationSum: 30 * time.Minute, MatchCount: 3},
+		"btb-new": {PublicName: "Big Team Battle", ScoreSum: 1000, ScoreCount: 1, DurationSum: 14 * time.Minute, MatchCount: 1},
+		"slayer":  {PublicName: "Quick Play", ScoreSum: 500, ScoreCount: 2, DurationSum: 20*time.Minute + 30*time.Second, MatchCount: 2},
+		"empty":   {PublicName: "Ranked Arena"},
+	}
+
+	averages, durations := adjustedPlaylistAverages(aggregates, rules)
+
+	// (3000 + 1000 * 2) / 4, whichever AssetId the map yields first
+	if got := averages["Big Team Battle"]; got != 1250 {
+		t.Errorf("Big Team Battle average = %d, want 1250", got)
+	}
+	if got := durations["Big Team Battle"]; got != "11:00" {
+		t.Errorf("Big Team Battle duration = %q, want 11:00", got)
+	}
+	if got := averages["Quick Play"]; got != 250 {
+		t.Errorf("Quick Play average = %d, want 250", got)
+	}
+	if got := durations["Quick Play"]; got != "10:15" {
+		t.Errorf("Quick Play duration = %q, want 10:15", got)
+	}
+	if _, ok := averages["Ranked Arena"]; ok {
+		t.Error("playlist without scores shouldn't have an average")
+	}
+}
@@ -0,0 +1,143 @@
+package spartanreport
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"spartanreport/haloapi"
+	requests "spartanreport/requests"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadSession resolves the session cookie into the GamerInfo stored server side
+func loadSession(c *gin.Context) (requests.GamerInfo, bool) {
+	sessionID, err := c.Cookie(requests.SessionCookieName)
+	if err != nil {
+		return requests.GamerInfo{}, false
+	}
+
+	session, err := requests.GetSession(c.Request.Context(), sessionID)
+	if err != nil {
+		if err != requests.ErrSessionNotFound {
+			fmt.Println("Error loading session: ", err)
+		}
+		return requests.GamerInfo{}, false
+	}
+
+	// Renew the Spartan token ahead of its ExpiresUtc deadline
+	if session.NeedsRefresh() {
+		refreshed, err := requests.RefreshSession(c.Request.Context(), sessionID)
+		if err != nil {
+			fmt.Println("Error refreshing session: ", err)
+			if time.Now().After(session.SpartanTokenExpires) {
+				return requests.GamerInfo{}, false
+			}
+		} else {
+			session = refreshed
+		}
+	}
+	return session.GamerInfo, true
+}
+
+// RequireSession rejects requests without a valid session and puts the caller's GamerInfo on the context
+func RequireSession(c *gin.Context) {
+	gamerInfo, ok := loadSession(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Not signed in"})
+		return
+	}
+	c.Set(GamerInfoKey, gamerInfo)
+	c.Next()
+}
+
+// OptionalSession is used on routes that also serve signed out users (store, operations).
+// When there is no session an empty GamerInfo is placed on the context, and the request's
+// context is tagged with the client IP to limit its upstream calls by.
+func OptionalSession(c *gin.Context) {
+	gamerInfo, ok := loadSession(c)
+	if !ok {
+		c.Request = c.Request.WithContext(haloapi.WithClientIP(c.Request.Context(), c.ClientIP()))
+	}
+	c.Set(GamerInfoKey, gamerInfo)
+	c.Next()
+}
+
+// RequireAdmin guards the admin routes with the ADMIN_TOKEN bearer token, they're disabled while it's unset
+func RequireAdmin(c *gin.Context) {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Not authorized"})
+		return
+	}
+	c.Next()
+}
+
+// requestOrigin is the Origin header, or the origin of the Referer when a browser left Origin out
+func requestOrigin(req *http.Request) string {
+	if origin := req.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+	referer, err := url.Parse(req.Header.Get("Referer"))
+	if err != nil || referer.Scheme == "" || referer.Host == "" {
+		return ""
+	}
+	return referer.Scheme + "://" + referer.Host
+}
+
+// sameOrigin reports whether origin is the front end at HOST
+func sameOrigin(origin string, host string) bool {
+	host = strings.TrimSuffix(host, "/")
+	return origin != "" && host != "" && strings.EqualFold(origin, host)
+}
+
+// RequireSameOrigin rejects writes that don't come from the front end at HOST.
+// Over https the session cookie is SameSite=None, so any other site could otherwise post to our routes with it.
+func RequireSameOrigin(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+	if !sameOrigin(requestOrigin(c.Request), os.Getenv("HOST")) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Cross-site request refused"})
+		return
+	}
+	c.Next()
+}
+
+// RequireJSON only lets application/json bodies through. Those need a CORS preflight,
+// unlike the text/plain and form posts another site can send without one.
+func RequireJSON(c *gin.Context) {
+	if c.ContentType() != "application/json" {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/json"})
+		return
+	}
+	c.Next()
+}
+
+// GetSessionGamerInfo returns the GamerInfo placed on the context by RequireSession/OptionalSession
+func GetSessionGamerInfo(c *gin.Context) requests.GamerInfo {
+	if val, exists := c.Get(GamerInfoKey); exists {
+		if gamerInfo, ok := val.(requests.GamerInfo); ok {
+			return gamerInfo
+		}
+	}
+	return requests.GamerInfo{}
+}
+
+// HandleLogout deletes the server side session and clears the cookie
+func HandleLogout(c *gin.Context) {
+	if sessionID, err := c.Cookie(requests.SessionCookieName); err == nil {
+		if err := requests.DeleteSession(c.Request.Context(), sessionID); err != nil {
+			fmt.Println("Error deleting session: ", err)
+		}
+	}
+	requests.ClearSessionCookie(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out"})
+}
@@ -0,0 +1,68 @@
+package spartanreport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func guardedRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/kit", RequireSameOrigin, ok)
+	r.POST("/kit", RequireSameOrigin, RequireJSON, ok)
+	return r
+}
+
+func TestRequireSameOriginAndJSON(t *testing.T) {
+	t.Setenv("HOST", "https://spartanreport.com/")
+	tests := []struct {
+		name        string
+		method      string
+		headers     map[string]string
+		contentType string
+		want        int
+	}{
+		{"front end json post", http.MethodPost, map[string]string{"Origin": "https://spartanreport.com"}, "application/json;charset=utf-8", http.StatusOK},
+		{"referer from the front end", http.MethodPost, map[string]string{"Referer": "https://spartanreport.com/armory?tab=kits"}, "application/json", http.StatusOK},
+		{"another site", http.MethodPost, map[string]string{"Origin": "https://evil.example"}, "application/json", http.StatusForbidden},
+		{"look-alike host", http.MethodPost, map[string]string{"Origin": "https://spartanreport.com.evil.example"}, "application/json", http.StatusForbidden},
+		{"null origin", http.MethodPost, map[string]string{"Origin": "null"}, "application/json", http.StatusForbidden},
+		{"no origin or referer", http.MethodPost, nil, "application/json", http.StatusForbidden},
+		{"plain text form post", http.MethodPost, map[string]string{"Origin": "https://spartanreport.com"}, "text/plain", http.StatusUnsupportedMediaType},
+		{"urlencoded form post", http.MethodPost, map[string]string{"Origin": "https://spartanreport.com"}, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"reads aren't checked", http.MethodGet, map[string]string{"Origin": "https://evil.example"}, "", http.StatusOK},
+	}
+	r := guardedRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/kit", strings.NewReader("{}"))
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireSameOriginWithoutHost(t *testing.T) {
+	t.Setenv("HOST", "")
+	req := httptest.NewRequest(http.MethodPost, "/kit", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	guardedRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -3,14 +3,12 @@ package spartanreport
 import (
 	"fmt"
 	"net/http"
-	requests "spartanreport/requests"
 	. "spartanreport/structures"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ArmorCoreEquip struct {
-	GamerInfo         requests.GamerInfo
 	CurrentlyEquipped CurrentlyEquipped
 }
 
@@ -19,16 +17,19 @@ type Items struct {
 }
 
 type ItemsInInventory struct {
-	Amount        int          `json:"Amount"`
-	ItemId        string       `json:"ItemId"`
-	ItemPath      string       `json:"ItemPath"`
-	ItemType      string       `json:"ItemType"`
-	ItemImageData string       `json:"ItemImageData"`
-	ItemMetaData  Item         `json:"Item"`
-	DetailedItem  ItemResponse `json:"DetailedItem"`
+	Amount       int          `json:"Amount"`
+	ItemId       string       `json:"ItemId"`
+	ItemPath     string       `json:"ItemPath"`
+	ItemType     string       `json:"ItemType"`
+	ItemImageURL string       `json:"ItemImageURL"`
+	ItemMetaData Item         `json:"Item"`
+	DetailedItem ItemResponse `json:"DetailedItem"`
+	// Base64 image of entries stored before the image store, moved into it on read
+	ItemImageData string `json:"ItemImageData,omitempty"`
 }
 type ItemJustImage struct {
-	ItemImageData string `json:"ItemImageData"`
+	ItemImageURL  string `json:"ItemImageURL"`
+	ItemImageData string `json:"ItemImageData,omitempty"` // Base64 image of entries stored before the image store, moved into it on read
 }
 
 func HandleEquipArmor(c *gin.Context) {
@@ -39,7 +40,7 @@ func HandleEquipArmor(c *gin.Context) {
 		return
 	}
 
-	gamerInfo := ArmorCoreData.GamerInfo
+	gamerInfo := GetSessionGamerInfo(c)
 	fmt.Println("Helmet Path: ", ArmorCoreData.CurrentlyEquipped.Helmet.CoreId)
 	fmt.Println("Visor Path: ", ArmorCoreData.CurrentlyEquipped.Visor.CoreId)
 
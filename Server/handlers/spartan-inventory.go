@@ -3,16 +3,13 @@ package spartanreport
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"image"
-	"image/png"
-	"io"
-	"io/ioutil"
 	"net/http"
-	"os/exec"
 	"spartanreport/db"
+	"spartanreport/haloapi"
+	"spartanreport/imageopt"
 	requests "spartanreport/requests"
 	. "spartanreport/structures"
 	"strings"
@@ -198,13 +195,8 @@ type DataToReturn struct {
 }
 
 func HandleInventory(c *gin.Context) {
-	// Get GamerInfo from request
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	// Check GamerInfo token to ensure it's valid
+	// Get GamerInfo from the session
+	gamerInfo := GetSessionGamerInfo(c)
 	newGamerInfo := gamerInfo
 	// Get Player Inventory
 	playerInventory, err := GetInventory(c, newGamerInfo)
@@ -217,7 +209,7 @@ func HandleInventory(c *gin.Context) {
 	}
 	includeArmory := c.Query("includeArmory") == "true"
 	data := DataToReturn{
-		GamerInfo:       newGamerInfo,
+		GamerInfo:       newGamerInfo.WithoutTokens(),
 		PlayerInventory: playerInventory,
 	}
 	// If includeArmory is true, get every armor piece in the players inventory and organize them into their own rows.
@@ -229,12 +221,10 @@ func HandleInventory(c *gin.Context) {
 		// Get Player Inventory
 		var InventoryResults = Items{}
 		// If not found in cache, proceed to make the API request.
-		url := "https://economy.svc.halowaypoint.com/hi/players/xuid(" + gamerInfo.XUID + ")/Inventory"
-		hdrs := map[string]string{
-			"343-clearance": gamerInfo.ClearanceCode,
-		}
 		start := time.Now()
-		makeAPIRequest(gamerInfo.SpartanKey, url, hdrs, &InventoryResults)
+		if err := haloapi.Default.PlayerInventory(c.Request.Context(), gamerInfo.Credentials(), gamerInfo.XUID, &InventoryResults); err != nil {
+			fmt.Println("Error getting inventory: ", err)
+		}
 		elapsed := time.Since(start)
 		fmt.Printf("Time to get inventory: %s\n", elapsed)
 
@@ -280,9 +270,10 @@ func HandleInventory(c *gin.Context) {
 			if err := json.Unmarshal([]byte(val.(string)), &existingItem); err != nil {
 				fmt.Printf("Error unmarshalling item from Redis: %v\n", err)
 			} else {
+				migrateInlineItemImage(ctx, itemPaths[i], &existingItem)
 				// If the item is not a Custom Armor Kit, remove the image data
 				if existingItem.ItemType != "ArmorKitCustom" && existingItem.ItemType != "ArmorKit" {
-					existingItem.ItemImageData = ""
+					existingItem.ItemImageURL = ""
 				}
 				// Item found, add to existing items
 				existingItems.InventoryItems = append(existingItems.InventoryItems, existingItem)
@@ -326,12 +317,33 @@ func HandleInventory(c *gin.Context) {
 	}
 
 	data = loadArmoryRow(data, playerInventory)
-	data.GamerInfo = newGamerInfo
+	data.GamerInfo = newGamerInfo.WithoutTokens()
 	data.Items = Items{}
 
 	c.JSON(http.StatusOK, data)
 }
 
+// migrateInlineItemImage moves the base64 image of an items entry from before the image store into it
+// and writes the entry back with its URL, so the blob leaves Redis
+func migrateInlineItemImage(ctx context.Context, itemPath string, item *ItemsInInventory) {
+	if item.ItemImageURL != "" || item.ItemImageData == "" {
+		return
+	}
+	imageURL, err := storeBase64Image(ctx, item.ItemImageData)
+	if err != nil {
+		fmt.Println("Error storing item image: ", err)
+		return
+	}
+	item.ItemImageURL = imageURL
+	item.ItemImageData = ""
+	itemBytes, err := json.Marshal(item)
+	if err != nil {
+		fmt.Printf("Error marshalling item: %v", err)
+	} else if err := db.RedisClient.HSet(ctx, "items", itemPath, itemBytes).Err(); err != nil {
+		fmt.Printf("error setting value in Redis: %v", err)
+	}
+}
+
 func StripKitDataFromItem(item ItemResponse) ItemResponse {
 	emptyItemOptions := ItemOptions{}
 	item.Coatings = emptyItemOptions
@@ -511,7 +523,7 @@ func createArmoryRowElement(id int, item ItemsInInventory, itemType, equippedPat
 	element := ArmoryRowElements{
 		ID:            id,
 		CorePath:      item.ItemPath,
-		Image:         item.ItemImageData,
+		Image:         item.ItemImageURL,
 		ImagePath:     item.ItemMetaData.Media.Media.MediaUrl.Path,
 		BelongsToCore: getCoreIDFromInventoryItemPath(item.ItemPath),
 		Rarity:        item.ItemMetaData.Quality,
@@ -572,7 +584,7 @@ func createArmoryRowKit(id int, item ItemsInInventory, itemType, equippedPath st
 	element := ArmoryKitRowElements{
 		ID:                  id,
 		CorePath:            item.ItemPath,
-		Image:               item.ItemImageData,
+		Image:               item.ItemImageURL,
 		ImagePath:           item.ItemMetaData.Media.Media.MediaUrl.Path,
 		Rarity:              item.ItemMetaData.Quality,
 		BelongsToCore:       parentCorePath,
@@ -606,11 +618,10 @@ func FetchInventoryItems(gamerInfo requests.GamerInfo, Items Items) Items {
 	makeRequest := func(path string) {
 		// Determine the core based on InventoryItemPath
 		core := getCoreFromInventoryItemPath(path)
-		url := "https://gamecms-hacs.svc.halowaypoint.com/hi/progression/file/" + path
 		currentItemResponse := ItemResponse{}
 
 		// Make API Request to get item data
-		err := makeAPIRequest(gamerInfo.SpartanKey, url, nil, &currentItemResponse)
+		err := haloapi.Default.ProgressionFile(context.Background(), gamerInfo.Credentials(), path, &currentItemResponse)
 		if err != nil {
 			fmt.Println("Error making request for item data: ", err)
 
@@ -620,6 +631,7 @@ func FetchInventoryItems(gamerInfo requests.GamerInfo, Items Items) Items {
 
 		if _, found := skipTitles[title]; found {
 			results <- RewardResult{} // Send an empty result to ensure channel doesn't block
+			return
 		}
 		if err != nil {
 			fmt.Println("Error making request for item data: ", err)
@@ -632,27 +644,20 @@ func FetchInventoryItems(gamerInfo requests.GamerInfo, Items Items) Items {
 		itemImagePath := currentItemResponse.CommonData.Media.Media.MediaUrl.Path
 		fmt.Println("Making request for ", itemImagePath)
 
-		url = "https://gamecms-hacs.svc.halowaypoint.com/hi/images/file/" + itemImagePath
-		rawImageData, err := makeAPIRequestImage(gamerInfo.SpartanKey, url, nil)
-		if err != nil {
-			fmt.Println("Error getting image data: ", err)
-		}
-
-		// Compress the raw image to be stored in the redis database
-		// Images is resized to 140x140, this is done to reduce the size of the response
-		// (Also, the images are displayed at 140x140 on the front end, so it's not a big deal to resize them here)
-		rawImageData, err = compressPNGWithImaging(rawImageData, true, 140, 140)
+		// The armory grid gets the 140x140 variant of the image, the size the cards are displayed at on the front end
+		thumbnail := ImageVariant{Path: itemImagePath, Width: armoryThumbnailSize, Height: armoryThumbnailSize, Format: "png", Exact: true}
+		hash, err := GetImageVariant(context.Background(), gamerInfo.Credentials(), thumbnail)
 
 		if err != nil {
-			fmt.Println("error compressing: ", err)
+			fmt.Println("Error getting image data: ", err)
 			results <- RewardResult{} // Send an empty result to ensure channel doesn't block
 		} else {
 			currentItemResponse.CommonData.CoreTitle = core // Assign Core
-			results <- RewardResult{Path: path, ImageData: rawImageData, Item: currentItemResponse.CommonData, DetailedItem: currentItemResponse}
+			results <- RewardResult{Path: path, ImageURL: imageURL(hash), Item: currentItemResponse.CommonData, DetailedItem: currentItemResponse}
 		}
 	}
 
-	totalPaths := 0
+	var paths []string
 	var filteredRewards []ItemsInInventory
 
 	for _, item := range Items.InventoryItems {
@@ -662,8 +667,7 @@ func FetchInventoryItems(gamerInfo requests.GamerInfo, Items Items) Items {
 				if strings.Contains(item.ItemPath, "Emblem") || item.ItemPath == "" || strings.Contains(item.ItemPath, "002-001-wlv-e781426b") {
 					continue
 				}
-				go makeRequest(item.ItemPath)
-				totalPaths++
+				paths = append(paths, item.ItemPath)
 				filteredRewards = append(filteredRewards, item)
 			}
 		}
@@ -672,6 +676,12 @@ func FetchInventoryItems(gamerInfo requests.GamerInfo, Items Items) Items {
 	// Replace the original InventoryRewards with the filtered list
 	Items.InventoryItems = filteredRewards
 
+	// Run the requests on a bounded pool while the results are collected below
+	totalPaths := len(paths)
+	go haloapi.Default.FanOut(totalPaths, func(i int) {
+		makeRequest(paths[i])
+	})
+
 	// Collect results from the channel and update the InventoryReward structs
 	for i := 0; i < totalPaths; i++ {
 		result := <-results
@@ -695,7 +705,7 @@ func FetchInventoryItems(gamerInfo requests.GamerInfo, Items Items) Items {
 				}
 
 				itemJustImageData := ItemJustImage{
-					ItemImageData: result.ImageData,
+					ItemImageURL: result.ImageURL,
 				}
 				itemBytesJustImageData, err := json.Marshal(itemJustImageData)
 				if err != nil {
@@ -718,20 +728,18 @@ func FetchInventoryItems(gamerInfo requests.GamerInfo, Items Items) Items {
 	return Items
 }
 
-// compressPNGWithImaging optimizes and compresses a PNG image.
-func compressPNGWithImaging(base64PNG string, resize bool, width, height int) (string, error) {
-	// Decode the base64 string to get the raw PNG data
-	pngData, err := base64.StdEncoding.DecodeString(base64PNG)
-	if err != nil {
-		return "", err
-	}
+// pngOptimization is the palette size, minimum quality and dithering images are optimized with
+var pngOptimization = imageopt.ConfigFromEnv()
 
+// compressPNGWithImaging optimizes and compresses a PNG image.
+// An image that can't be optimized is kept as a full color PNG rather than dropped
+func compressPNGWithImaging(pngData []byte, resize bool, width, height int) ([]byte, error) {
 	// Decode PNG data
 	img, _, err := image.Decode(bytes.NewReader(pngData))
 	if err != nil {
 		fmt.Println("Error decoding png")
 
-		return "", err
+		return nil, err
 	}
 
 	// Resize if needed
@@ -739,42 +747,22 @@ func compressPNGWithImaging(base64PNG string, resize bool, width, height int) (s
 		img = imaging.Resize(img, width, height, imaging.Lanczos)
 	}
 
-	// Encode the image to PNG
-	var buf bytes.Buffer
-	if err := png.Encode(&buf, img); err != nil {
-		fmt.Println("Error encoding to png")
-		return "", err
-	}
-
-	optimizedImage, err := optimizePNGWithPngquant(buf.Bytes())
+	optimizedImage, err := encodeOptimizedPNG(img)
 	if err != nil {
-		return "", err
+		fmt.Println("Error encoding to png")
+		return nil, err
 	}
-
-	// Convert back to base64
-	compressedBase64 := base64.StdEncoding.EncodeToString(optimizedImage)
-	return compressedBase64, nil
+	return optimizedImage, nil
 }
 
-func optimizePNGWithPngquant(input []byte) ([]byte, error) {
-	// Set up the pngquant command with desired options.
-	// The following example uses "--quality=65-80" for quality settings,
-	cmd := exec.Command("pngquant", "--quality=60-80", "--speed", "1", "--floyd=0.5 ", "--force", "--output", "-", "-")
-
-	// Provide the input image data.
-	cmd.Stdin = bytes.NewReader(input)
-
-	// Capture the output.
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	// Run the command.
-	if err := cmd.Run(); err != nil {
-		return nil, err
+// encodeOptimizedPNG encodes the image as an optimized PNG, or a full color one when it can't be optimized
+func encodeOptimizedPNG(img image.Image) ([]byte, error) {
+	optimizedImage, err := imageopt.Optimize(img, pngOptimization)
+	if err == nil {
+		return optimizedImage, nil
 	}
-
-	// Return the optimized image data.
-	return out.Bytes(), nil
+	fmt.Println("Error optimizing png, keeping it in full color: ", err)
+	return imageopt.EncodePNG(img)
 }
 
 func isExcludedItemType(itemType string) bool {
@@ -809,17 +797,13 @@ func isExcludedItemType(itemType string) bool {
 
 func GetInventory(c *gin.Context, gamerInfo requests.GamerInfo) ([]SpartanInventory, error) {
 	fmt.Println("Getting Inventory!")
-	// Headers
-	hdrs := map[string]string{}
-	hdrs["Accept"] = "application/json"
-
-	// Adds Clearance Code from GamerInfo to headers. Required for querying the economy.svc.halowaypoint.com endpoint
-	hdrs["343-clearance"] = gamerInfo.ClearanceCode
+	ctx := c.Request.Context()
+	// The Clearance Code in the credentials is required for querying the economy.svc.halowaypoint.com endpoint
+	creds := gamerInfo.Credentials()
 
 	// Create a structure to hold the response. The response from the endpoint is a list of the players currently equipped items.
 	var inventoryResponse InventoryResponse
-	url := "https://economy.svc.halowaypoint.com/hi/customization?players=xuid(" + gamerInfo.XUID + ")"
-	err := makeAPIRequest(gamerInfo.SpartanKey, url, hdrs, &inventoryResponse)
+	err := haloapi.Default.PlayerCustomization(ctx, creds, gamerInfo.XUID, &inventoryResponse)
 	if err != nil {
 		fmt.Println("Error getting inventory: ", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -834,7 +818,7 @@ func GetInventory(c *gin.Context, gamerInfo requests.GamerInfo) ([]SpartanInvent
 		FetchCoreDetails(&customization.Result, gamerInfo)
 		// Logic for fetching the emblem path and processing emblem data
 		var rawResponse map[string]interface{}
-		if err := makeAPIRequest(gamerInfo.SpartanKey, "https://gamecms-hacs.svc.halowaypoint.com/hi/Waypoint/file/images/emblems/mapping.json", nil, &rawResponse); err != nil {
+		if err := haloapi.Default.WaypointFile(ctx, creds, "images/emblems/mapping.json", &rawResponse); err != nil {
 			c.Error(err)
 			continue // Skip this iteration due to error
 		}
@@ -880,11 +864,11 @@ func GetInventory(c *gin.Context, gamerInfo requests.GamerInfo) ([]SpartanInvent
 			continue // Skip this iteration due to error
 		}
 
-		emblemPngPath := "https://gamecms-hacs.svc.halowaypoint.com/hi/Waypoint/file/" + emblem.EmblemCmsPath
-		nameplatePngPath := "https://gamecms-hacs.svc.halowaypoint.com/hi/Waypoint/file/" + emblem.NameplateCmsPath
+		emblemPngPath := "hi/Waypoint/file/" + emblem.EmblemCmsPath
+		nameplatePngPath := "hi/Waypoint/file/" + emblem.NameplateCmsPath
 
-		emblem.EmblemImageData = FetchImageData(emblemPngPath, gamerInfo)
-		emblem.NameplateImageData = FetchImageData(nameplatePngPath, gamerInfo)
+		emblem.EmblemImageData = FetchImageData(ctx, emblemPngPath, gamerInfo)
+		emblem.NameplateImageData = FetchImageData(ctx, nameplatePngPath, gamerInfo)
 		emblemColors := GetColorPercentages(emblem.NameplateImageData)
 		customization.Result.EmblemInfo = emblem
 		customization.Result.EmblemColors = emblemColors
@@ -901,40 +885,11 @@ func FetchCoreDetails(spartanInventory *SpartanInventory, gamerInfo requests.Gam
 	// Extract CorePath from the first ArmorCore in the list (modify this as needed)
 	corePath := spartanInventory.ArmorCores.ArmorCores[0].CorePath
 
-	// Prepare the URL
-	url := "https://gamecms-hacs.svc.halowaypoint.com/hi/progression/file/" + corePath
-
-	// Initialize headers
-	hdrs := http.Header{}
-	hdrs.Set("X-343-Authorization-Spartan", gamerInfo.SpartanKey)
-
-	// Create a new HTTP client and request
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return
-	}
-
-	// Set the headers for the request
-	req.Header = hdrs
-
-	// Make the request
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error making request:", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading response:", err)
+	var details CoreDetails
+	if err := haloapi.Default.ProgressionFile(context.Background(), gamerInfo.Credentials(), corePath, &details); err != nil {
+		fmt.Println("Error getting core details:", err)
 		return
 	}
-	details := ParseCoreDetails(body)
-	// Add image data to details
-	url = "https://gamecms-hacs.svc.halowaypoint.com/hi/images/file/" + details.CommonData.DisplayPath.Media.MediaUrl.Path
 	spartanInventory.CoreDetails = details
 
 }
@@ -951,25 +906,11 @@ func ParseCoreDetails(responseBody []byte) CoreDetails {
 	return details
 }
 
-func FetchImageData(imageURL string, gamerInfo requests.GamerInfo) []byte {
-	hdrs := http.Header{}
-	hdrs.Set("X-343-Authorization-Spartan", gamerInfo.SpartanKey)
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", imageURL, nil)
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return nil
-	}
-	req.Header = hdrs
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error making request:", err)
-		return nil
-	}
-	defer resp.Body.Close()
-	imgData, err := io.ReadAll(resp.Body)
+// FetchImageData returns a gamecms file by its path relative to the service root (e.g. hi/images/file/...)
+func FetchImageData(ctx context.Context, cmsPath string, gamerInfo requests.GamerInfo) []byte {
+	imgData, err := haloapi.Default.CMSFile(ctx, gamerInfo.Credentials(), cmsPath)
 	if err != nil {
-		fmt.Println("Error reading image data:", err)
+		fmt.Println("Error getting image data:", err)
 		return nil
 	}
 	return imgData
@@ -977,11 +918,10 @@ func FetchImageData(imageURL string, gamerInfo requests.GamerInfo) []byte {
 
 // Loads Armor Cores in from an endpoint and stores them in the database
 func LoadArmorCores(gamerInfo requests.GamerInfo, armorcore string) string {
-	url := "https://gamecms-hacs.svc.halowaypoint.com/hi/progression/file/cores/armorcores/" + armorcore + ".json"
 	currentItemResponse := ItemResponse{}
 
 	// Make API Request to get item data
-	err := makeAPIRequest(gamerInfo.SpartanKey, url, nil, &currentItemResponse)
+	err := haloapi.Default.ProgressionFile(context.Background(), gamerInfo.Credentials(), "cores/armorcores/"+armorcore+".json", &currentItemResponse)
 	if err != nil {
 		fmt.Println("Error making request for item data: ", err)
 	}
@@ -994,11 +934,11 @@ type ItemRequest struct {
 	ImagePath string `json:"ImagePath"`
 }
 
-// Returns the image data from the redis server for a given item
+// Returns the image URL from the redis server for a given item
 func HandleGetItemImage(c *gin.Context) {
 	fmt.Println("in item image!")
 	var item ItemRequest
-	var existingItem ItemsInInventory
+	var existingItem ItemJustImage
 
 	if err := c.ShouldBindJSON(&item); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -1018,7 +958,23 @@ func HandleGetItemImage(c *gin.Context) {
 		fmt.Printf("Error unmarshalling item from Redis: %v", err)
 	}
 
+	// Entries from before the image store still carry the image itself, move it into the store
+	if existingItem.ItemImageURL == "" && existingItem.ItemImageData != "" {
+		imageURL, err := storeBase64Image(ctx, existingItem.ItemImageData)
+		if err != nil {
+			fmt.Println("Error storing item image: ", err)
+		} else {
+			existingItem = ItemJustImage{ItemImageURL: imageURL}
+			itemBytes, err := json.Marshal(existingItem)
+			if err != nil {
+				fmt.Printf("Error marshalling item: %v", err)
+			} else if err := db.RedisClient.HSet(ctx, "items_images", item.ImagePath, itemBytes).Err(); err != nil {
+				fmt.Printf("error setting value in Redis: %v", err)
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"imageData": existingItem.ItemImageData,
+		"imageURL": existingItem.ItemImageURL,
 	})
 }
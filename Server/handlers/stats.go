@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"spartanreport/haloapi"
 	requests "spartanreport/requests"
 	"sync"
 	"time"
@@ -46,11 +47,7 @@ func ParseGamerInfo(data interface{}) (requests.GamerInfo, error) {
 }
 
 func HandleStats(c *gin.Context) {
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+	gamerInfo := GetSessionGamerInfo(c)
 
 	haloStats, err := GetStats(gamerInfo, c)
 	if err != nil {
@@ -58,42 +55,36 @@ func HandleStats(c *gin.Context) {
 		return
 	}
 
-	var wg sync.WaitGroup
-
-	for i := range haloStats.Results {
-		wg.Add(1) // Increment the WaitGroup counter
+	ctx := c.Request.Context()
+	creds := gamerInfo.Credentials()
+	haloapi.Default.FanOut(len(haloStats.Results), func(i int) {
 
-		go func(i int) {
-			defer wg.Done() // Decrement the counter when the goroutine completes
+		matchID := haloStats.Results[i].MatchId
 
-			matchID := haloStats.Results[i].MatchId
+		// Fetch and format MatchInfo
+		fetchedMatch, _ := GetMatchStats(ctx, creds, matchID)
+		formattedMatch := formatMatchStats(ctx, creds, fetchedMatch)          // Assuming formatMatchStats returns Match
+		formattedMatch.MatchInfo = formatMatchTimes(formattedMatch.MatchInfo) // Assuming formatMatchTimes returns MatchInfo
 
-			// Fetch and format MatchInfo
-			fetchedMatch, _ := GetMatchStats(c, gamerInfo.SpartanKey, matchID)
-			formattedMatch := formatMatchStats(gamerInfo.SpartanKey, fetchedMatch) // Assuming formatMatchStats returns Match
-			formattedMatch.MatchInfo = formatMatchTimes(formattedMatch.MatchInfo)  // Assuming formatMatchTimes returns MatchInfo
+		ResolveMatchMedals(ctx, creds, &formattedMatch)
+		haloStats.Results[i].Match = formattedMatch
 
-			haloStats.Results[i].Match = formattedMatch
-
-			// For PlaylistInfo
-			playlistAssetID := haloStats.Results[i].Match.MatchInfo.Playlist.AssetId
-			playlistVersionID := haloStats.Results[i].Match.MatchInfo.Playlist.VersionId
-
-			var playlistInfo PlaylistInfo
-			err := FetchPlaylistDetails(gamerInfo.SpartanKey, playlistAssetID, playlistVersionID, &playlistInfo)
-			if err != nil {
-				fmt.Println("Error fetching playlist details ", err)
-			} else {
-				haloStats.Results[i].Match.MatchInfo.PlaylistInfo = playlistInfo
-			}
-		}(i)
-	}
+		// For PlaylistInfo
+		playlistAssetID := haloStats.Results[i].Match.MatchInfo.Playlist.AssetId
+		playlistVersionID := haloStats.Results[i].Match.MatchInfo.Playlist.VersionId
 
-	wg.Wait() // Wait for all goroutines to complete
+		var playlistInfo PlaylistInfo
+		err := FetchPlaylistDetails(ctx, creds, playlistAssetID, playlistVersionID, &playlistInfo)
+		if err != nil {
+			fmt.Println("Error fetching playlist details ", err)
+		} else {
+			haloStats.Results[i].Match.MatchInfo.PlaylistInfo = playlistInfo
+		}
+	})
 
 	data := TemplateData{
 		HaloStats: haloStats,
-		GamerInfo: gamerInfo,
+		GamerInfo: gamerInfo.WithoutTokens(),
 	}
 
 	c.JSON(http.StatusOK, data)
@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"spartanreport/db"
+	"spartanreport/haloapi"
 	requests "spartanreport/requests"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -80,7 +80,7 @@ type OfferingDetails struct {
 	PriceColorOverrideRGB           *string `json:"PriceColorOverrideRGB"`
 	PriceShadowColorOverrideRGB     *string `json:"PriceShadowColorOverrideRGB"`
 	HasFlair                        bool    `json:"HasFlair"`
-	OfferingImage                   string  `json:"OfferingImage"`
+	OfferingImageURL                string  `json:"OfferingImageURL"`
 }
 
 var storeDataCache *StoreDataCache
@@ -136,9 +136,9 @@ func getNextInvalidateTimeCST() time.Time {
 }
 func HandleStore(c *gin.Context) {
 	expirationTime := getNextInvalidateTimeCST()
-	cacheKey := "storeData:" + expirationTime.Format("2006-01-02")
+	cacheKey := "storeOfferings:" + expirationTime.Format("2006-01-02")
 
-	ctx := context.Background()
+	ctx := c.Request.Context()
 	storeCache := &StoreDataCache{} // Assuming this is now interfacing with Redis
 
 	if cachedData, exists := storeCache.Get(ctx, cacheKey); exists {
@@ -146,11 +146,7 @@ func HandleStore(c *gin.Context) {
 		return
 	}
 
-	var gamerInfo requests.GamerInfo
-	if err := c.ShouldBindJSON(&gamerInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+	gamerInfo := GetSessionGamerInfo(c)
 
 	// Check if gamerInfo is nil or empty and serve from cache
 	if gamerInfo.SpartanKey == "" {
@@ -163,39 +159,26 @@ func HandleStore(c *gin.Context) {
 		}
 	}
 
-	url := "https://economy.svc.halowaypoint.com/hi/players/xuid(" + gamerInfo.XUID + ")/stores/Main"
+	creds := gamerInfo.Credentials()
 	var store StoreData
-	hdrs := map[string]string{}
-	hdrs["343-clearance"] = gamerInfo.ClearanceCode
-
-	makeAPIRequest(gamerInfo.SpartanKey, url, hdrs, &store)
-	basePath := "https://gamecms-hacs.svc.halowaypoint.com/hi/Progression/file/"
-
-	var wg sync.WaitGroup
-	wg.Add(len(store.Offerings))
-
-	for i := range store.Offerings {
-		go func(i int) {
-			defer wg.Done()
-
-			fullPath := basePath + store.Offerings[i].OfferingDisplayPath
-			var offeringDetails OfferingDetails
-			makeAPIRequest(gamerInfo.SpartanKey, fullPath, hdrs, &offeringDetails)
-
-			// Safely update the original Offering object
-			store.Offerings[i].OfferingDetails = offeringDetails
-
-			url := "https://gamecms-hacs.svc.halowaypoint.com/hi/Images/file/" + offeringDetails.ObjectImagePath
-			offeringImage, _ := makeAPIRequestImage(gamerInfo.SpartanKey, url, hdrs)
-			offeringImage, err := compressPNGWithImaging(offeringImage, false, 0, 0)
-			if err != nil {
-				fmt.Println("Error Compressing Store Data: ", err)
-			}
-			store.Offerings[i].OfferingDetails.OfferingImage = offeringImage
-		}(i)
+	if err := haloapi.Default.PlayerStore(ctx, creds, gamerInfo.XUID, &store); err != nil {
+		fmt.Println("Error getting store: ", err)
 	}
 
-	wg.Wait()
+	haloapi.Default.FanOut(len(store.Offerings), func(i int) {
+
+		var offeringDetails OfferingDetails
+		haloapi.Default.ProgressionFile(ctx, creds, store.Offerings[i].OfferingDisplayPath, &offeringDetails)
+
+		// Safely update the original Offering object
+		store.Offerings[i].OfferingDetails = offeringDetails
+
+		offeringImageURL, err := fetchImageURL(ctx, creds, offeringDetails.ObjectImagePath)
+		if err != nil {
+			fmt.Println("Error getting store image: ", err)
+		}
+		store.Offerings[i].OfferingDetails.OfferingImageURL = offeringImageURL
+	})
 	dataToStore := StoreDataToReturn{
 		gamerInfo: requests.GamerInfo{},
 		StoreData: store,
@@ -204,7 +187,7 @@ func HandleStore(c *gin.Context) {
 	storeCache.Set(ctx, cacheKey, dataToStore)
 
 	data := StoreDataToReturn{
-		gamerInfo: gamerInfo,
+		gamerInfo: gamerInfo.WithoutTokens(),
 		StoreData: store,
 	}
 	c.JSON(http.StatusOK, data)
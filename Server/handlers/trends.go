@@ -0,0 +1,217 @@
+package spartanreport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Trend buckets
+const (
+	TrendBucketGame = "game" // Fixed windows of size matches
+	TrendBucketDay  = "day"
+	TrendBucketWeek = "week" // Weeks start on Monday
+)
+
+const (
+	defaultTrendWindow     = 20
+	maxTrendWindow         = 200
+	defaultTrendBucketSize = 10
+	maxTrendBucketSize     = 100
+)
+
+// TrendStats are per match averages, DNFs count as played and not won
+type TrendStats struct {
+	KDA           float64
+	WinRate       float64
+	Kills         float64
+	Deaths        float64
+	Assists       float64
+	PersonalScore float64
+}
+
+// TrendPoint is one bucket of a player's trend.
+// Bucket averages the bucket's own matches, Rolling the last Window matches up to the end of the bucket.
+type TrendPoint struct {
+	Start          time.Time // Start of the day or week, or of the bucket's first match
+	LastMatch      time.Time
+	Matches        int
+	TotalMatches   int // Matches up to and including this bucket
+	Bucket         TrendStats
+	Rolling        TrendStats
+	RollingMatches int // Fewer than Window until enough matches are played
+}
+
+// PerformanceTrend is the response of GET /players/:xuid/trends, points run oldest first
+type PerformanceTrend struct {
+	Bucket string
+	Size   int `json:",omitempty"`
+	Window int
+	Points []TrendPoint
+}
+
+// trendSums holds running totals so any window's averages come from two lookups
+type trendSums struct {
+	wins, kda, kills, deaths, assists, personalScore float64
+}
+
+func (sums trendSums) add(row playerMatchRow) trendSums {
+	if row.Outcome == matchOutcomes["win"] {
+		sums.wins++
+	}
+	sums.kda += row.KDA
+	sums.kills += row.Kills
+	sums.deaths += row.Deaths
+	sums.assists += row.Assists
+	sums.personalScore += row.PersonalScore
+	return sums
+}
+
+// averages of the matches between two running totals
+func (sums trendSums) averagesSince(earlier trendSums, matches int) TrendStats {
+	n := float64(matches)
+	return TrendStats{
+		KDA:           (sums.kda - earlier.kda) / n,
+		WinRate:       (sums.wins - earlier.wins) / n,
+		Kills:         (sums.kills - earlier.kills) / n,
+		Deaths:        (sums.deaths - earlier.deaths) / n,
+		Assists:       (sums.assists - earlier.assists) / n,
+		PersonalScore: (sums.personalScore - earlier.personalScore) / n,
+	}
+}
+
+func trendPeriodStart(t time.Time, bucket string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if bucket == TrendBucketWeek {
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return day
+}
+
+// GetPerformanceTrend reads a player's stored matches oldest first and buckets them.
+// Days and weeks are in UTC and only buckets with matches are returned.
+func GetPerformanceTrend(ctx context.Context, query MatchHistoryQuery, bucket string, size int, window int) (PerformanceTrend, error) {
+	trend := PerformanceTrend{Bucket: bucket, Window: window, Points: []TrendPoint{}}
+	if bucket == TrendBucketGame {
+		trend.Size = size
+	}
+	query.Cursor = ""
+	filter, err := matchHistoryFilter(query)
+	if err != nil {
+		return trend, err
+	}
+
+	rows, err := loadPlayerMatchRows(ctx, filter, query.XUID)
+	if err != nil {
+		return trend, err
+	}
+	trend.Points, err = buildTrendPoints(rows, bucket, size, window)
+	return trend, err
+}
+
+// buildTrendPoints buckets rows that run oldest first
+func buildTrendPoints(rows []playerMatchRow, bucket string, size int, window int) ([]TrendPoint, error) {
+	points := []TrendPoint{}
+
+	// sums[i] totals the first i matches
+	sums := make([]trendSums, len(rows)+1)
+	starts := make([]time.Time, len(rows))
+	for i, row := range rows {
+		sums[i+1] = sums[i].add(row)
+		start, err := time.Parse(time.RFC3339Nano, row.StartTime)
+		if err != nil {
+			return points, fmt.Errorf("Error parsing match start time: %v", err)
+		}
+		starts[i] = start
+	}
+
+	for first := 0; first < len(rows); {
+		end := first + 1
+		start := starts[first]
+		if bucket == TrendBucketGame {
+			end = first + size
+			if end > len(rows) {
+				end = len(rows)
+			}
+		} else {
+			start = trendPeriodStart(starts[first], bucket)
+			for end < len(rows) && trendPeriodStart(starts[end], bucket).Equal(start) {
+				end++
+			}
+		}
+
+		rollingFirst := end - window
+		if rollingFirst < 0 {
+			rollingFirst = 0
+		}
+		points = append(points, TrendPoint{
+			Start:          start,
+			LastMatch:      starts[end-1],
+			Matches:        end - first,
+			TotalMatches:   end,
+			Bucket:         sums[end].averagesSince(sums[first], end-first),
+			Rolling:        sums[end].averagesSince(sums[rollingFirst], end-rollingFirst),
+			RollingMatches: end - rollingFirst,
+		})
+		first = end
+	}
+	return points, nil
+}
+
+// boundedIntQuery reads a positive integer query parameter, capped at max
+func boundedIntQuery(c *gin.Context, name string, fallback int, max int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		return 0, fmt.Errorf("%s must be a positive number", name)
+	}
+	if parsed > max {
+		parsed = max
+	}
+	return parsed, nil
+}
+
+// HandlePerformanceTrend serves GET /players/:xuid/trends.
+// It takes the filters of GET /players/:xuid/matches plus bucket (game, day or week), size (matches per game bucket)
+// and window (matches in the rolling averages).
+func HandlePerformanceTrend(c *gin.Context) {
+	query, err := ParseMatchHistoryQuery(c)
+	if err == nil && c.Query("cursor") != "" {
+		err = errors.New("cursor isn't supported here")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bucket := c.DefaultQuery("bucket", TrendBucketGame)
+	if bucket != TrendBucketGame && bucket != TrendBucketDay && bucket != TrendBucketWeek {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be one of game, day or week"})
+		return
+	}
+	size, err := boundedIntQuery(c, "size", defaultTrendBucketSize, maxTrendBucketSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	window, err := boundedIntQuery(c, "window", defaultTrendWindow, maxTrendWindow)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	trend, err := GetPerformanceTrend(c.Request.Context(), query, bucket, size, window)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, trend)
+}
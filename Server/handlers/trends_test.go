@@ -0,0 +1,143 @@
+package spartanreport
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrendPeriodStart(t *testing.T) {
+	at := func(day, hour int) time.Time { return time.Date(2024, time.March, day, hour, 30, 0, 0, time.UTC) }
+	monday := time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		t      time.Time
+		bucket string
+		want   time.Time
+	}{
+		{"day", at(6, 23), TrendBucketDay, time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC)},
+		{"day is taken in UTC", time.Date(2024, time.March, 6, 23, 30, 0, 0, time.FixedZone("UTC-5", -5*3600)), TrendBucketDay, time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC)},
+		{"Monday starts its own week", at(4, 0), TrendBucketWeek, monday},
+		{"midweek", at(6, 12), TrendBucketWeek, monday},
+		{"Sunday ends the week before", at(10, 23), TrendBucketWeek, monday},
+		{"the next Monday is a new week", at(11, 0), TrendBucketWeek, monday.AddDate(0, 0, 7)},
+		{"week across a month", time.Date(2024, time.March, 2, 8, 0, 0, 0, time.UTC), TrendBucketWeek, time.Date(2024, time.February, 26, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trendPeriodStart(tt.t, tt.bucket); !got.Equal(tt.want) {
+				t.Errorf("trendPeriodStart(%v, %s) = %v, want %v", tt.t, tt.bucket, got, tt.want)
+			}
+		})
+	}
+}
+
+func trendRow(start time.Time, outcome int, kda float64) playerMatchRow {
+	return playerMatchRow{StartTime: start.Format(time.RFC3339Nano), Outcome: outcome, KDA: kda, Kills: kda}
+}
+
+// trendShape is the bucket layout and the bucket and rolling KDA of each point
+type trendShape struct {
+	Matches, TotalMatches, RollingMatches int
+	BucketKDA, RollingKDA                 float64
+}
+
+func trendShapes(points []TrendPoint) []trendShape {
+	shapes := []trendShape{}
+	for _, point := range points {
+		shapes = append(shapes, trendShape{point.Matches, point.TotalMatches, point.RollingMatches, point.Bucket.KDA, point.Rolling.KDA})
+	}
+	return shapes
+}
+
+func TestBuildTrendPoints(t *testing.T) {
+	win, loss := matchOutcomes["win"], matchOutcomes["loss"]
+	hour := func(day, hour int) time.Time { return time.Date(2024, time.March, day, hour, 0, 0, 0, time.UTC) }
+	// Saturday, Sunday twice, Monday, Tuesday
+	rows := []playerMatchRow{
+		trendRow(hour(9, 20), win, 1),
+		trendRow(hour(10, 1), loss, 2),
+		trendRow(hour(10, 23), win, 3),
+		trendRow(hour(11, 0), win, 4),
+		trendRow(hour(12, 12), loss, 5),
+	}
+
+	tests := []struct {
+		name   string
+		bucket string
+		size   int
+		window int
+		want   []trendShape
+	}{
+		{
+			name:   "fixed game buckets, the last one short",
+			bucket: TrendBucketGame, size: 2, window: 3,
+			want: []trendShape{{2, 2, 2, 1.5, 1.5}, {2, 4, 3, 3.5, 3}, {1, 5, 3, 5, 4}},
+		},
+		{
+			name:   "window larger than the matches played",
+			bucket: TrendBucketGame, size: 5, window: 200,
+			want: []trendShape{{5, 5, 5, 3, 3}},
+		},
+		{
+			name:   "days",
+			bucket: TrendBucketDay, size: 10, window: 2,
+			want: []trendShape{{1, 1, 1, 1, 1}, {2, 3, 2, 2.5, 2.5}, {1, 4, 2, 4, 3.5}, {1, 5, 2, 5, 4.5}},
+		},
+		{
+			name:   "weeks keep Sunday with the Saturday before",
+			bucket: TrendBucketWeek, size: 10, window: 4,
+			want: []trendShape{{3, 3, 3, 2, 2}, {2, 5, 4, 4.5, 3.5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := buildTrendPoints(rows, tt.bucket, tt.size, tt.window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := trendShapes(points); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("points = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTrendPointsStats(t *testing.T) {
+	start := time.Date(2024, time.March, 10, 18, 0, 0, 0, time.UTC)
+	rows := []playerMatchRow{
+		trendRow(start, matchOutcomes["win"], 2),
+		trendRow(start.Add(time.Hour), matchOutcomes["dnf"], 0),
+		trendRow(start.Add(2*time.Hour), matchOutcomes["tie"], 1),
+		trendRow(start.Add(3*time.Hour), matchOutcomes["win"], 1),
+	}
+	rows[0].Deaths, rows[0].Assists, rows[0].PersonalScore = 4, 6, 800
+	rows[2].Deaths, rows[2].Assists, rows[2].PersonalScore = 2, 2, 400
+
+	points, err := buildTrendPoints(rows, TrendBucketDay, 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 {
+		t.Fatalf("got %d points, want 1", len(points))
+	}
+	point := points[0]
+	// DNFs and ties count as played and not won
+	want := TrendStats{KDA: 1, WinRate: 0.5, Kills: 1, Deaths: 1.5, Assists: 2, PersonalScore: 300}
+	if point.Bucket != want || point.Rolling != want {
+		t.Errorf("stats = %+v rolling %+v, want %+v", point.Bucket, point.Rolling, want)
+	}
+	if !point.Start.Equal(time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC)) || !point.LastMatch.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("Start = %v, LastMatch = %v", point.Start, point.LastMatch)
+	}
+}
+
+func TestBuildTrendPointsEdges(t *testing.T) {
+	points, err := buildTrendPoints(nil, TrendBucketWeek, 10, 20)
+	if err != nil || points == nil || len(points) != 0 {
+		t.Errorf("no matches = %v, %v, want an empty list", points, err)
+	}
+	if _, err := buildTrendPoints([]playerMatchRow{{StartTime: "yesterday"}}, TrendBucketGame, 10, 20); err == nil {
+		t.Error("buildTrendPoints accepted an unparseable start time")
+	}
+}
@@ -0,0 +1,13 @@
+package spartanreport
+
+import (
+	"net/http"
+	"spartanreport/haloapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleUpstreamStats reports how many upstream Halo calls are queued and in flight
+func HandleUpstreamStats(c *gin.Context) {
+	c.JSON(http.StatusOK, haloapi.Default.Stats())
+}
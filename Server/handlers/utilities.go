@@ -1,77 +1,17 @@
 package spartanreport
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"spartanreport/db"
+	"spartanreport/haloapi"
 	requests "spartanreport/requests"
 )
 
-func makeAPIRequest(spartanToken, url string, hdrs map[string]string, responseStruct interface{}) error {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("Failed to create request: %v", err)
-	}
-	req.Header.Set("X-343-Authorization-Spartan", spartanToken)
-	req.Header.Set("Accept", "application/json")
-
-	for key, value := range hdrs {
-		req.Header.Set(key, value)
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("Failed to make request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("Received a non-OK status code %d. Response body: %s url: %s, headers: %s", resp.StatusCode, string(bodyBytes), url, hdrs)
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("Failed to read response body: %v", err)
-	}
-
-	if err := json.Unmarshal(body, responseStruct); err != nil {
-		return fmt.Errorf("Failed to parse JSON response: %v", err)
-	}
-
-	return nil
-}
-
-func makeAPIRequestImage(spartanToken, url string, hdrs map[string]string) (string, error) {
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("x-343-authorization-spartan", spartanToken)
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	base64ImageData := base64.StdEncoding.EncodeToString(data)
-	return base64ImageData, nil
-}
-
 // Define a struct that matches the JSON structure
 type Customization struct {
 	IsEquipped bool        `json:"IsEquipped"`
@@ -108,79 +48,24 @@ func ChangeCurrentArmor(gamerInfo requests.GamerInfo, customizationData Customiz
 	}
 	fmt.Println("Customization Data being passed to ChangeCurrentArmor:")
 	fmt.Println(string(jsonCustomizationData))
-	// Marshal the struct into JSON
-	jsonBody, err := json.Marshal(customizationData)
-	if err != nil {
-		panic(err)
-	}
-
-	// Construct the request URL and headers
-	url := "https://economy.svc.halowaypoint.com/hi/players/xuid(" + gamerInfo.XUID + ")/customization/armors/" + customizationData.Themes[0].CoreId + "?flight=" + gamerInfo.ClearanceCode
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(jsonBody))
-	if err != nil {
-		panic(err)
-	}
-
-	// Add headers to the request
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("343-clearance", gamerInfo.ClearanceCode)
-	req.Header.Add("X-343-Authorization-Spartan", gamerInfo.SpartanKey)
-	req.Header.Add("Host", "economy.svc.halowaypoint.com")
-
-	// Send the request using an http.Client
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
 
-	// Read the response body
-	responseBody, err := io.ReadAll(resp.Body)
+	var responseBody json.RawMessage
+	err = haloapi.Default.PutArmorCoreCustomization(context.Background(), gamerInfo.Credentials(), gamerInfo.XUID, customizationData.Themes[0].CoreId, customizationData, &responseBody)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("Response Status:", resp.Status)
 	fmt.Println("Response Body:", string(responseBody))
 	fmt.Println("Changed Armor")
 }
 
 func GetCurrentArmor(gamerInfo requests.GamerInfo, ArmorCoreData ArmorCoreEquip, GetCore bool) Customization {
 
-	// Construct the request URL and headers
-	url := "https://economy.svc.halowaypoint.com/hi/players/xuid(" + gamerInfo.XUID + ")/customization/armors/" + ArmorCoreData.CurrentlyEquipped.Core.CoreId + "?flight=" + gamerInfo.ClearanceCode
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		panic(err)
-	}
-
-	// Add headers to the request
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("343-clearance", gamerInfo.ClearanceCode)
-	req.Header.Add("X-343-Authorization-Spartan", gamerInfo.SpartanKey)
-	req.Header.Add("Host", "economy.svc.halowaypoint.com")
-
-	// Send the request using an http.Client
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
-
-	// Read the response body
-	responseBody, err := io.ReadAll(resp.Body)
-
-	// Instead of printing, unmarshal the responseBody into the Customization struct
 	var customizationData Customization
-	err = json.Unmarshal(responseBody, &customizationData)
+	err := haloapi.Default.ArmorCoreCustomization(context.Background(), gamerInfo.Credentials(), gamerInfo.XUID, ArmorCoreData.CurrentlyEquipped.Core.CoreId, &customizationData)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("You received customization struct: ")
-	fmt.Println(string(responseBody))
+	fmt.Println("You received customization struct: ", customizationData)
 
 	// If there are multiple themes equipped, then the user has an armor kit equipped
 	// Remove the non-armor kit from the array so it's just the armor kit that remains
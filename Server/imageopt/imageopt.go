@@ -0,0 +1,121 @@
+// Package imageopt shrinks PNGs in process by reducing them to a palette, the job pngquant used to do in a subprocess
+package imageopt
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Defaults match the pngquant flags the server used to run with (--quality=60-80 --floyd=0.5)
+const (
+	defaultColors     = 256
+	defaultMinQuality = 60
+	defaultDither     = 0.5
+)
+
+// ErrQualityTooLow is returned when a palette of the configured size can't reach the minimum quality
+var ErrQualityTooLow = errors.New("imageopt: palette can't reach the minimum quality")
+
+// Config sets how hard images are squeezed
+type Config struct {
+	Colors     int     // Palette size, 2 to 256
+	MinQuality int     // 0 to 100 on pngquant's scale, images the palette can't reach it for fail with ErrQualityTooLow
+	Dither     float64 // Floyd-Steinberg dithering strength, 0 (off) to 1
+}
+
+// DefaultConfig is the Config used when nothing is set
+func DefaultConfig() Config {
+	return Config{Colors: defaultColors, MinQuality: defaultMinQuality, Dither: defaultDither}
+}
+
+// ConfigFromEnv reads the palette size from IMAGE_PALETTE_SIZE, the minimum quality from IMAGE_MIN_QUALITY
+// and the dithering strength from IMAGE_DITHER, falling back to the defaults for anything unset or out of range
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	if colors, err := strconv.Atoi(os.Getenv("IMAGE_PALETTE_SIZE")); err == nil && colors >= 2 && colors <= 256 {
+		cfg.Colors = colors
+	}
+	if quality, err := strconv.Atoi(os.Getenv("IMAGE_MIN_QUALITY")); err == nil && quality >= 0 && quality <= 100 {
+		cfg.MinQuality = quality
+	}
+	if dither, err := strconv.ParseFloat(os.Getenv("IMAGE_DITHER"), 64); err == nil && dither >= 0 && dither <= 1 {
+		cfg.Dither = dither
+	}
+	return cfg
+}
+
+// EncodePNG encodes the image in full color at the best compression
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Optimize reduces the image to a palette of at most cfg.Colors colors and encodes it as a paletted PNG.
+// Images with no more colors than that are stored losslessly.
+func Optimize(img image.Image, cfg Config) ([]byte, error) {
+	if cfg.Colors < 2 || cfg.Colors > 256 {
+		cfg.Colors = defaultColors
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	if rgba.Bounds().Empty() {
+		return EncodePNG(rgba)
+	}
+
+	hist := histogram(rgba)
+	var palette []pixel
+	if len(hist) <= cfg.Colors {
+		palette = make([]pixel, len(hist))
+		for i, entry := range hist {
+			palette[i] = entry.color
+		}
+		cfg.Dither = 0
+	} else {
+		var mse float64
+		palette, mse = buildPalette(hist, cfg.Colors)
+		if mse > qualityToMSE(cfg.MinQuality) {
+			return nil, ErrQualityTooLow
+		}
+	}
+
+	// Transparent entries first, the tRNS chunk only has to cover the palette up to the last of them
+	sortByAlpha(palette)
+	colorPalette := make(color.Palette, len(palette))
+	for i, p := range palette {
+		colorPalette[i] = color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+	}
+	paletted := image.NewPaletted(rgba.Bounds(), colorPalette)
+	remap(rgba, paletted, newNearestIndex(palette), cfg.Dither)
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, paletted); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// qualityToMSE is libimagequant's mapping from a 0-100 quality to the mean squared error it allows,
+// colors being measured from 0 to 1 per channel
+func qualityToMSE(quality int) float64 {
+	if quality <= 0 {
+		return math.Inf(1)
+	}
+	if quality >= 100 {
+		return 0
+	}
+	q := float64(quality)
+	extraLowQualityFudge := math.Max(0, 0.016/(0.001+q)-0.001)
+	return extraLowQualityFudge + 2.5/math.Pow(210+q, 1.2)*(100.1-q)/100
+}
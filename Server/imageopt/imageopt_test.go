@@ -0,0 +1,180 @@
+package imageopt
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func decodePaletted(t *testing.T, data []byte) *image.Paletted {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decoding optimized PNG: %v", err)
+	}
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("optimized PNG decoded as %T, want *image.Paletted", img)
+	}
+	return paletted
+}
+
+// noisyImage has far more colors than a palette holds
+func noisyImage(size int, seed int64) *image.NRGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(256))
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	return img
+}
+
+func TestOptimizeFewColorsIsLossless(t *testing.T) {
+	colors := []color.NRGBA{
+		{R: 255, A: 255},
+		{G: 200, A: 255},
+		{B: 90, A: 255},
+		{R: 10, G: 20, B: 30, A: 255},
+		{R: 255, G: 255, B: 255, A: 128},
+		{},
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 24, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 24; x++ {
+			src.SetNRGBA(x, y, colors[(x+y*7)%len(colors)])
+		}
+	}
+
+	for _, cfg := range []Config{DefaultConfig(), {Colors: len(colors), MinQuality: 100, Dither: 1}} {
+		data, err := Optimize(src, cfg)
+		if err != nil {
+			t.Fatalf("Optimize(%+v): %v", cfg, err)
+		}
+		out := decodePaletted(t, data)
+		for y := 0; y < 24; y++ {
+			for x := 0; x < 24; x++ {
+				want := color.RGBAModel.Convert(src.At(x, y))
+				if got := color.RGBAModel.Convert(out.At(x, y)); got != want {
+					t.Fatalf("Optimize(%+v) pixel (%d, %d) = %v, want %v", cfg, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestOptimizePaletteSize(t *testing.T) {
+	src := noisyImage(64, 1)
+	for _, colors := range []int{2, 16, 64, 256} {
+		data, err := Optimize(src, Config{Colors: colors, MinQuality: 0, Dither: 0.5})
+		if err != nil {
+			t.Fatalf("Optimize with %d colors: %v", colors, err)
+		}
+		if got := len(decodePaletted(t, data).Palette); got > colors {
+			t.Errorf("palette for %d colors has %d entries", colors, got)
+		}
+	}
+}
+
+func TestOptimizeKeepsTransparentPixelsTransparent(t *testing.T) {
+	src := noisyImage(48, 2)
+	// A transparent frame and diagonal through the noise, carrying leftover color that has to be ignored
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 48; x++ {
+			if x < 4 || y < 4 || x >= 44 || y >= 44 || x == y {
+				src.Pix[src.PixOffset(x, y)+3] = 0
+			}
+		}
+	}
+
+	for _, dither := range []float64{0, 0.5, 1} {
+		data, err := Optimize(src, Config{Colors: 32, MinQuality: 0, Dither: dither})
+		if err != nil {
+			t.Fatalf("Optimize with dither %v: %v", dither, err)
+		}
+		out := decodePaletted(t, data)
+		for y := 0; y < 48; y++ {
+			for x := 0; x < 48; x++ {
+				if src.NRGBAAt(x, y).A != 0 {
+					continue
+				}
+				if _, _, _, a := out.At(x, y).RGBA(); a != 0 {
+					t.Fatalf("dither %v: transparent pixel (%d, %d) came out with alpha %d", dither, x, y, a>>8)
+				}
+			}
+		}
+	}
+}
+
+func TestNearestIndexMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	randomPixel := func() pixel {
+		var p pixel
+		p[3] = uint8(rng.Intn(256))
+		for ch := 0; ch < 3; ch++ {
+			p[ch] = uint8(rng.Intn(int(p[3]) + 1))
+		}
+		return p
+	}
+
+	for _, size := range []int{1, 2, 7, 64, 256} {
+		palette := make([]pixel, size)
+		for i := range palette {
+			palette[i] = randomPixel()
+		}
+		nearest := newNearestIndex(palette)
+		for i := 0; i < 2000; i++ {
+			c := randomPixel()
+			if i%5 == 0 {
+				// Repeats go through the cache
+				c = palette[rng.Intn(size)]
+			}
+			bestDistance := -1
+			for _, p := range palette {
+				if d := distance(c, p); bestDistance < 0 || d < bestDistance {
+					bestDistance = d
+				}
+			}
+			// Ties may pick any of the closest entries, only the distance has to agree
+			if got := distance(c, palette[nearest.find(c)]); got != bestDistance {
+				t.Fatalf("palette of %d: find(%v) is %d away, the nearest entry is %d away", size, c, got, bestDistance)
+			}
+		}
+	}
+}
+
+func TestQualityToMSE(t *testing.T) {
+	tests := []struct {
+		quality int
+		want    float64
+	}{
+		{-5, math.Inf(1)},
+		{0, math.Inf(1)},
+		{100, 0},
+		{120, 0},
+	}
+	for _, tt := range tests {
+		if got := qualityToMSE(tt.quality); got != tt.want {
+			t.Errorf("qualityToMSE(%d) = %v, want %v", tt.quality, got, tt.want)
+		}
+	}
+	// Higher quality never allows more error
+	for q := 1; q < 100; q++ {
+		if qualityToMSE(q) < qualityToMSE(q+1) {
+			t.Fatalf("qualityToMSE(%d) = %v is below qualityToMSE(%d) = %v", q, qualityToMSE(q), q+1, qualityToMSE(q+1))
+		}
+	}
+}
+
+func TestOptimizeQualityTooLow(t *testing.T) {
+	_, err := Optimize(noisyImage(32, 4), Config{Colors: 16, MinQuality: 100, Dither: 0.5})
+	if err != ErrQualityTooLow {
+		t.Fatalf("Optimize with MinQuality 100 = %v, want ErrQualityTooLow", err)
+	}
+}
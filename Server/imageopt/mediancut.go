@@ -0,0 +1,346 @@
+package imageopt
+
+import (
+	"image"
+	"sort"
+)
+
+// Histograms are kept under maxHistogramColors entries by dropping up to maxHistogramShift bits per channel
+const (
+	maxHistogramColors = 1 << 16
+	maxHistogramShift  = 3
+)
+
+// kMeansPasses is how many times the median cut palette is moved to the mean of the colors nearest each entry
+const kMeansPasses = 2
+
+// pixel is a premultiplied RGBA color, so fully transparent pixels all compare equal whatever their color
+type pixel [4]uint8
+
+type histEntry struct {
+	color pixel
+	count int
+}
+
+// histogram counts the distinct colors of the image. Past maxHistogramColors colors it drops the low bits of
+// every channel until few enough are left, each entry then standing for the mean of the colors merged into it
+func histogram(img *image.RGBA) []histEntry {
+	type bucket struct {
+		sum   [4]int
+		count int
+	}
+	var buckets map[pixel]*bucket
+	for shift := uint(0); shift <= maxHistogramShift; shift++ {
+		buckets = map[pixel]*bucket{}
+		tooMany := false
+		for y := 0; y < img.Rect.Dy() && !tooMany; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+img.Rect.Dx()*4]
+			for x := 0; x < len(row); x += 4 {
+				key := pixel{row[x] >> shift, row[x+1] >> shift, row[x+2] >> shift, row[x+3] >> shift}
+				b := buckets[key]
+				if b == nil {
+					b = &bucket{}
+					buckets[key] = b
+				}
+				for ch := 0; ch < 4; ch++ {
+					b.sum[ch] += int(row[x+ch])
+				}
+				b.count++
+			}
+			tooMany = len(buckets) > maxHistogramColors && shift < maxHistogramShift
+		}
+		if !tooMany {
+			break
+		}
+	}
+
+	hist := make([]histEntry, 0, len(buckets))
+	for _, b := range buckets {
+		var c pixel
+		for ch := 0; ch < 4; ch++ {
+			c[ch] = uint8((b.sum[ch] + b.count/2) / b.count)
+		}
+		hist = append(hist, histEntry{color: c, count: b.count})
+	}
+	return hist
+}
+
+// colorBox is a run of the histogram the median cut treats as one palette entry
+type colorBox struct {
+	entries  []histEntry
+	count    int
+	mean     [4]float64
+	variance [4]float64 // Count weighted, per channel
+}
+
+func newColorBox(entries []histEntry) colorBox {
+	box := colorBox{entries: entries}
+	var sum, sumSquares [4]float64
+	for _, entry := range entries {
+		n := float64(entry.count)
+		box.count += entry.count
+		for ch := 0; ch < 4; ch++ {
+			v := float64(entry.color[ch])
+			sum[ch] += v * n
+			sumSquares[ch] += v * v * n
+		}
+	}
+	for ch := 0; ch < 4; ch++ {
+		box.mean[ch] = sum[ch] / float64(box.count)
+		box.variance[ch] = sumSquares[ch] - sum[ch]*box.mean[ch]
+	}
+	return box
+}
+
+func (b colorBox) totalVariance() float64 {
+	return b.variance[0] + b.variance[1] + b.variance[2] + b.variance[3]
+}
+
+// split cuts the box at the weighted median of its widest channel
+func (b colorBox) split() (colorBox, colorBox) {
+	widest := 0
+	for ch := 1; ch < 4; ch++ {
+		if b.variance[ch] > b.variance[widest] {
+			widest = ch
+		}
+	}
+	sort.Slice(b.entries, func(i, j int) bool {
+		return b.entries[i].color[widest] < b.entries[j].color[widest]
+	})
+	cut, seen := 1, b.entries[0].count
+	for cut < len(b.entries)-1 && seen+b.entries[cut].count <= b.count/2 {
+		seen += b.entries[cut].count
+		cut++
+	}
+	return newColorBox(b.entries[:cut]), newColorBox(b.entries[cut:])
+}
+
+// buildPalette picks at most colors entries for the histogram by median cut, refined by k-means.
+// It also returns the mean squared error of mapping each pixel to its nearest entry, channels measured from 0 to 1.
+func buildPalette(hist []histEntry, colors int) ([]pixel, float64) {
+	boxes := []colorBox{newColorBox(hist)}
+	for len(boxes) < colors {
+		// The box holding the most error is split next
+		worst := -1
+		for i, box := range boxes {
+			if len(box.entries) > 1 && (worst < 0 || box.totalVariance() > boxes[worst].totalVariance()) {
+				worst = i
+			}
+		}
+		if worst < 0 {
+			break
+		}
+		a, b := boxes[worst].split()
+		boxes[worst] = a
+		boxes = append(boxes, b)
+	}
+
+	means := make([][4]float64, len(boxes))
+	for i, box := range boxes {
+		means[i] = box.mean
+	}
+	palette := toPixels(means)
+
+	var mse float64
+	for pass := 0; pass <= kMeansPasses; pass++ {
+		nearest := newNearestIndex(palette)
+		sums := make([][4]float64, len(palette))
+		counts := make([]float64, len(palette))
+		var squaredError, total float64
+		for _, entry := range hist {
+			i := nearest.find(entry.color)
+			n := float64(entry.count)
+			squaredError += float64(distance(entry.color, palette[i])) * n
+			total += n
+			for ch := 0; ch < 4; ch++ {
+				sums[i][ch] += float64(entry.color[ch]) * n
+			}
+			counts[i] += n
+		}
+		mse = squaredError / total / (255 * 255)
+		if pass == kMeansPasses {
+			break
+		}
+		for i := range means {
+			if counts[i] > 0 {
+				for ch := 0; ch < 4; ch++ {
+					means[i][ch] = sums[i][ch] / counts[i]
+				}
+			}
+		}
+		palette = toPixels(means)
+	}
+	return palette, mse
+}
+
+func toPixels(means [][4]float64) []pixel {
+	pixels := make([]pixel, len(means))
+	for i, mean := range means {
+		for ch := 0; ch < 4; ch++ {
+			pixels[i][ch] = clampChannel(mean[ch])
+		}
+		// A premultiplied color can't be brighter than its alpha
+		for ch := 0; ch < 3; ch++ {
+			if pixels[i][ch] > pixels[i][3] {
+				pixels[i][ch] = pixels[i][3]
+			}
+		}
+	}
+	return pixels
+}
+
+func clampChannel(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+func distance(a, b pixel) int {
+	d := 0
+	for ch := 0; ch < 4; ch++ {
+		diff := int(a[ch]) - int(b[ch])
+		d += diff * diff
+	}
+	return d
+}
+
+func sortByAlpha(palette []pixel) {
+	sort.SliceStable(palette, func(i, j int) bool {
+		return palette[i][3] < palette[j][3]
+	})
+}
+
+// nearestIndex finds the closest palette entry to a color. Entries are sorted by the sum of their channels,
+// and as (Δsum)² / 4 never exceeds the squared distance the search stops once the sums are too far apart.
+type nearestIndex struct {
+	palette []pixel
+	order   []int // Palette indexes sorted by key
+	keys    []int
+	cache   []cacheSlot // Direct mapped on a hash of the color, a map of every color seen costs more than the searches it saves
+}
+
+type cacheSlot struct {
+	color pixel
+	index int
+	used  bool
+}
+
+const nearestCacheBits = 16
+
+func cacheSlotOf(c pixel) uint32 {
+	packed := uint32(c[0]) | uint32(c[1])<<8 | uint32(c[2])<<16 | uint32(c[3])<<24
+	return (packed * 2654435761) >> (32 - nearestCacheBits)
+}
+
+func channelSum(p pixel) int {
+	return int(p[0]) + int(p[1]) + int(p[2]) + int(p[3])
+}
+
+func newNearestIndex(palette []pixel) *nearestIndex {
+	n := &nearestIndex{palette: palette, order: make([]int, len(palette)), keys: make([]int, len(palette)), cache: make([]cacheSlot, 1<<nearestCacheBits)}
+	for i := range palette {
+		n.order[i] = i
+	}
+	sort.Slice(n.order, func(i, j int) bool {
+		return channelSum(palette[n.order[i]]) < channelSum(palette[n.order[j]])
+	})
+	for i, idx := range n.order {
+		n.keys[i] = channelSum(palette[idx])
+	}
+	return n
+}
+
+func (n *nearestIndex) find(c pixel) int {
+	slot := &n.cache[cacheSlotOf(c)]
+	if slot.used && slot.color == c {
+		return slot.index
+	}
+	key := channelSum(c)
+	start := sort.SearchInts(n.keys, key)
+	best, bestDistance := -1, 0
+	consider := func(i int) {
+		if d := distance(c, n.palette[n.order[i]]); best < 0 || d < bestDistance {
+			best, bestDistance = n.order[i], d
+		}
+	}
+	for lo, hi := start-1, start; lo >= 0 || hi < len(n.order); lo, hi = lo-1, hi+1 {
+		done := true
+		if lo >= 0 {
+			if gap := key - n.keys[lo]; best < 0 || gap*gap < 4*bestDistance {
+				consider(lo)
+				done = false
+			} else {
+				lo = -1
+			}
+		}
+		if hi < len(n.order) {
+			if gap := n.keys[hi] - key; best < 0 || gap*gap < 4*bestDistance {
+				consider(hi)
+				done = false
+			} else {
+				hi = len(n.order)
+			}
+		}
+		if done {
+			break
+		}
+	}
+	*slot = cacheSlot{color: c, index: best, used: true}
+	return best
+}
+
+// remap writes the palette index of every pixel, diffusing dither times the error Floyd-Steinberg style
+func remap(src *image.RGBA, dst *image.Paletted, nearest *nearestIndex, dither float64) {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	if dither <= 0 {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				o := y*src.Stride + x*4
+				dst.Pix[y*dst.Stride+x] = uint8(nearest.find(pixel{src.Pix[o], src.Pix[o+1], src.Pix[o+2], src.Pix[o+3]}))
+			}
+		}
+		return
+	}
+
+	// Error carried into the current and next rows, padded by a pixel on each side
+	current := make([][4]float64, width+2)
+	next := make([][4]float64, width+2)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			o := y*src.Stride + x*4
+			var target pixel
+			var wanted [4]float64
+			for ch := 0; ch < 4; ch++ {
+				wanted[ch] = float64(src.Pix[o+ch]) + current[x+1][ch]
+				target[ch] = clampChannel(wanted[ch])
+			}
+			for ch := 0; ch < 3; ch++ {
+				if target[ch] > target[3] {
+					target[ch] = target[3]
+				}
+			}
+			idx := nearest.find(target)
+			dst.Pix[y*dst.Stride+x] = uint8(idx)
+			// Fully transparent pixels pass no error on, it would only show up as noise around edges
+			if src.Pix[o+3] == 0 {
+				continue
+			}
+			chosen := nearest.palette[idx]
+			for ch := 0; ch < 4; ch++ {
+				e := (wanted[ch] - float64(chosen[ch])) * dither
+				current[x+2][ch] += e * 7 / 16
+				next[x][ch] += e * 3 / 16
+				next[x+1][ch] += e * 5 / 16
+				next[x+2][ch] += e * 1 / 16
+			}
+		}
+		current, next = next, current
+		for i := range next {
+			next[i] = [4]float64{}
+		}
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"log"
 	"os"
 	"spartanreport/db"
+	"spartanreport/haloapi"
+	requests "spartanreport/requests"
+	"strings"
 	"time"
 
 	spartanreport "spartanreport/handlers"
@@ -69,21 +72,84 @@ func main() {
 	}
 	defer db.MongoClient.Disconnect(ctx)
 
-	err = db.CreateIndex("detailed_matches", bson.D{{"MatchId", 1}})
+	err = db.CreateIndex("detailed_matches", bson.D{{Key: "MatchId", Value: 1}})
 	if err != nil {
 		fmt.Println("Error creating index:", err)
 		return
 	}
-	err = db.CreateIndex("item_data", bson.D{{"inventoryitempath", 1}})
-
+	err = db.CreateIndex("item_data", bson.D{{Key: "inventoryitempath", Value: 1}})
+	if err != nil {
+		fmt.Println("Error creating index:", err)
+		return
+	}
+	err = db.CreateIndex("progression_data", bson.D{{Key: "gamerinfo.xuid", Value: 1}})
+	if err != nil {
+		fmt.Println("Error creating index:", err)
+		return
+	}
+	err = spartanreport.CreateMatchHistoryIndexes()
+	if err != nil {
+		fmt.Println("Error creating index:", err)
+		return
+	}
+	err = spartanreport.CreatePlaySessionIndexes()
+	if err != nil {
+		fmt.Println("Error creating index:", err)
+		return
+	}
+	err = spartanreport.CreateCSRIndexes()
+	if err != nil {
+		fmt.Println("Error creating index:", err)
+		return
+	}
+	err = spartanreport.CreateCareerXPIndexes()
 	if err != nil {
 		fmt.Println("Error creating index:", err)
 		return
 	}
+	err = spartanreport.CreateScoringRulesIndexes()
+	if err != nil {
+		fmt.Println("Error creating index:", err)
+		return
+	}
+	err = spartanreport.CreateMedalIndexes()
+	if err != nil {
+		fmt.Println("Error creating index:", err)
+		return
+	}
+	if err := spartanreport.BackfillPlayerOutcomes(ctx); err != nil {
+		fmt.Println("Error backfilling player outcomes:", err)
+	}
+	if err := spartanreport.DropInlineImageCaches(ctx); err != nil {
+		fmt.Println("Error dropping inline image caches:", err)
+	}
+	if err := spartanreport.DropNonDefaultPlaySessions(ctx); err != nil {
+		fmt.Println("Error dropping play sessions of other gaps:", err)
+	}
+	// Upstream calls rejected with 401 get one retry with a token refreshed from the caller's session
+	haloapi.Default.SetTokenRefresher(requests.RefreshSpartanToken)
+
 	InitialBootSetup()
+	// Picks up players left behind by a scoring rules change that didn't finish recomputing
+	go func() {
+		if err := spartanreport.RecomputeAdjustedAverages(context.Background()); err != nil {
+			fmt.Println("Error recomputing adjusted averages:", err)
+		}
+	}()
+	spartanreport.StartProgressionWorkers()
+	spartanreport.StartSeasonRefresher(context.Background())
 	r := gin.Default()
 	r.Use(nrgin.Middleware(app))
-	r.Use(gzip.Gzip(gzip.DefaultCompression))
+	// Server-Sent Events have to reach the browser as they're written, which gzip's buffering would hold back.
+	// Images are already compressed
+	compress := gzip.Gzip(gzip.DefaultCompression)
+	r.Use(func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if path == "/progression/stream" || strings.HasPrefix(path, "/images/") || strings.HasPrefix(path, "/variants/") {
+			return
+		}
+		compress(c)
+	})
 	// Global CORS middleware
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", host)
@@ -100,26 +166,61 @@ func main() {
 	})
 
 	// startAuth is the route that redirects to the authentication page
-	r.POST("/startAuth", spartanreport.HandleAuth)
-	r.POST("/spartan", spartanreport.HandleInventory)
-	r.POST("/customkitcheck", spartanreport.HandleCustomKitCheck)
-	r.POST("/stats", spartanreport.HandleStats)
-	r.POST("/progression", spartanreport.HandleProgression)
-	r.POST("/operations", spartanreport.HandleOperations)
-	r.POST("/operations/:id", spartanreport.HandleOperationDetails)
-	r.POST("/store", spartanreport.HandleStore)
-	r.POST("/ranking", spartanreport.SendRanks)
-	r.POST("/challengedeck", spartanreport.HandleChallengeDeck)
-	r.POST("/match/:id", spartanreport.HandleMatch)
-	r.POST("/armorcore", spartanreport.HandleEquipArmor)
+	r.POST("/startAuth", spartanreport.RequireSameOrigin, spartanreport.HandleAuth)
+	r.POST("/logout", spartanreport.RequireSameOrigin, spartanreport.HandleLogout)
+	r.GET("/auth/login", spartanreport.HandleLogin)
+	r.GET("/auth/callback", spartanreport.HandleAuthCallback)
+	r.POST("/handoff", spartanreport.RequireSameOrigin, spartanreport.HandleRedeemHandoff)
 	r.GET("/home", spartanreport.HandleEventsHome)
-	r.POST("/saveCustomKit", spartanreport.HandleSaveCustomKit)
-	r.POST("/deleteCustomKit", spartanreport.HandleRemoveCustomKit)
-	r.POST("/updateCustomKit", spartanreport.HandleUpdateCustomKit)
-	r.POST("/getCustomKit", spartanreport.HandleGetCustomKit)
 	r.POST("/getItemImage", spartanreport.HandleGetItemImage)
+	r.GET("/images/:hash", spartanreport.HandleImage)
 	r.GET("/.well-known/microsoft-identity-association.json", spartanreport.HandleMSIdentity)
 	r.GET("/customkit/:kitId/:xuid", spartanreport.HandleGetCustomKitById)
+	r.GET("/upstream/stats", spartanreport.RequireAdmin, spartanreport.HandleUpstreamStats)
+
+	// Routes that also serve signed out users
+	public := r.Group("/", spartanreport.OptionalSession)
+	public.GET("/authenticated", spartanreport.HandleAuthenticated)
+	public.POST("/operations", spartanreport.HandleOperations)
+	public.POST("/operations/:id", spartanreport.HandleOperationDetails)
+	public.GET("/events", spartanreport.HandleEvents)
+	public.GET("/events/:id", spartanreport.HandleEventDetails)
+	public.POST("/store", spartanreport.HandleStore)
+	public.GET("/variants/*path", spartanreport.HandleImageVariant)
+
+	// Routes that need the caller's identity, resolved from the session cookie. Writes only come from the front end
+	authenticated := r.Group("/", spartanreport.RequireSameOrigin, spartanreport.RequireSession)
+	authenticated.POST("/spartan", spartanreport.HandleInventory)
+	authenticated.POST("/customkitcheck", spartanreport.HandleCustomKitCheck)
+	authenticated.POST("/stats", spartanreport.HandleStats)
+	authenticated.POST("/progression", spartanreport.HandleProgression)
+	authenticated.GET("/progression", spartanreport.HandleProgressionData)
+	authenticated.GET("/progression/jobs/:id", spartanreport.HandleProgressionJob)
+	authenticated.GET("/progression/stream", spartanreport.HandleProgressionStream)
+	authenticated.GET("/progression/forecast", spartanreport.HandleRankForecast)
+	authenticated.GET("/players/:xuid/matches", spartanreport.HandleMatchHistory)
+	authenticated.GET("/players/:xuid/breakdowns", spartanreport.HandlePerformanceBreakdowns)
+	authenticated.GET("/players/:xuid/trends", spartanreport.HandlePerformanceTrend)
+	authenticated.GET("/players/:xuid/sessions", spartanreport.HandlePlaySessions)
+	authenticated.GET("/players/:xuid/relationships", spartanreport.HandlePlayerRelationships)
+	authenticated.GET("/players/:xuid/csr", spartanreport.HandleCSRHistory)
+	authenticated.POST("/compare", spartanreport.HandleCompare)
+	authenticated.GET("/players/:xuid/medals", spartanreport.HandlePlayerMedals)
+	authenticated.GET("/medals", spartanreport.HandleMedalCatalog)
+	authenticated.POST("/ranking", spartanreport.SendRanks)
+	authenticated.POST("/challengedeck", spartanreport.HandleChallengeDeck)
+	authenticated.POST("/match/:id", spartanreport.HandleMatch)
+	authenticated.POST("/armorcore", spartanreport.RequireJSON, spartanreport.HandleEquipArmor)
+	authenticated.POST("/saveCustomKit", spartanreport.RequireJSON, spartanreport.HandleSaveCustomKit)
+	authenticated.POST("/deleteCustomKit", spartanreport.RequireJSON, spartanreport.HandleRemoveCustomKit)
+	authenticated.POST("/updateCustomKit", spartanreport.RequireJSON, spartanreport.HandleUpdateCustomKit)
+	authenticated.POST("/getCustomKit", spartanreport.HandleGetCustomKit)
+	authenticated.GET("/gamecms/*path", spartanreport.HandleGameCMSImage)
+
+	// Admin routes take the ADMIN_TOKEN bearer token instead of a session
+	admin := r.Group("/admin", spartanreport.RequireAdmin)
+	admin.GET("/scoring-rules", spartanreport.HandleGetScoringRules)
+	admin.PUT("/scoring-rules", spartanreport.HandlePutScoringRules)
 
 	fmt.Println("Server started at :8080")
 	r.Run(":8080")
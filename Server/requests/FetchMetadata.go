@@ -1,10 +1,9 @@
 package spartanreport
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"spartanreport/haloapi"
 	"strings"
 )
 
@@ -13,55 +12,29 @@ type RouteInfo struct {
 	Type  string `json:"type"`
 }
 
-func FetchData(url string, headers http.Header) (interface{}, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header = headers
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		fmt.Printf("Received a non-OK status code %d. Response body: %s\n", resp.StatusCode, string(bodyBytes))
-		return nil, fmt.Errorf("Received a non-OK status code %d", resp.StatusCode)
-	}
-
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
+func FetchData(url string, creds haloapi.Credentials) (interface{}, error) {
 	var jsonData interface{}
-	err = json.Unmarshal(body, &jsonData)
-	if err != nil {
+	if err := haloapi.Default.GetURLJSON(context.Background(), creds, url, &jsonData); err != nil {
 		return nil, err
 	}
 	return jsonData, nil
 }
 
-func ExtractRoutesAndFetch(baseURL string, data interface{}, headers http.Header, routeInfo *[]RouteInfo) {
+func ExtractRoutesAndFetch(baseURL string, data interface{}, creds haloapi.Credentials, routeInfo *[]RouteInfo) {
 	switch v := data.(type) {
 	case map[string]interface{}:
 		for _, val := range v {
-			ExtractRoutesAndFetch(baseURL, val, headers, routeInfo)
+			ExtractRoutesAndFetch(baseURL, val, creds, routeInfo)
 		}
 	case []interface{}:
 		for _, val := range v {
-			ExtractRoutesAndFetch(baseURL, val, headers, routeInfo)
+			ExtractRoutesAndFetch(baseURL, val, creds, routeInfo)
 		}
 	case string:
 		if strings.HasSuffix(v, ".json") || strings.HasSuffix(v, ".png") {
 			// Fetch data for this route and continue exploring.
 			newURL := baseURL + v
-			newData, err := FetchData(newURL, headers)
+			newData, err := FetchData(newURL, creds)
 			if err != nil {
 				fmt.Println("Error fetching data from ", newURL, ":", err)
 				return
@@ -77,7 +50,7 @@ func ExtractRoutesAndFetch(baseURL string, data interface{}, headers http.Header
 			}
 
 			*routeInfo = append(*routeInfo, RouteInfo{Route: newURL, Type: "file"})
-			ExtractRoutesAndFetch(baseURL, newData, headers, routeInfo)
+			ExtractRoutesAndFetch(baseURL, newData, creds, routeInfo)
 		}
 	}
 }
@@ -2,10 +2,12 @@ package spartanreport
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -80,8 +82,7 @@ func RequestUserToken(accessToken string) (*UserTokenResponse, error) {
 	req.Header.Set("x-xbl-contract-version", "1")
 
 	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return userTokenResp, fmt.Errorf("Error executing request: %v", err)
 	}
@@ -102,23 +103,8 @@ func RequestUserToken(accessToken string) (*UserTokenResponse, error) {
 	return userTokenResp, nil
 }
 
-// tempGamerInfoMap for temporary storage
-var tempGamerInfoMap = make(map[string]GamerInfo)
-
-// SetGamerInfo adds gamerInfo to the map
-func SetGamerInfo(token string, gamerInfo GamerInfo) {
-	tempGamerInfoMap[token] = gamerInfo
-}
-
-// GetGamerInfo retrieves and deletes gamerInfo from the map
-func GetGamerInfo(token string) (GamerInfo, bool) {
-	gamerInfo, exists := tempGamerInfoMap[token]
-	if exists {
-		delete(tempGamerInfoMap, token) // Delete after retrieval
-	}
-	return gamerInfo, exists
-}
-
+// ProcessAuthCode signs a user in from an access token acquired by the front end (MSAL).
+// These sessions carry no refresh token, so they end when the Spartan token expires.
 func ProcessAuthCode(code string, c *gin.Context) {
 	userToken, err := RequestUserToken(code)
 	if err != nil {
@@ -134,11 +120,84 @@ func ProcessAuthCode(code string, c *gin.Context) {
 		return
 	}
 
-	gamerInfo, err := RequestUserProfile(SpartanResp.SpartanToken)
+	startSession(c, SpartanResp, RefreshTokenInfo{})
+}
+
+// ProcessAuthorizationCode signs a user in from an OAuth authorization code.
+// The code is exchanged server side, so the refresh token can be kept (encrypted) on the session
+// and the Spartan token renewed without the user logging in again.
+func ProcessAuthorizationCode(authCode string, c *gin.Context) {
+	SpartanResp, refreshInfo, err := ExchangeAuthorizationCode(authCode)
+	if err != nil {
+		fmt.Println("Error exchanging authorization code:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Could not exchange authorization code"})
+		return
+	}
+
+	startSession(c, SpartanResp, refreshInfo)
+}
+
+// ExchangeAuthorizationCode runs the authorization code -> user token -> XSTS -> spartan-token chain.
+// The returned RefreshTokenInfo is already encrypted and empty if Microsoft didn't send a refresh token.
+func ExchangeAuthorizationCode(authCode string) (SpartanTokenResponse, RefreshTokenInfo, error) {
+	var SpartanResp SpartanTokenResponse
+	refreshInfo := RefreshTokenInfo{}
+
+	body := RequestOAuth(os.Getenv("CLIENT_ID"), os.Getenv("CLIENT_SECRET"), os.Getenv("REDIRECT_URI"), authCode)
+	var oauthResp OAuthResponse
+	if err := json.Unmarshal(body, &oauthResp); err != nil || oauthResp.AccessToken == "" {
+		return SpartanResp, refreshInfo, fmt.Errorf("OAuth exchange was rejected: %s", string(body))
+	}
+
+	userToken, err := RequestUserToken(oauthResp.AccessToken)
+	if err != nil {
+		return SpartanResp, refreshInfo, fmt.Errorf("Error with AccessToken: %v", err)
+	}
+
+	err, SpartanResp = RequestXstsToken(*userToken)
+	if err != nil {
+		return SpartanResp, refreshInfo, fmt.Errorf("Error with XSTS Token: %v", err)
+	}
+
+	if oauthResp.RefreshToken != "" {
+		refreshInfo, err = NewRefreshTokenInfo(oauthResp)
+		if err != nil {
+			// Still sign the user in, they'll just have to log in again once the token expires
+			fmt.Println("Error encrypting refresh token:", err)
+		}
+	}
+	return SpartanResp, refreshInfo, nil
+}
+
+// startSession loads the user's profile and hands the browser a session cookie
+func startSession(c *gin.Context, SpartanResp SpartanTokenResponse, refreshInfo RefreshTokenInfo) {
+	sessionID, gamerInfo, err := NewSessionFromSpartanToken(c.Request.Context(), SpartanResp, refreshInfo)
+	if err != nil {
+		fmt.Println("Error creating session: ", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Could not create session"})
+		return
+	}
+	SetSessionCookie(c, sessionID)
+	c.JSON(http.StatusOK, gamerInfo.WithoutTokens())
+}
+
+// NewSessionFromSpartanToken loads the user's profile and stores a new server side session for it
+func NewSessionFromSpartanToken(ctx context.Context, SpartanResp SpartanTokenResponse, refreshInfo RefreshTokenInfo) (string, GamerInfo, error) {
+	gamerInfo, err := RequestUserProfile(ctx, SpartanResp.SpartanToken)
 	if err != nil {
-		fmt.Println("Error when getting user profile: ", err)
+		return "", gamerInfo, fmt.Errorf("Error when getting user profile: %v", err)
+	}
+	gamerInfo.XBLToken = SpartanResp.XBLToken
 
+	// Keep the tokens server side, the browser only gets an opaque session ID
+	session := Session{
+		GamerInfo:           gamerInfo,
+		SpartanTokenExpires: ParseSpartanTokenExpiry(SpartanResp),
+		RefreshToken:        refreshInfo,
+	}
+	sessionID, err := CreateSession(ctx, session)
+	if err != nil {
+		return "", gamerInfo, err
 	}
-	// Send the SpartanToken to the client
-	c.JSON(http.StatusOK, gamerInfo)
+	return sessionID, gamerInfo, nil
 }
@@ -0,0 +1,68 @@
+package spartanreport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"spartanreport/db"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// handoffTTL is how long the client has to redeem a handoff code after the OAuth redirect
+const handoffTTL = 60 * time.Second
+
+// ErrHandoffNotFound is returned for unknown, expired or already redeemed handoff codes
+var ErrHandoffNotFound = errors.New("handoff code not found")
+
+// Handoff is what a one-time code stands for: the session created during the OAuth callback
+type Handoff struct {
+	SessionID string    `json:"sessionId"`
+	GamerInfo GamerInfo `json:"gamerInfo"` // Stored without tokens
+}
+
+func handoffKey(code string) string {
+	return "handoff:" + code
+}
+
+// CreateHandoff stores a handoff in Redis and returns the one-time code that redeems it
+func CreateHandoff(ctx context.Context, sessionID string, gamerInfo GamerInfo) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Error generating handoff code: %v", err)
+	}
+	code := base64.RawURLEncoding.EncodeToString(buf)
+
+	handoffBytes, err := json.Marshal(Handoff{SessionID: sessionID, GamerInfo: gamerInfo.WithoutTokens()})
+	if err != nil {
+		return "", fmt.Errorf("Error marshaling handoff: %v", err)
+	}
+	if err := db.RedisClient.Set(ctx, handoffKey(code), handoffBytes, handoffTTL).Err(); err != nil {
+		return "", fmt.Errorf("Error storing handoff: %v", err)
+	}
+	return code, nil
+}
+
+// RedeemHandoff looks up and deletes a handoff in one GETDEL, so a code can only be used once
+func RedeemHandoff(ctx context.Context, code string) (Handoff, error) {
+	var handoff Handoff
+	if code == "" {
+		return handoff, ErrHandoffNotFound
+	}
+
+	val, err := db.RedisClient.GetDel(ctx, handoffKey(code)).Result()
+	if err == redis.Nil {
+		return handoff, ErrHandoffNotFound
+	} else if err != nil {
+		return handoff, fmt.Errorf("Error redeeming handoff: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(val), &handoff); err != nil {
+		return handoff, fmt.Errorf("Error unmarshaling handoff: %v", err)
+	}
+	return handoff, nil
+}
@@ -0,0 +1,49 @@
+package spartanreport
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OAuthStateCookieName carries the state of the sign in this browser started, the callback only accepts that one
+const OAuthStateCookieName = "oauth_state"
+
+// oauthStateTTL is how long the browser has to get through the Microsoft sign in page
+const oauthStateTTL = 10 * time.Minute
+
+// ErrOAuthStateMismatch is returned when a callback's state isn't the one this browser was sent off with
+var ErrOAuthStateMismatch = errors.New("OAuth state doesn't match the sign in started by this browser")
+
+// setOAuthStateCookie is Lax even over https, the callback is a top level redirect from login.live.com which Lax cookies come along with
+func setOAuthStateCookie(c *gin.Context, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OAuthStateCookieName, state, maxAge, "/auth/", "", secureCookies(), true)
+}
+
+// StartOAuthState generates the state for a new sign in, hands it to the browser and returns it for the authorize URL
+func StartOAuthState(c *gin.Context) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Error generating OAuth state: %v", err)
+	}
+	state := base64.RawURLEncoding.EncodeToString(buf)
+	setOAuthStateCookie(c, state, int(oauthStateTTL.Seconds()))
+	return state, nil
+}
+
+// CheckOAuthState compares a callback's state with the browser's cookie. The cookie is cleared either way, so a state works once.
+func CheckOAuthState(c *gin.Context, state string) error {
+	expected, err := c.Cookie(OAuthStateCookieName)
+	setOAuthStateCookie(c, "", -1)
+	if err != nil || expected == "" || subtle.ConstantTimeCompare([]byte(state), []byte(expected)) != 1 {
+		return ErrOAuthStateMismatch
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package spartanreport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// callbackContext builds a callback request carrying the cookies set on an earlier response
+func callbackContext(cookies []*http.Cookie) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/auth/callback", nil)
+	for _, cookie := range cookies {
+		c.Request.AddCookie(cookie)
+	}
+	return c, w
+}
+
+func TestOAuthState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	login, loginResponse := callbackContext(nil)
+	state, err := StartOAuthState(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := loginResponse.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != OAuthStateCookieName || cookies[0].Value != state || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %+v, want one HttpOnly %s cookie holding the state", cookies, OAuthStateCookieName)
+	}
+
+	other, _ := callbackContext(nil)
+	otherState, _ := StartOAuthState(other)
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		state   string
+		wantErr bool
+	}{
+		{"matching state", cookies, state, false},
+		{"another sign in's state", cookies, otherState, true},
+		{"no state", cookies, "", true},
+		{"no cookie", nil, state, true},
+		{"empty cookie", []*http.Cookie{{Name: OAuthStateCookieName, Value: ""}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := callbackContext(tt.cookies)
+			if err := CheckOAuthState(c, tt.state); (err != nil) != tt.wantErr {
+				t.Errorf("CheckOAuthState = %v, want error %v", err, tt.wantErr)
+			}
+			// The cookie is cleared either way
+			cleared := w.Result().Cookies()
+			if len(cleared) != 1 || cleared[0].Name != OAuthStateCookieName || cleared[0].MaxAge >= 0 {
+				t.Errorf("cookies after the callback = %+v, want %s cleared", cleared, OAuthStateCookieName)
+			}
+		})
+	}
+}
+
+func TestRequestLinkCarriesState(t *testing.T) {
+	link, err := http.NewRequest(http.MethodGet, RequestLink("client", "http://localhost:8080/auth/callback", "abc-123"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := link.URL.Query().Get("state"); got != "abc-123" {
+		t.Errorf("state = %q, want abc-123", got)
+	}
+}
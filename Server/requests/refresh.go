@@ -0,0 +1,213 @@
+package spartanreport
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"spartanreport/db"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// spartanTokenRefreshWindow is how close to ExpiresUtc we start refreshing a session's Spartan token
+const spartanTokenRefreshWindow = 5 * time.Minute
+
+// refreshLockTTL bounds how long one refresh can hold the per session lock
+const refreshLockTTL = 30 * time.Second
+
+// releaseRefreshLock deletes a refresh lock only if it still holds the token it was taken with
+var releaseRefreshLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ErrNoRefreshToken is returned for sessions created without an OAuth refresh token (MSAL sign ins)
+var ErrNoRefreshToken = errors.New("session has no refresh token")
+
+// ParseSpartanTokenExpiry reads the ExpiresUtc deadline off a spartan-token response
+func ParseSpartanTokenExpiry(spartanResp SpartanTokenResponse) time.Time {
+	expires, err := time.Parse(time.RFC3339, spartanResp.ExpiresUtc.ISO8601Date)
+	if err != nil {
+		return time.Time{}
+	}
+	return expires
+}
+
+// NeedsRefresh reports whether the Spartan token is about to expire and can be refreshed
+func (session Session) NeedsRefresh() bool {
+	if session.RefreshToken.RefreshToken == "" || session.SpartanTokenExpires.IsZero() {
+		return false
+	}
+	return time.Until(session.SpartanTokenExpires) < spartanTokenRefreshWindow
+}
+
+// NewRefreshTokenInfo encrypts the refresh token from an OAuth response so it can be stored on the session
+func NewRefreshTokenInfo(oauthResp OAuthResponse) (RefreshTokenInfo, error) {
+	encrypted, err := encryptToken(oauthResp.RefreshToken)
+	if err != nil {
+		return RefreshTokenInfo{}, err
+	}
+	now := time.Now().UTC()
+	return RefreshTokenInfo{
+		RefreshToken:    encrypted,
+		ExpirationData:  now,
+		OAuthExpiration: now.Add(time.Duration(oauthResp.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// Upstream calls only know the Spartan token they were made with, this index maps it back to its session
+func spartanTokenKey(spartanToken string) string {
+	sum := sha256.Sum256([]byte(spartanToken))
+	return "spartantoken:" + hex.EncodeToString(sum[:])
+}
+
+func indexSpartanToken(ctx context.Context, sessionID string, session Session) error {
+	if session.GamerInfo.SpartanKey == "" {
+		return nil
+	}
+	return db.RedisClient.Set(ctx, spartanTokenKey(session.GamerInfo.SpartanKey), sessionID, sessionTTL).Err()
+}
+
+// RefreshSession reruns the refresh token -> user token -> XSTS -> spartan-token chain for a session
+// and stores the new tokens. Concurrent callers for the same session share a single refresh.
+func RefreshSession(ctx context.Context, sessionID string) (Session, error) {
+	session, err := GetSession(ctx, sessionID)
+	if err != nil {
+		return session, err
+	}
+	if session.RefreshToken.RefreshToken == "" {
+		return session, ErrNoRefreshToken
+	}
+
+	lockKey := "session-refresh:" + sessionID
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return session, fmt.Errorf("Error generating refresh lock token: %v", err)
+	}
+	lockToken := hex.EncodeToString(buf)
+	acquired, err := db.RedisClient.SetNX(ctx, lockKey, lockToken, refreshLockTTL).Result()
+	if err != nil {
+		return session, fmt.Errorf("Error acquiring refresh lock: %v", err)
+	}
+	if !acquired {
+		return waitForRefresh(ctx, sessionID, session.GamerInfo.SpartanKey)
+	}
+	// A refresh outliving refreshLockTTL mustn't release the lock another refresh has taken since
+	defer releaseRefreshLock.Run(context.Background(), db.RedisClient, []string{lockKey}, lockToken)
+
+	refreshToken, err := decryptToken(session.RefreshToken.RefreshToken)
+	if err != nil {
+		return session, err
+	}
+
+	oauthResp, spartanResp, err := exchangeRefreshToken(refreshToken)
+	if err != nil {
+		return session, err
+	}
+
+	session.GamerInfo.SpartanKey = spartanResp.SpartanToken
+	session.GamerInfo.XBLToken = spartanResp.XBLToken
+	session.SpartanTokenExpires = ParseSpartanTokenExpiry(spartanResp)
+
+	// Microsoft rotates refresh tokens, keep the newest one
+	if oauthResp.RefreshToken != "" {
+		refreshInfo, err := NewRefreshTokenInfo(oauthResp)
+		if err != nil {
+			return session, err
+		}
+		session.RefreshToken = refreshInfo
+	}
+
+	if err := saveSession(ctx, sessionID, session); err != nil {
+		return session, err
+	}
+	if err := indexSpartanToken(ctx, sessionID, session); err != nil {
+		fmt.Println("Error indexing spartan token: ", err)
+	}
+	fmt.Println("Refreshed Spartan token for", session.GamerInfo.Gamertag)
+	return session, nil
+}
+
+// exchangeRefreshToken runs the refresh token -> user token -> XSTS -> spartan-token chain.
+// The OAuth response carries the rotated refresh token, when Microsoft sent one.
+func exchangeRefreshToken(refreshToken string) (OAuthResponse, SpartanTokenResponse, error) {
+	var oauthResp OAuthResponse
+	var spartanResp SpartanTokenResponse
+	body, err := RequestOAuthWithRefreshToken(os.Getenv("CLIENT_ID"), os.Getenv("CLIENT_SECRET"), os.Getenv("REDIRECT_URI"), refreshToken)
+	if err != nil {
+		return oauthResp, spartanResp, fmt.Errorf("Error refreshing OAuth token: %v", err)
+	}
+	if err := json.Unmarshal(body, &oauthResp); err != nil || oauthResp.AccessToken == "" {
+		return oauthResp, spartanResp, fmt.Errorf("OAuth refresh was rejected: %s", string(body))
+	}
+
+	userToken, err := RequestUserToken(oauthResp.AccessToken)
+	if err != nil {
+		return oauthResp, spartanResp, err
+	}
+	err, spartanResp = RequestXstsToken(*userToken)
+	if err != nil {
+		return oauthResp, spartanResp, err
+	}
+	if spartanResp.SpartanToken == "" {
+		return oauthResp, spartanResp, errors.New("spartan-token response had no token")
+	}
+	return oauthResp, spartanResp, nil
+}
+
+// waitForRefresh polls the session until another request finishes refreshing it
+func waitForRefresh(ctx context.Context, sessionID string, staleToken string) (Session, error) {
+	deadline := time.Now().Add(refreshLockTTL)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return Session{}, ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+		session, err := GetSession(ctx, sessionID)
+		if err != nil {
+			return session, err
+		}
+		if session.GamerInfo.SpartanKey != staleToken {
+			return session, nil
+		}
+	}
+	return Session{}, errors.New("timed out waiting for token refresh")
+}
+
+// RefreshSpartanToken is called when an upstream request is rejected with 401.
+// It finds the session the token belongs to, refreshes it, and returns the new token to retry with.
+func RefreshSpartanToken(ctx context.Context, spartanToken string) (string, error) {
+	if spartanToken == "" {
+		return "", ErrSessionNotFound
+	}
+	sessionID, err := db.RedisClient.Get(ctx, spartanTokenKey(spartanToken)).Result()
+	if err == redis.Nil {
+		return "", ErrSessionNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	session, err := GetSession(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	// Another request already refreshed this session
+	if session.GamerInfo.SpartanKey != spartanToken {
+		return session.GamerInfo.SpartanKey, nil
+	}
+
+	session, err = RefreshSession(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	return session.GamerInfo.SpartanKey, nil
+}
@@ -2,14 +2,15 @@ package spartanreport
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-
-	"github.com/go-resty/resty/v2"
+	"spartanreport/haloapi"
+	"time"
 )
 
 type Properties struct {
@@ -86,9 +87,12 @@ type Seasons struct {
 }
 
 var discoveredRoutes = make(map[string]bool)
-var httpClient = &http.Client{}
 
-func RequestLink(clientID string, redirectURI string) string {
+// httpClient is shared by the Microsoft / Xbox Live auth calls, Halo calls go through haloapi
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// RequestLink builds the Microsoft sign in URL. state comes back on the callback and ties it to the browser that started it
+func RequestLink(clientID string, redirectURI string, state string) string {
 
 	// Base URL for Microsoft OAuth 2.0 Authorization
 	baseURL := "https://login.live.com/oauth20_authorize.srf"
@@ -98,6 +102,7 @@ func RequestLink(clientID string, redirectURI string) string {
 	params.Add("response_type", "code")
 	params.Add("redirect_uri", redirectURI)
 	params.Add("scope", "XboxLive.signin offline_access")
+	params.Add("state", state)
 	// Generate the complete URL
 	authURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
 	return authURL
@@ -118,8 +123,7 @@ func RequestOAuth(clientID string, clientSecret string, redirectURI string, auth
 		return nil
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		fmt.Println("Error executing request:", err)
 		return nil
@@ -148,8 +152,7 @@ func RequestOAuthWithRefreshToken(clientID string, clientSecret string, redirect
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		fmt.Println("Error executing request:", err)
 		return nil, err
@@ -177,8 +180,7 @@ func RequestXstsToken(userTokenResp UserTokenResponse) (error, SpartanTokenRespo
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-xbl-contract-version", "1")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		fmt.Println("Error While Executing Request")
 		return err, SpartanTokenResponse{}
@@ -217,94 +219,34 @@ func requestSpartanToken(xstsToken string) (*SpartanTokenResponse, error) {
 			TokenType: "Xbox_XSTSv3",
 		},
 	}
-	jsonData, err := json.Marshal(reqData)
-	if err != nil {
-		return nil, fmt.Errorf("Error marshaling JSON: %v", err)
-	}
-	req, err := http.NewRequest("POST", "https://settings.svc.halowaypoint.com/spartan-token", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("Error creating request: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "HALO_WAYPOINT_USER_AGENT")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("Error executing request: %v", err)
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("Error reading response body: %v", err)
-	}
 	var spartanTokenResp SpartanTokenResponse
-	err = json.Unmarshal(body, &spartanTokenResp)
-	if err != nil {
-		return nil, fmt.Errorf("Error unmarshaling JSON: %v", err)
+	if err := haloapi.Default.SpartanToken(context.Background(), reqData, &spartanTokenResp); err != nil {
+		return nil, fmt.Errorf("Error requesting spartan token: %v", err)
 	}
 	return &spartanTokenResp, nil
 }
 
-func requestUserClearance(spartanKey string, userID string) (string, error) {
-	// Create a new HTTP client
-	client := &http.Client{}
-
-	editedUserID := "xuid(" + userID + ")"
-	url := "https://settings.svc.halowaypoint.com/oban/flight-configurations/titles/hi/audiences/RETAIL/players/" + editedUserID + "/active?sandbox=UNUSED&build=210921.22.01.10.1706-0"
-
-	// Create the request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("Error creating request: %v", err)
-	}
-
-	// Add the header
-	req.Header.Set("x-343-authorization-spartan", spartanKey)
-
-	// Execute the request
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("Error executing request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Read the response body
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("Error reading response body: %v", err)
-	}
-
+func requestUserClearance(ctx context.Context, spartanKey string, userID string) (string, error) {
 	var clearanceCode struct {
 		FlightConfigurationId string `json:"FlightConfigurationId"`
 	}
-	err = json.Unmarshal(body, &clearanceCode)
+	err := haloapi.Default.FlightConfiguration(ctx, haloapi.Credentials{SpartanToken: spartanKey}, userID, &clearanceCode)
 	if err != nil {
-		return "", fmt.Errorf("Error unmarshaling JSON: %v", err)
+		return "", err
 	}
 
 	return clearanceCode.FlightConfigurationId, nil
-
 }
 
-func RequestUserProfile(spartanKey string) (GamerInfo, error) {
-	client := resty.New()
+func RequestUserProfile(ctx context.Context, spartanKey string) (GamerInfo, error) {
 	gamerInfo := GamerInfo{}
 
-	resp, err := client.R().
-		SetHeader("x-343-authorization-spartan", spartanKey).
-		SetResult(&gamerInfo).
-		Get("https://profile.svc.halowaypoint.com/users/me")
-
-	if err != nil {
+	if err := haloapi.Default.Me(ctx, haloapi.Credentials{SpartanToken: spartanKey}, &gamerInfo); err != nil {
 		return gamerInfo, err
 	}
 
-	if resp.IsError() {
-		return gamerInfo, fmt.Errorf("API request failed with status %v", resp.Status())
-	}
-
 	// Handle additional logic, if needed
-	clearanceCode, err := requestUserClearance(spartanKey, gamerInfo.XUID)
+	clearanceCode, err := requestUserClearance(ctx, spartanKey, gamerInfo.XUID)
 	if err != nil {
 		return gamerInfo, err
 	}
@@ -313,3 +255,8 @@ func RequestUserProfile(spartanKey string) (GamerInfo, error) {
 
 	return gamerInfo, nil
 }
+
+// Credentials are what the haloapi client needs to make calls on behalf of this player
+func (gamerInfo GamerInfo) Credentials() haloapi.Credentials {
+	return haloapi.Credentials{SpartanToken: gamerInfo.SpartanKey, ClearanceCode: gamerInfo.ClearanceCode}
+}
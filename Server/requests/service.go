@@ -0,0 +1,91 @@
+package spartanreport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"spartanreport/db"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// serviceRefreshTokenKey holds the service account's latest refresh token, encrypted. Microsoft rotates them,
+// so once one has been stored it's used over SERVICE_REFRESH_TOKEN
+const serviceRefreshTokenKey = "service:refreshtoken"
+
+// ErrNoServiceAccount is returned when SERVICE_REFRESH_TOKEN isn't set, background jobs needing upstream calls then don't run
+var ErrNoServiceAccount = errors.New("no service account configured")
+
+var (
+	serviceGamerInfo GamerInfo
+	serviceExpires   time.Time
+	serviceMutex     sync.Mutex
+)
+
+// ServiceGamerInfo returns the signed in GamerInfo of the service account background jobs call the upstream as,
+// the account whose OAuth refresh token SERVICE_REFRESH_TOKEN holds. Its Spartan token is kept in memory until it's about to expire.
+func ServiceGamerInfo(ctx context.Context) (GamerInfo, error) {
+	serviceMutex.Lock()
+	defer serviceMutex.Unlock()
+	if serviceGamerInfo.SpartanKey != "" && time.Until(serviceExpires) > spartanTokenRefreshWindow {
+		return serviceGamerInfo, nil
+	}
+
+	candidates, err := serviceRefreshTokens(ctx)
+	if err != nil {
+		return GamerInfo{}, err
+	}
+	var oauthResp OAuthResponse
+	var spartanResp SpartanTokenResponse
+	for _, refreshToken := range candidates {
+		if oauthResp, spartanResp, err = exchangeRefreshToken(refreshToken); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return GamerInfo{}, err
+	}
+	if oauthResp.RefreshToken != "" {
+		refreshInfo, err := NewRefreshTokenInfo(oauthResp)
+		if err != nil {
+			fmt.Println("Error encrypting service refresh token: ", err)
+		} else if err := db.RedisClient.Set(ctx, serviceRefreshTokenKey, refreshInfo.RefreshToken, 0).Err(); err != nil {
+			fmt.Println("Error storing service refresh token: ", err)
+		}
+	}
+
+	gamerInfo, err := RequestUserProfile(ctx, spartanResp.SpartanToken)
+	if err != nil {
+		return GamerInfo{}, fmt.Errorf("Error getting service account profile: %v", err)
+	}
+	gamerInfo.XBLToken = spartanResp.XBLToken
+	serviceGamerInfo = gamerInfo
+	serviceExpires = ParseSpartanTokenExpiry(spartanResp)
+	return serviceGamerInfo, nil
+}
+
+// serviceRefreshTokens lists the refresh tokens to try: the stored one first, then SERVICE_REFRESH_TOKEN
+// in case the stored one was revoked and the variable set to a new one
+func serviceRefreshTokens(ctx context.Context) ([]string, error) {
+	var tokens []string
+	stored, err := db.RedisClient.Get(ctx, serviceRefreshTokenKey).Result()
+	if err == nil {
+		if token, err := decryptToken(stored); err != nil {
+			fmt.Println("Error decrypting service refresh token: ", err)
+		} else {
+			tokens = append(tokens, token)
+		}
+	} else if err != redis.Nil {
+		return nil, err
+	}
+	if token := os.Getenv("SERVICE_REFRESH_TOKEN"); token != "" {
+		tokens = append(tokens, token)
+	}
+	if len(tokens) == 0 {
+		return nil, ErrNoServiceAccount
+	}
+	return tokens, nil
+}
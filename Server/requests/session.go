@@ -0,0 +1,134 @@
+package spartanreport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"spartanreport/db"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// SessionCookieName is the HttpOnly cookie that carries the opaque session ID
+const SessionCookieName = "spartan_session"
+
+// sessionTTL is how long a session survives without being touched
+const sessionTTL = 24 * time.Hour
+
+// ErrSessionNotFound is returned when a session ID is unknown or has expired
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is what's stored in Redis under the session ID.
+// The browser only ever sees the ID, the tokens stay on the server.
+type Session struct {
+	GamerInfo           GamerInfo        `json:"gamerInfo"`
+	CreatedAt           time.Time        `json:"createdAt"`
+	SpartanTokenExpires time.Time        `json:"spartanTokenExpires"`
+	RefreshToken        RefreshTokenInfo `json:"refreshToken"` // RefreshToken.RefreshToken is stored encrypted
+}
+
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func newSessionID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CreateSession stores the session in Redis and returns the new session ID
+func CreateSession(ctx context.Context, session Session) (string, error) {
+	sessionID, err := newSessionID()
+	if err != nil {
+		return "", fmt.Errorf("Error generating session ID: %v", err)
+	}
+
+	session.CreatedAt = time.Now().UTC()
+	if err := saveSession(ctx, sessionID, session); err != nil {
+		return "", err
+	}
+	if err := indexSpartanToken(ctx, sessionID, session); err != nil {
+		fmt.Println("Error indexing spartan token: ", err)
+	}
+	return sessionID, nil
+}
+
+func saveSession(ctx context.Context, sessionID string, session Session) error {
+	sessionBytes, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("Error marshaling session: %v", err)
+	}
+	if err := db.RedisClient.Set(ctx, sessionKey(sessionID), sessionBytes, sessionTTL).Err(); err != nil {
+		return fmt.Errorf("Error storing session: %v", err)
+	}
+	return nil
+}
+
+// GetSession looks up a session and slides its expiry forward
+func GetSession(ctx context.Context, sessionID string) (Session, error) {
+	var session Session
+	if sessionID == "" {
+		return session, ErrSessionNotFound
+	}
+
+	val, err := db.RedisClient.GetEx(ctx, sessionKey(sessionID), sessionTTL).Result()
+	if err == redis.Nil {
+		return session, ErrSessionNotFound
+	} else if err != nil {
+		return session, fmt.Errorf("Error getting session: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(val), &session); err != nil {
+		return session, fmt.Errorf("Error unmarshaling session: %v", err)
+	}
+	return session, nil
+}
+
+// DeleteSession removes a session, used on logout
+func DeleteSession(ctx context.Context, sessionID string) error {
+	return db.RedisClient.Del(ctx, sessionKey(sessionID)).Err()
+}
+
+// WithoutTokens returns a copy of gamerInfo that is safe to hand to the browser or store
+func (gamerInfo GamerInfo) WithoutTokens() GamerInfo {
+	gamerInfo.SpartanKey = ""
+	gamerInfo.XBLToken = ""
+	return gamerInfo
+}
+
+// The front end and the API live on different ports (and domains in production),
+// so the cookie has to be SameSite=None there, which browsers only accept over https.
+func secureCookies() bool {
+	return strings.HasPrefix(os.Getenv("HOST"), "https://")
+}
+
+// SetSessionCookie hands the session ID to the browser in an HttpOnly cookie
+func SetSessionCookie(c *gin.Context, sessionID string) {
+	if secureCookies() {
+		c.SetSameSite(http.SameSiteNoneMode)
+	} else {
+		c.SetSameSite(http.SameSiteLaxMode)
+	}
+	c.SetCookie(SessionCookieName, sessionID, int(sessionTTL.Seconds()), "/", "", secureCookies(), true)
+}
+
+// ClearSessionCookie expires the session cookie in the browser
+func ClearSessionCookie(c *gin.Context) {
+	if secureCookies() {
+		c.SetSameSite(http.SameSiteNoneMode)
+	} else {
+		c.SetSameSite(http.SameSiteLaxMode)
+	}
+	c.SetCookie(SessionCookieName, "", -1, "/", "", secureCookies(), true)
+}
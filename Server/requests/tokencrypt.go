@@ -0,0 +1,88 @@
+package spartanreport
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	tokenCipher     cipher.AEAD
+	tokenCipherErr  error
+	tokenCipherOnce sync.Once
+)
+
+// loadTokenCipher builds the cipher tokens are stored with from TOKEN_ENCRYPTION_KEY
+func loadTokenCipher() (cipher.AEAD, error) {
+	tokenCipherOnce.Do(func() {
+		secret := os.Getenv("TOKEN_ENCRYPTION_KEY")
+		if secret == "" {
+			tokenCipherErr = errors.New("TOKEN_ENCRYPTION_KEY is not set")
+			return
+		}
+		tokenCipher, tokenCipherErr = newTokenCipher(secret)
+	})
+	return tokenCipher, tokenCipherErr
+}
+
+// newTokenCipher builds an AES-GCM cipher from a secret.
+// The secret is hashed so any length of it gives a 256 bit key.
+func newTokenCipher(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptToken seals a token for storage
+func encryptToken(plaintext string) (string, error) {
+	aead, err := loadTokenCipher()
+	if err != nil {
+		return "", err
+	}
+	return sealToken(aead, plaintext)
+}
+
+// decryptToken reverses encryptToken
+func decryptToken(encoded string) (string, error) {
+	aead, err := loadTokenCipher()
+	if err != nil {
+		return "", err
+	}
+	return openToken(aead, encoded)
+}
+
+// sealToken encrypts a token, the nonce is prepended to the ciphertext
+func sealToken(aead cipher.AEAD, plaintext string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("Error generating nonce: %v", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openToken(aead cipher.AEAD, encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("Error decoding token: %v", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted token is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("Error decrypting token: %v", err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,95 @@
+package spartanreport
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestTokenRoundTrip(t *testing.T) {
+	t.Setenv("TOKEN_ENCRYPTION_KEY", "test secret")
+	for _, token := range []string{"", "M.C105_BAY.2.U.refresh-token", strings.Repeat("x", 4096), "ünïcödé"} {
+		encrypted, err := encryptToken(token)
+		if err != nil {
+			t.Fatalf("encryptToken(%q): %v", token, err)
+		}
+		if token != "" && strings.Contains(encrypted, token) {
+			t.Fatalf("encryptToken(%q) leaves the token readable: %q", token, encrypted)
+		}
+		decrypted, err := decryptToken(encrypted)
+		if err != nil {
+			t.Fatalf("decryptToken(encryptToken(%q)): %v", token, err)
+		}
+		if decrypted != token {
+			t.Fatalf("round trip gave %q, want %q", decrypted, token)
+		}
+	}
+
+	// A fresh nonce each time, the same token never encrypts the same way twice
+	first, _ := encryptToken("same token")
+	second, _ := encryptToken("same token")
+	if first == second {
+		t.Error("encrypting the same token twice gave the same ciphertext")
+	}
+}
+
+func TestOpenTokenDetectsTampering(t *testing.T) {
+	aead, err := newTokenCipher("test secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealToken(aead, "refresh-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(i int) string {
+		tampered := append([]byte(nil), raw...)
+		tampered[i] ^= 0x01
+		return base64.StdEncoding.EncodeToString(tampered)
+	}
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"nonce bit flipped", flip(0)},
+		{"ciphertext bit flipped", flip(aead.NonceSize())},
+		{"tag bit flipped", flip(len(raw) - 1)},
+		{"truncated tag", base64.StdEncoding.EncodeToString(raw[:len(raw)-1])},
+		{"shorter than a nonce", base64.StdEncoding.EncodeToString(raw[:aead.NonceSize()-1])},
+		{"empty", ""},
+		{"not base64", "not base64!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if plaintext, err := openToken(aead, tt.encoded); err == nil {
+				t.Errorf("openToken accepted a tampered token, giving %q", plaintext)
+			}
+		})
+	}
+}
+
+func TestOpenTokenRejectsWrongKey(t *testing.T) {
+	aead, err := newTokenCipher("test secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newTokenCipher("another secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealToken(aead, "refresh-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := openToken(other, sealed); err == nil {
+		t.Errorf("openToken with the wrong key gave %q", plaintext)
+	}
+	if plaintext, err := openToken(aead, sealed); err != nil || plaintext != "refresh-token" {
+		t.Errorf("openToken with the right key = %q, %v", plaintext, err)
+	}
+}
@@ -0,0 +1,13 @@
+// Item, reward, season and store images are served by the API from /images/<hash>.
+// Anything else (emblems, rank icons, older cached items) is still base64 image data.
+const apiUrl = process.env.REACT_APP_API_URL || 'http://localhost:8080'; // Fallback URL if the env variable is not set
+
+export default function imageUrl(image) {
+    if (!image) {
+        return null;
+    }
+    if (image.startsWith('/images/')) {
+        return `${apiUrl}${image}`;
+    }
+    return `data:image/png;base64,${image}`;
+}
@@ -7,6 +7,7 @@ import currencyImage from '../credit.png';
 import challengeSwap from '../challengeswap.png';
 import checkmark from "../checkmark.svg"
 import { useNavigate } from 'react-router-dom';
+import imageUrl from "./imageUrl";
 
 
 const ItemDetailsPage = () => {
@@ -25,8 +26,8 @@ const ItemDetailsPage = () => {
         return '';
     }
   };
-  function SeasonImage(base64ImageData){
-    return `data:image/png;base64,${base64ImageData}`;
+  function SeasonImage(imageURL){
+    return imageUrl(imageURL);
 }
   function transformString(str) {
     let mapping = {
@@ -72,7 +73,7 @@ const ItemDetailsPage = () => {
     rewardType = reward.Amount  + "x Swap";
     name = "Challenge Swap";
   } else {
-    imageSrc = SeasonImage(reward.ItemImageData);
+    imageSrc = SeasonImage(reward.ItemImageURL);
     rewardType = transformString(reward.Type);
     name = reward.Item.Title.value
     if (reward.Item.IsCrossCompatible){
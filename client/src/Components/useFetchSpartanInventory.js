@@ -40,19 +40,8 @@ const useFetchSpartanInventory = (gamerInfo, includeArmory = false, setHighlight
       if (response.data.GamerInfo){
         if (storedGamerInfo) {
           localStorage.setItem('isSignedIn', "true");
-          if (response.data.GamerInfo.spartankey === ""){
-            console.log("No spartankey, logging out")
-            const apiUrl = process.env.REACT_APP_API_URL || 'http://localhost:8080';
-            const storedGamerInfo = localStorage.getItem('gamerInfo');
-            const parsedGamerInfo = JSON.parse(storedGamerInfo);
-            await axios.post(`${apiUrl}/logout`, parsedGamerInfo);
-            localStorage.clear();
-            window.location.href = `${apiUrl}/`;
-            return
-    
-            
-          }
-          if (response.data.GamerInfo.spartankey !== parsedGamerInfo.spartankey){
+          // Tokens live in the server side session, an expired session comes back as a 401 and is handled below
+          if (response.data.GamerInfo.xuid !== parsedGamerInfo.xuid){
             console.log("New GamerInfo!")
             localStorage.setItem('gamerInfo', JSON.stringify(response.data.GamerInfo));
           }
@@ -21,6 +21,7 @@ import useAutoRenewToken from '../auth/AuthRenewToken'; // Adjust the path as ne
 import { useAuth } from '../Components/GlobalStateContext'; // Adjust the import path as needed
 import useStartAuth from '../auth/AuthComponent';
 import CustomKit from './CustomKit';
+import axios from 'axios';
 
 
 function App() {
@@ -37,7 +38,7 @@ function App() {
     const storedGamerInfo = localStorage.getItem('gamerInfo');
     if (storedGamerInfo) {
       const parsedGamerInfo = JSON.parse(storedGamerInfo);
-      if (parsedGamerInfo.spartankey === ""){
+      if (!parsedGamerInfo.xuid){
         setIsAuthenticated(false); // Set isAuthenticated to true
 
       }else{
@@ -50,6 +51,26 @@ function App() {
     }
   }, [setIsAuthenticated]);
 
+  useEffect(() => {
+    // Redeem the one-time handoff code the server's OAuth callback redirects back with
+    const params = new URLSearchParams(window.location.search);
+    const handoff = params.get('handoff');
+    if (!handoff) {
+      return;
+    }
+    window.history.replaceState(null, '', window.location.pathname);
+    const apiUrl = process.env.REACT_APP_API_URL || 'http://localhost:8080';
+    axios.post(`${apiUrl}/handoff`, { code: handoff })
+      .then(resp => {
+        setGamerInfo(resp.data);
+        setIsAuthenticated(true);
+      })
+      .catch(error => {
+        console.error('Failed to redeem handoff code:', error);
+        setIsAuthenticated(false);
+      });
+  }, [setIsAuthenticated]);
+
   useEffect(() => {
     // Save gamerInfo to local storage whenever it changes
     if (gamerInfo) {
@@ -339,6 +339,7 @@ const ArmoryRow = ({ visId, objects, fullObjects, resetHighlight, gamerInfo, onE
       const apiUrl = process.env.REACT_APP_API_URL || 'http://localhost:8080';
 
       const response = await fetch(`${apiUrl}/armorcore`, {
+        credentials: 'include',
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
@@ -177,6 +177,7 @@ function CustomKit({gamerInfo, startAuth=useStartAuth}) {
             const apiUrl = process.env.REACT_APP_API_URL || 'http://localhost:8080';
 
             const response = await fetch(`${apiUrl}/armorcore`, {
+                credentials: 'include',
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -1,7 +1,8 @@
 // HighlightedObjectCard is the individual card rendered for each armor piece in the Armory Row when it is highlighted
 import {useEffect, useState} from "react";
 import SvgBorderWrapper from "../Styles/Border";
-import fetchImage from "./ProxyFetchImage";
+import fetchImage, { fetchImageVariant } from "./ProxyFetchImage";
+import imageUrl from "../Components/imageUrl";
 
 /**
  * Renders a highlighted object card component.
@@ -18,24 +19,23 @@ const HighlightedObjectCard = ({ gamerInfo, object, isDisplay }) => {
     useEffect(() => {
         async function loadImage() {
             if (object.Type === "ArmorCore") {
-                const imgSrc = await fetchImage("hi/images/file/" + object.CorePath, gamerInfo.spartankey);
+                const imgSrc = await fetchImage("hi/images/file/" + object.CorePath);
                 if (imgSrc === null || imgSrc === undefined){
                     console.log("is null")
                     return;
                 }
                 setImageSrc(imgSrc);
 
-            }else if (object.ImagePath && gamerInfo.spartankey && isDisplay && object.Type !== "ArmorCore") {
-                let url = "hi/images/file/" + object.ImagePath;
-                const imgSrc = await fetchImage(url, gamerInfo.spartankey);
+            }else if (object.ImagePath && gamerInfo.xuid && isDisplay && object.Type !== "ArmorCore") {
+                const imgSrc = await fetchImageVariant(object.ImagePath, 512, 512);
                 setImageSrc(imgSrc);
             }
             else {
-                setImageSrc(`data:image/png;base64,${object.Image}`);
+                setImageSrc(imageUrl(object.Image));
             }
         }
         loadImage();
-    }, [object.id, object.ImagePath, object.Image, gamerInfo.spartankey, isDisplay]);
+    }, [object.id, object.ImagePath, object.Image, gamerInfo.xuid, isDisplay]);
     if (object.Type === "ArmorKitCustom"){
         return
     }
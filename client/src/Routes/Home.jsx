@@ -3,9 +3,10 @@ import { useEffect, useState } from "react";
 import axios from "axios";
 import { useNavigate, Link } from 'react-router-dom';
 import ReleaseNotesViewer from "./ReleaseNotes";
+import imageUrl from "../Components/imageUrl";
 
-function SeasonImage(base64ImageData){
-  return `data:image/png;base64,${base64ImageData}`;
+function SeasonImage(seasonImageURL){
+  return imageUrl(seasonImageURL);
 }
 const calculateDays = (dateRange) => {
   const today = new Date();
@@ -51,7 +52,7 @@ function DisplayEvent({ season }) {
           <h2 className="event-title-home">{seasonActive ? <span className="live-text">LIVE  </span>: <span className="past-text">PAST  </span>} - <span className="event-date-home">{season.SeasonMetadataDetails.DateRange.value}</span></h2>
       </div>
 
-      <img className="event-image" src={SeasonImage(season.SeasonMetadataDetails.SeasonImage)} alt="Season Logo" />
+      <img className="event-image" src={SeasonImage(season.SeasonMetadataDetails.SeasonImageURL)} alt="Season Logo" />
       <br />
     </div>
   );
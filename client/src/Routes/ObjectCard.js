@@ -2,7 +2,8 @@
 import {useEffect, useRef, useState} from "react";
 import checkmark from '../checkmark.svg';
 import axios from "axios";
-import fetchImage from "./ProxyFetchImage";
+import fetchImage, { fetchImageVariant } from "./ProxyFetchImage";
+import imageUrl from "../Components/imageUrl";
 
 
 
@@ -23,7 +24,7 @@ async function fetchImageFromDB(gamerInfo,path) {
 
         // if path contains Cores or ArmorCore or ArmorCores, fetch from elsewhere
         if (path.includes("Cores") || path.includes("ArmorCore") || path.includes("ArmorCores")) {
-            const imgSrc = await fetchImage("hi/images/file/" + path, gamerInfo.spartankey);
+            const imgSrc = await fetchImage("hi/images/file/" + path);
             return imgSrc;
         }
         let payload = {
@@ -85,7 +86,7 @@ const ObjectCard = ({customStyle, customKitCount, setCustomKitCount, editingObje
                                         if (response === null){
                                             return;
                                         }
-                                        setImageSrc(object.Image ? `data:image/png;base64,${response.imageData}` : null);
+                                        setImageSrc(object.Image ? imageUrl(response.imageURL) : null);
                                     });
                                 }
                             }
@@ -95,8 +96,8 @@ const ObjectCard = ({customStyle, customKitCount, setCustomKitCount, editingObje
                                 if (response === null){
                                     return;
                                 }
-                                object.Image = response.imageData;
-                                setImageSrc(response.imageData);
+                                object.Image = response.imageURL;
+                                setImageSrc(imageUrl(response.imageURL));
                             });
                         }
                         setIsInView(true); // Set state to indicate the object is in view
@@ -139,7 +140,7 @@ const ObjectCard = ({customStyle, customKitCount, setCustomKitCount, editingObje
                         if (response === null ){
                             continue;
                         }
-                        eq.Image = response.imageData; // Update the item's Image with the fetched data
+                        eq.Image = response.imageURL; // Update the item's Image with the fetched data
                     }
                     // Push the updated image and type to the arrays
                     newEquippedImages.push(eq.Image);
@@ -175,15 +176,15 @@ const ObjectCard = ({customStyle, customKitCount, setCustomKitCount, editingObje
                             if (response === null){
                                 return;
                             }
-                            object.Image = response.imageData;
-                            setImageSrc(object.Image ? `data:image/png;base64,${object.Image}` : null);
+                            object.Image = response.imageURL;
+                            setImageSrc(imageUrl(object.Image));
                         });
                     }
                 }
-                setImageSrc(object.Image ? `data:image/png;base64,${object.Image}` : null);
+                setImageSrc(imageUrl(object.Image));
             }
-            else if (object.ImagePath && gamerInfo.spartankey && object.isHighlighted  && object.Type !== "ArmorCore") {
-                const imgSrc = await fetchImage("hi/images/file/" + object.ImagePath, gamerInfo.spartankey);
+            else if (object.ImagePath && gamerInfo.xuid && object.isHighlighted  && object.Type !== "ArmorCore") {
+                const imgSrc = await fetchImageVariant(object.ImagePath, 280, 280);
                 if (object.Type === "ArmorCore"){
                     console.log("checking armor core")
                 }
@@ -193,7 +194,7 @@ const ObjectCard = ({customStyle, customKitCount, setCustomKitCount, editingObje
                 }
                 setImageSrc(imgSrc);
             } else if (object.Type === "ArmorCore") {
-                const imgSrc = await fetchImage("hi/images/file/" + object.CorePath, gamerInfo.spartankey);
+                const imgSrc = await fetchImage("hi/images/file/" + object.CorePath);
                 if (imgSrc === null || imgSrc === undefined){
                     console.log("is null")
                     return;
@@ -201,11 +202,11 @@ const ObjectCard = ({customStyle, customKitCount, setCustomKitCount, editingObje
                 setImageSrc(imgSrc);
 
             }else{
-                setImageSrc(`data:image/png;base64,${object.Image}`);
+                setImageSrc(imageUrl(object.Image));
             }
         }
         loadImage();
-    }, [object, gamerInfo.spartankey, object.Image]);
+    }, [object, gamerInfo.xuid, object.Image]);
 
     // Focus the input field when the card enters edit mode
     useEffect(() => {
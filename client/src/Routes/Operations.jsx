@@ -4,6 +4,7 @@ import '../Styles/operations.css';
 import SelectedOperation from './SelectedOperation';
 import { useNavigate, Link } from 'react-router-dom';
 import LoadingScreen from '../Components/Loading';
+import imageUrl from "../Components/imageUrl";
 
 
 const Operations = ({ gamerInfo }) => {
@@ -33,8 +34,8 @@ const Operations = ({ gamerInfo }) => {
 
 
 
-    function SeasonImage(base64ImageData) {
-      return `data:image/png;base64,${base64ImageData}`;
+    function SeasonImage(seasonImageURL) {
+      return imageUrl(seasonImageURL);
     }
     const getSeasonLink = (season) => {
       let seasonMetadata = season.OperationTrackPath;
@@ -84,7 +85,7 @@ const Operations = ({ gamerInfo }) => {
               </h2>
             </div>
       
-            <img className="event-image" src={SeasonImage(season.SeasonMetadataDetails.SeasonImage)} alt="Season Logo" />
+            <img className="event-image" src={SeasonImage(season.SeasonMetadataDetails.SeasonImageURL)} alt="Season Logo" />
             <br />
           </div>
         );
@@ -15,38 +15,60 @@ const Progression = ({ gamerInfo }) => {
     const [rankImages, setRankImages] = useState([]); // New state variable for rank images
     const [currentRank, setCurrentRank] = useState();
     const [nextRank, setNextRank] = useState();
+    const [syncStatus, setSyncStatus] = useState('');
     
     useEffect(() => {
-      const fetchSpartanInventory = async () => {
-        try {
-          // Use gamerInfo in the Axios POST request
-          const apiUrl = process.env.REACT_APP_API_URL || 'http://localhost:8080'; // Fallback URL if the env variable is not set
-          const response = await axios.post(`${apiUrl}/progression`, gamerInfo);
-          console.log(response.data.AverageDurations)
-          console.log(response.data.careerLadder)
-          console.log(response.data.RankImages)
-
-          setRankImages(response.data.RankImages);
-          setNextRank(response.data.RankImageNext)
-          setCurrentRank(response.data.RankImageCurrent)
-          console.log(response.data); // Log the rank images
+      const apiUrl = process.env.REACT_APP_API_URL || 'http://localhost:8080'; // Fallback URL if the env variable is not set
 
+      const applyProgression = (data) => {
+        setRankImages(data.RankImages);
+        setNextRank(data.RankImageNext)
+        setCurrentRank(data.RankImageCurrent)
+        setCareerTrack(data.CareerTrack)
+        setCareerLadder(data.CareerLadder)
+        setPlaylistMultipliers(data.AdjustedAverages)
+        setPlaylistTimes(data.AverageDurations)
+      };
 
-          setCareerTrack(response.data.CareerTrack)
-          setCareerLadder(response.data.CareerLadder)
-          setPlaylistMultipliers(response.data.AdjustedAverages)
-          setPlaylistTimes(response.data.AverageDurations)
-          
+      // Render what was stored by the last sync straight away, then follow the sync of new matches as it runs
+      const fetchSpartanInventory = async () => {
+        try {
+          const response = await axios.get(`${apiUrl}/progression`);
+          applyProgression(response.data);
         } catch (error) {
           console.error("Error fetching Spartan inventory:", error);
         }
         setIsLoading(false);
-        
       };
-    
+
+      const stream = new EventSource(`${apiUrl}/progression/stream`, { withCredentials: true });
+      stream.addEventListener('status', (event) => {
+        const job = JSON.parse(event.data);
+        if (job.matchesTotal > 0) {
+          setSyncStatus(`${job.phase} (${job.matchesDone}/${job.matchesTotal})`);
+        } else if (job.phase) {
+          setSyncStatus(job.phase);
+        }
+      });
+      stream.addEventListener('aggregates', (event) => {
+        const aggregates = JSON.parse(event.data);
+        setPlaylistMultipliers(aggregates.AdjustedAverages);
+        setPlaylistTimes(aggregates.AverageDurations);
+      });
+      stream.addEventListener('done', () => {
+        setSyncStatus('');
+        stream.close();
+      });
+      stream.addEventListener('failed', (event) => {
+        console.error("Error syncing match history:", JSON.parse(event.data).error);
+        setSyncStatus('');
+        stream.close();
+      });
+
       if (!careerLadder) {
         fetchSpartanInventory();
       }
+      return () => stream.close();
     }, []);
 
 
@@ -142,6 +164,7 @@ const Progression = ({ gamerInfo }) => {
             <div className="card mb-5 playlist-card">
               <div className="card-header">
                 <h1>Averages Per Playlist</h1>
+                {syncStatus && <p className='HeroProgress'>{syncStatus}</p>}
               </div>
               <thead>
                     <tr className='top-icon-bar'>
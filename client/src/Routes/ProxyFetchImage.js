@@ -1,16 +1,26 @@
-async function fetchImage(path, spartankey) {
-    try {
-        if (path === null || path === undefined) {
-            return null;
-        }
-        const proxyBaseUrl = process.env.PROXY_BASE_URL || 'http://localhost:3001/api/';
-        const url = `${proxyBaseUrl}/${path}`;
-        const headers = new Headers();
-        headers.append('X-343-Authorization-Spartan', spartankey);
+async function fetchImage(path) {
+    if (path === null || path === undefined) {
+        return null;
+    }
+    // The API proxies gamecms images using the Spartan token from the session cookie
+    const apiUrl = process.env.REACT_APP_API_URL || 'http://localhost:8080';
+    return fetchImageBlob(`${apiUrl}/gamecms/${path}`);
+}
 
+// fetchImageVariant gets an image under hi/images/file/ scaled down to fit width x height, generated and cached by the API
+export async function fetchImageVariant(path, width, height, format = 'png') {
+    if (path === null || path === undefined || path === "") {
+        return null;
+    }
+    const apiUrl = process.env.REACT_APP_API_URL || 'http://localhost:8080';
+    return fetchImageBlob(`${apiUrl}/variants/${path}?width=${width}&height=${height}&format=${format}`);
+}
+
+async function fetchImageBlob(url) {
+    try {
         const requestOptions = {
             method: 'GET',
-            headers: headers,
+            credentials: 'include',
         };
 
         const response = await fetch(url, requestOptions);
@@ -10,10 +10,11 @@ import checkmark from "../checkmark.svg"
 import { Routes, Route,useParams } from 'react-router-dom';
 import SvgBorderWrapper from '../Styles/Border';
 import LoadingScreen from '../Components/Loading';
+import imageUrl from "../Components/imageUrl";
 
 
-function SeasonImage(base64ImageData) {
-  return `data:image/png;base64,${base64ImageData}`;
+function SeasonImage(imageURL) {
+  return imageUrl(imageURL);
 }
 
 function DisplayEvent({ season }) {
@@ -47,7 +48,7 @@ function DisplayEvent({ season }) {
         </h2>
       </div>
 
-      <img className="event-image" src={SeasonImage(season.SeasonMetadataDetails.SeasonImage)} alt="Season Logo" />
+      <img className="event-image" src={SeasonImage(season.SeasonMetadataDetails.SeasonImageURL)} alt="Season Logo" />
       <br />
     </div>
   );
@@ -89,8 +90,8 @@ function SelectedOperation({ gamerInfo }) {
     adjustTextSize();
   }, [trackData]);
 
-  function SeasonImage(base64ImageData){
-    return `data:image/png;base64,${base64ImageData}`;
+  function SeasonImage(imageURL){
+    return imageUrl(imageURL);
 }
   const getBackgroundStyle = (quality) => {
     switch (quality) {
@@ -176,7 +177,7 @@ const navigate = useNavigate();
 
         name = "Challenge Swap";
       } else {
-        imageSrc = SeasonImage(reward.ItemImageData);
+        imageSrc = SeasonImage(reward.ItemImageURL);
         rewardType = transformString(reward.Type);
         name = reward.Item.Title.value
         if (reward.Item.IsCrossCompatible){
@@ -190,8 +191,8 @@ const navigate = useNavigate();
       const handleItemClick = (reward, gamerInfo, selectedSeason, handleBackClick) => {
         // SeasonImage processing could happen here if needed before passing it along
         // For example, if you need to transform reward item data:
-        if (reward.ItemImageData) {
-          reward.imageSrc = SeasonImage(reward.ItemImageData);
+        if (reward.ItemImageURL) {
+          reward.imageSrc = SeasonImage(reward.ItemImageURL);
         }
       
         // Navigate with all the state you want to pass
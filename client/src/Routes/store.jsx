@@ -4,6 +4,7 @@ import "../Styles/styles.css"
 import "../Styles/store.css"
 import SvgBorderWrapper from '../Styles/Border';
 import LoadingScreen from '../Components/Loading';
+import imageUrl from "../Components/imageUrl";
 
 
 
@@ -38,12 +39,11 @@ const Store = ({ gamerInfo }) => {
 
     const is2x2Tile = offering => offering.OfferingDetails.HeightHint === 2 && offering.OfferingDetails.WidthHint === 2;
     const is1x2Tile = offering => offering.OfferingDetails.HeightHint === 1 && offering.OfferingDetails.WidthHint === 2;
-    function ShopImage(base64ImageData){
-        console.log("base64ImageData: ",base64ImageData)
-        if (base64ImageData === ""){
+    function ShopImage(offeringImageURL){
+        if (offeringImageURL === ""){
             return null;
         }
-        return `data:image/png;base64,${base64ImageData}`;
+        return imageUrl(offeringImageURL);
     }
     const renderOffering = (offering, index, isSpecialOffering=false) => {
         if (offering.OfferingDetails.HeightHint === 1){
@@ -65,7 +65,7 @@ const Store = ({ gamerInfo }) => {
             cardClassName = ``;
 
         }
-        const imageSrc = ShopImage(offering.OfferingDetails.OfferingImage);
+        const imageSrc = ShopImage(offering.OfferingDetails.OfferingImageURL);
         const name = offering.OfferingDetails.Title.value;
         const price = offering.Prices.length > 0 ? `${offering.Prices[0].Cost}` : '';
         if (imageSrc === null){
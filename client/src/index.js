@@ -1,5 +1,6 @@
 import React from 'react';
 import ReactDOM from 'react-dom';
+import axios from 'axios';
 import './Styles/index.css';
 import App from './Routes/App';
 import { MsalProvider } from '@azure/msal-react';
@@ -7,6 +8,9 @@ import { msalInstance } from './auth/authConfig';
 import { CurrentlyEquippedProvider } from './Components/GlobalStateContext';
 await msalInstance.initialize();
 
+// The API identifies the user through an HttpOnly session cookie
+axios.defaults.withCredentials = true;
+
 ReactDOM.render(
   <CurrentlyEquippedProvider>
         <MsalProvider instance={msalInstance}>